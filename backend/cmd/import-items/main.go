@@ -0,0 +1,169 @@
+// import-items lists many items at once as drafts, from a manifest and a zip of their images.
+//
+//	go run ./cmd/import-items -user 1 -password password -manifest items.csv -images images.zip
+//
+// The manifest is a CSV file with a header of name, price, description, category_id and images,
+// where the images of a row are file names in the zip separated by semicolons, or a JSON array of
+// objects with the same fields and images as an array. It needs a running server.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// the statuses of domain.ImportStatus
+const (
+	statusRunning   = 1
+	statusSucceeded = 2
+)
+
+type job struct {
+	ID        int64  `json:"id"`
+	Status    int    `json:"status"`
+	Total     int    `json:"total"`
+	Processed int    `json:"processed"`
+	Error     string `json:"error"`
+	Rows      []struct {
+		Row    int    `json:"row"`
+		ItemID int32  `json:"item_id"`
+		Error  string `json:"error"`
+	} `json:"rows"`
+}
+
+var baseURL string
+
+func main() {
+	flag.StringVar(&baseURL, "url", "http://127.0.0.1:9000", "server url")
+	userID := flag.Int64("user", 0, "id of the seller")
+	password := flag.String("password", "", "password of the seller")
+	manifest := flag.String("manifest", "", "CSV or JSON manifest")
+	images := flag.String("images", "", "zip of the images")
+	interval := flag.Duration("interval", time.Second, "how often the progress is checked")
+	flag.Parse()
+
+	if *userID == 0 || *manifest == "" || *images == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(*userID, *password, *manifest, *images, *interval); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(userID int64, password string, manifest string, images string, interval time.Duration) error {
+	token, err := login(userID, password)
+	if err != nil {
+		return err
+	}
+	jobID, err := upload(token, manifest, images)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("import %d started\n", jobID)
+
+	for {
+		time.Sleep(interval)
+		j, err := getJob(token, jobID)
+		if err != nil {
+			return err
+		}
+		if j.Status == statusRunning {
+			fmt.Printf("checked %d of %d rows\n", j.Processed, j.Total)
+			continue
+		}
+
+		for _, row := range j.Rows {
+			if row.Error != "" {
+				fmt.Printf("row %d: %s\n", row.Row, row.Error)
+			} else {
+				fmt.Printf("row %d: item %d\n", row.Row, row.ItemID)
+			}
+		}
+		if j.Status != statusSucceeded {
+			return fmt.Errorf("import %d failed: %s", jobID, j.Error)
+		}
+		fmt.Printf("import %d created %d items\n", jobID, len(j.Rows))
+		return nil
+	}
+}
+
+func login(userID int64, password string) (string, error) {
+	payload, err := json.Marshal(map[string]any{"user_id": userID, "password": password})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, baseURL+"/login", bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var res struct {
+		Token string `json:"token"`
+	}
+	return res.Token, send(req, http.StatusOK, &res)
+}
+
+func upload(token string, manifest string, images string) (int64, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for field, name := range map[string]string{"manifest": manifest, "images": images} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return -1, err
+		}
+		part, err := w.CreateFormFile(field, filepath.Base(name))
+		if err != nil {
+			return -1, err
+		}
+		part.Write(data)
+	}
+	w.Close()
+
+	req, err := http.NewRequest(http.MethodPost, baseURL+"/items/import", &buf)
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	var res struct {
+		ID int64 `json:"id"`
+	}
+	return res.ID, send(req, http.StatusAccepted, &res)
+}
+
+func getJob(token string, jobID int64) (job, error) {
+	var j job
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/items/import/%d", baseURL, jobID), nil)
+	if err != nil {
+		return j, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return j, send(req, http.StatusOK, &j)
+}
+
+// send decodes the response into res, and fails unless its status is want
+func send(req *http.Request, want int, res any) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != want {
+		return fmt.Errorf("%s %s: %d %s", req.Method, req.URL.Path, resp.StatusCode, body)
+	}
+	return json.Unmarshal(body, res)
+}
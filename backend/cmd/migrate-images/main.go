@@ -0,0 +1,106 @@
+// migrate-images moves the images still stored as blobs in the DB to the image store set by IMAGE_STORE.
+// The images go through the same pipeline as new uploads, which strips their metadata and makes their thumbnails.
+// Blobs the pipeline rejects are moved as they are.
+//
+//	go run ./cmd/migrate-images -vacuum
+//
+// Run it in the backend directory, with the same environment as the server. It can be stopped and run again.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/db"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/imageproc"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/imagestore"
+)
+
+func main() {
+	batch := flag.Int("batch", 100, "number of images read from the DB at once")
+	vacuum := flag.Bool("vacuum", false, "shrink the DB file after moving the images")
+	flag.Parse()
+
+	if err := run(context.Background(), *batch, *vacuum); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, batch int, vacuum bool) error {
+	sqlDB, err := db.PrepareDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	store, err := imagestore.FromEnv()
+	if err != nil {
+		return err
+	}
+	moved, err := moveImages(ctx, db.NewImageRepository(sqlDB), store, batch)
+	if err != nil {
+		return err
+	}
+
+	if vacuum {
+		if _, err := sqlDB.ExecContext(ctx, "VACUUM"); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("done, moved %d images\n", moved)
+	return nil
+}
+
+// moveImages moves the blobs of repo to store in batches, and returns how many it moved
+func moveImages(ctx context.Context, repo db.ImageRepository, store domain.ImageStore, batch int) (int, error) {
+	pipeline := imageproc.NewPipeline()
+	// the size limit is for uploads, images already accepted are not dropped
+	pipeline.MaxBytes = math.MaxInt64
+
+	var moved int
+	for {
+		images, err := repo.GetBlobImages(ctx, batch)
+		if err != nil {
+			return moved, err
+		}
+		if len(images) == 0 {
+			break
+		}
+		for _, img := range images {
+			key, err := imagestore.NewKey()
+			if err != nil {
+				return moved, err
+			}
+			image, err := pipeline.Process(img.Data)
+			if err != nil {
+				fmt.Printf("image %d of item %d is moved as it is: %s\n", img.ID, img.ItemID, err)
+				image = imageproc.Image{Data: img.Data}
+			}
+			if err := store.Put(ctx, key, image.Data); err != nil {
+				return moved, fmt.Errorf("image %d of item %d: %w", img.ID, img.ItemID, err)
+			}
+			if image.Thumbnail != nil {
+				if err := store.Put(ctx, imagestore.ThumbnailKey(key), image.Thumbnail); err != nil {
+					return moved, fmt.Errorf("thumbnail %d of item %d: %w", img.ID, img.ItemID, err)
+				}
+			}
+			if err := repo.MoveImage(ctx, img.ID, key); err != nil {
+				// the image was deleted or moved by someone else meanwhile
+				if err == db.ErrConflict {
+					store.Delete(ctx, key)
+					store.Delete(ctx, imagestore.ThumbnailKey(key))
+					continue
+				}
+				return moved, err
+			}
+			moved++
+		}
+		fmt.Printf("moved %d images\n", moved)
+	}
+	return moved, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/db"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/imagestore"
+)
+
+// failingStore fails the puts after the first ones, like a store which went down during the migration
+type failingStore struct {
+	*imagestore.MemoryStore
+	puts int
+	// keys are the keys put
+	keys []string
+}
+
+func (s *failingStore) Put(ctx context.Context, key string, data []byte) error {
+	if s.puts == 0 {
+		return errors.New("unavailable")
+	}
+	s.puts--
+	s.keys = append(s.keys, key)
+	return s.MemoryStore.Put(ctx, key, data)
+}
+
+// racingRepo moves every image to another key right before the migration does
+type racingRepo struct {
+	db.ImageRepository
+}
+
+func (r racingRepo) MoveImage(ctx context.Context, id int64, key string) error {
+	if err := r.ImageRepository.MoveImage(ctx, id, "other"); err != nil {
+		return err
+	}
+	return r.ImageRepository.MoveImage(ctx, id, key)
+}
+
+// newTestRepo returns the images of an empty DB with blobs, which are no images the pipeline accepts
+func newTestRepo(t *testing.T, blobs ...string) db.ImageRepository {
+	t.Helper()
+	sqlDB, err := db.OpenDB(context.Background(), filepath.Join(t.TempDir(), "test.sqlite3"), filepath.Join("..", "..", "sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	for i, blob := range blobs {
+		if _, err := sqlDB.Exec("INSERT INTO items (id, name, price, description, category_id, seller_id, status) VALUES (?, 'item', 1000, 'description', 1, 1, 2)", i+1); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sqlDB.Exec("INSERT INTO item_images (item_id, position, image) VALUES (?, 0, ?)", i+1, []byte(blob)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db.NewImageRepository(sqlDB)
+}
+
+func TestMoveImagesResumes(t *testing.T) {
+	ctx := context.Background()
+	blobs := []string{"a", "b", "c", "d", "e"}
+	repo := newTestRepo(t, blobs...)
+	store := &failingStore{MemoryStore: imagestore.NewMemoryStore(), puts: 2}
+
+	moved, err := moveImages(ctx, repo, store, 2)
+	if err == nil || moved != 2 {
+		t.Fatalf("moveImages() = %d, %v, want 2 and an error", moved, err)
+	}
+
+	// run again once the store is back
+	store.puts = len(blobs)
+	moved, err = moveImages(ctx, repo, store, 2)
+	if err != nil || moved != 3 {
+		t.Fatalf("moveImages() again = %d, %v, want 3", moved, err)
+	}
+	if len(store.keys) != len(blobs) {
+		t.Errorf("puts = %d, want every image put once", len(store.keys))
+	}
+
+	for i, blob := range blobs {
+		img, err := repo.GetImage(ctx, int32(i+1), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Key == "" || img.Data != nil {
+			t.Errorf("image %d = %+v, want moved", i+1, img)
+			continue
+		}
+		data, err := store.Get(ctx, img.Key)
+		if err != nil || !bytes.Equal(data, []byte(blob)) {
+			t.Errorf("stored image %d = %q, %v, want %q", i+1, data, err, blob)
+		}
+	}
+}
+
+func TestMoveImagesConflict(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepo(t, "a", "b")
+	store := &failingStore{MemoryStore: imagestore.NewMemoryStore(), puts: 10}
+
+	moved, err := moveImages(ctx, racingRepo{repo}, store, 10)
+	if err != nil || moved != 0 {
+		t.Fatalf("moveImages() = %d, %v, want 0", moved, err)
+	}
+
+	// the images of the other migration are kept, the copies of this one deleted
+	for itemID := int32(1); itemID <= 2; itemID++ {
+		img, err := repo.GetImage(ctx, itemID, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Key != "other" {
+			t.Errorf("key of image %d = %q, want other", itemID, img.Key)
+		}
+	}
+	if blobs, err := repo.GetBlobImages(ctx, 10); err != nil || len(blobs) != 0 {
+		t.Errorf("GetBlobImages() = %d, %v, want none left", len(blobs), err)
+	}
+	if len(store.keys) != 2 {
+		t.Errorf("puts = %d, want 2", len(store.keys))
+	}
+	for _, key := range store.keys {
+		if _, err := store.Get(ctx, key); err != domain.ErrImageNotFound {
+			t.Errorf("Get(%q) error = %v, want the copy deleted", key, err)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+// purchase-race checks that an item is sold exactly once when many buyers purchase it at the same time.
+//
+//	go run ./cmd/purchase-race -url http://127.0.0.1:9000 -buyers 50
+//
+// It needs a running server and a category with -category as id.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	imagepkg "image"
+	"image/jpeg"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const price = 1000
+
+var baseURL string
+
+func main() {
+	flag.StringVar(&baseURL, "url", "http://127.0.0.1:9000", "server url")
+	buyers := flag.Int("buyers", 50, "number of concurrent buyers")
+	category := flag.Int("category", 1, "category id of the item")
+	flag.Parse()
+
+	if err := run(*buyers, *category); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(buyers int, category int) error {
+	_, sellerToken, err := newUser("race-seller")
+	if err != nil {
+		return err
+	}
+	itemID, err := addItem(sellerToken, category)
+	if err != nil {
+		return err
+	}
+	if _, err := call(http.MethodPost, "/sell", sellerToken, map[string]any{"item_id": itemID}); err != nil {
+		return err
+	}
+
+	tokens := make([]string, buyers)
+	for i := range tokens {
+		if _, tokens[i], err = newUser(fmt.Sprintf("race-buyer-%d", i)); err != nil {
+			return err
+		}
+		if _, err := call(http.MethodPost, "/balance", tokens[i], map[string]any{"balance": price}); err != nil {
+			return err
+		}
+	}
+
+	// start all purchases at once
+	start := make(chan struct{})
+	statuses := make([]int, buyers)
+	var wg sync.WaitGroup
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			statuses[i], _ = do(http.MethodPost, fmt.Sprintf("/purchase-v2/%d", itemID), tokens[i], nil)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	counts := map[int]int{}
+	charged := 0
+	for i, status := range statuses {
+		counts[status]++
+		balance, err := getBalance(tokens[i])
+		if err != nil {
+			return err
+		}
+		if balance != price {
+			charged++
+		}
+	}
+	fmt.Printf("item %d: responses %v, buyers charged %d\n", itemID, counts, charged)
+
+	if counts[http.StatusOK] != 1 || counts[http.StatusConflict] != buyers-1 || charged != 1 {
+		return fmt.Errorf("want exactly one 200 and %d 409 with one buyer charged", buyers-1)
+	}
+	fmt.Println("ok")
+	return nil
+}
+
+func newUser(name string) (int64, string, error) {
+	body, err := call(http.MethodPost, "/register", "", map[string]any{"name": name, "password": "password"})
+	if err != nil {
+		return -1, "", err
+	}
+	var user struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal(body, &user); err != nil {
+		return -1, "", err
+	}
+
+	body, err = call(http.MethodPost, "/login", "", map[string]any{"user_id": user.ID, "password": "password"})
+	if err != nil {
+		return -1, "", err
+	}
+	var login struct {
+		Token string `json:"token"`
+	}
+	return user.ID, login.Token, json.Unmarshal(body, &login)
+}
+
+func addItem(token string, category int) (int64, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	w.WriteField("name", "race item")
+	w.WriteField("category_id", strconv.Itoa(category))
+	w.WriteField("price", strconv.Itoa(price))
+	w.WriteField("description", "sold exactly once")
+	image, err := w.CreateFormFile("image", "race.jpg")
+	if err != nil {
+		return -1, err
+	}
+	// uploads have to be real images
+	if err := jpeg.Encode(image, imagepkg.NewGray(imagepkg.Rect(0, 0, 1, 1)), nil); err != nil {
+		return -1, err
+	}
+	w.Close()
+
+	req, err := http.NewRequest(http.MethodPost, baseURL+"/items", &buf)
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	_, body, err := send(req)
+	if err != nil {
+		return -1, err
+	}
+	var item struct {
+		ID int64 `json:"id"`
+	}
+	return item.ID, json.Unmarshal(body, &item)
+}
+
+func getBalance(token string) (int64, error) {
+	body, err := call(http.MethodGet, "/balance", token, nil)
+	if err != nil {
+		return -1, err
+	}
+	var res struct {
+		Balance int64 `json:"balance"`
+	}
+	return res.Balance, json.Unmarshal(body, &res)
+}
+
+// call fails unless the response is 200
+func call(method string, path string, token string, payload any) ([]byte, error) {
+	req, err := newRequest(method, path, token, payload)
+	if err != nil {
+		return nil, err
+	}
+	status, body, err := send(req)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %d %s", method, path, status, body)
+	}
+	return body, nil
+}
+
+func do(method string, path string, token string, payload any) (int, error) {
+	req, err := newRequest(method, path, token, payload)
+	if err != nil {
+		return -1, err
+	}
+	status, _, err := send(req)
+	return status, err
+}
+
+func newRequest(method string, path string, token string, payload any) (*http.Request, error) {
+	var body io.Reader
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+func send(req *http.Request) (int, []byte, error) {
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return -1, nil, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	return res.StatusCode, body, err
+}
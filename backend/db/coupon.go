@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrCouponUsedUp = errors.New("coupon has reached its usage limit")
+	ErrCouponExists = errors.New("coupon code already exists")
+)
+
+type CouponRepository interface {
+	// AddCoupon returns ErrCouponExists if the code is taken
+	AddCoupon(ctx context.Context, coupon domain.Coupon) (int64, error)
+	GetCoupons(ctx context.Context) ([]domain.Coupon, error)
+	GetCouponByCodeTx(tx *sql.Tx, ctx context.Context, code string) (domain.Coupon, error)
+	CountRedemptionsTx(tx *sql.Tx, ctx context.Context, couponID int64, userID int64) (int64, error)
+	RedeemTx(tx *sql.Tx, ctx context.Context, coupon domain.Coupon, userID int64, purchaseID int64, discount int64) error
+	UnredeemTx(tx *sql.Tx, ctx context.Context, purchaseID int64) error
+}
+
+type CouponDBRepository struct {
+	*sql.DB
+}
+
+func NewCouponRepository(db *sql.DB) CouponRepository {
+	return &CouponDBRepository{DB: db}
+}
+
+const couponColumns = "id, code, discount_type, discount_value, max_discount, min_price, usage_limit, per_user_limit, used_count, expires_at, created_at"
+
+func scanCoupon(row interface{ Scan(...any) error }) (domain.Coupon, error) {
+	var c domain.Coupon
+	return c, row.Scan(&c.ID, &c.Code, &c.DiscountType, &c.DiscountValue, &c.MaxDiscount, &c.MinPrice, &c.UsageLimit, &c.PerUserLimit, &c.UsedCount, &c.ExpiresAt, &c.CreatedAt)
+}
+
+func (r *CouponDBRepository) AddCoupon(ctx context.Context, coupon domain.Coupon) (int64, error) {
+	row := r.QueryRowContext(ctx, "INSERT INTO coupons (code, discount_type, discount_value, max_discount, min_price, usage_limit, per_user_limit, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?) RETURNING id",
+		coupon.Code, coupon.DiscountType, coupon.DiscountValue, coupon.MaxDiscount, coupon.MinPrice, coupon.UsageLimit, coupon.PerUserLimit, coupon.ExpiresAt)
+	var id int64
+	if err := row.Scan(&id); err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return 0, ErrCouponExists
+		}
+		return 0, err
+	}
+	return id, nil
+}
+
+func (r *CouponDBRepository) GetCoupons(ctx context.Context) ([]domain.Coupon, error) {
+	rows, err := r.QueryContext(ctx, "SELECT "+couponColumns+" FROM coupons ORDER BY id desc")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var coupons []domain.Coupon
+	for rows.Next() {
+		c, err := scanCoupon(rows)
+		if err != nil {
+			return nil, err
+		}
+		coupons = append(coupons, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return coupons, nil
+}
+
+func (r *CouponDBRepository) GetCouponByCodeTx(tx *sql.Tx, ctx context.Context, code string) (domain.Coupon, error) {
+	return scanCoupon(tx.QueryRowContext(ctx, "SELECT "+couponColumns+" FROM coupons WHERE code = ?", code))
+}
+
+func (r *CouponDBRepository) CountRedemptionsTx(tx *sql.Tx, ctx context.Context, couponID int64, userID int64) (int64, error) {
+	row := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM coupon_redemptions WHERE coupon_id = ? AND user_id = ?", couponID, userID)
+	var count int64
+	return count, row.Scan(&count)
+}
+
+// RedeemTx records the use of the coupon. It fails with ErrCouponUsedUp when the usage limit
+// was reached by someone else in the meantime.
+func (r *CouponDBRepository) RedeemTx(tx *sql.Tx, ctx context.Context, coupon domain.Coupon, userID int64, purchaseID int64, discount int64) error {
+	res, err := tx.ExecContext(ctx, "UPDATE coupons SET used_count = used_count + 1 WHERE id = ? AND (usage_limit = 0 OR used_count < usage_limit)", coupon.ID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrCouponUsedUp
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO coupon_redemptions (coupon_id, user_id, purchase_id, discount) VALUES (?, ?, ?, ?)", coupon.ID, userID, purchaseID, discount); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnredeemTx gives back the use of the coupon redeemed by the purchase, if any
+func (r *CouponDBRepository) UnredeemTx(tx *sql.Tx, ctx context.Context, purchaseID int64) error {
+	var couponID int64
+	if err := tx.QueryRowContext(ctx, "DELETE FROM coupon_redemptions WHERE purchase_id = ? RETURNING coupon_id", purchaseID).Scan(&couponID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	_, err := tx.ExecContext(ctx, "UPDATE coupons SET used_count = used_count - 1 WHERE id = ?", couponID)
+	return err
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+func TestAddCouponDuplicateCode(t *testing.T) {
+	ctx := context.Background()
+	repo := NewCouponRepository(newTestDB(t))
+	coupon := domain.Coupon{
+		Code:          "WELCOME",
+		DiscountType:  domain.CouponDiscountAmount,
+		DiscountValue: 100,
+		ExpiresAt:     "2099-01-01 00:00:00",
+	}
+
+	if _, err := repo.AddCoupon(ctx, coupon); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.AddCoupon(ctx, coupon); err != ErrCouponExists {
+		t.Errorf("AddCoupon() of a taken code error = %v, want %v", err, ErrCouponExists)
+	}
+}
+
+func TestUnredeem(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	repo := NewCouponRepository(db)
+	id, err := repo.AddCoupon(ctx, domain.Coupon{Code: "ONCE", DiscountType: domain.CouponDiscountAmount, DiscountValue: 100, UsageLimit: 1, ExpiresAt: "2099-01-01 00:00:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	coupon := domain.Coupon{ID: id}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := repo.RedeemTx(tx, ctx, coupon, 1, 10, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.RedeemTx(tx, ctx, coupon, 2, 11, 100); err != ErrCouponUsedUp {
+		t.Fatalf("RedeemTx() over the limit error = %v, want %v", err, ErrCouponUsedUp)
+	}
+
+	if err := repo.UnredeemTx(tx, ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := repo.CountRedemptionsTx(tx, ctx, id, 1); err != nil || n != 0 {
+		t.Errorf("CountRedemptionsTx() = %d, %v, want 0", n, err)
+	}
+	// the use is given back once
+	if err := repo.UnredeemTx(tx, ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.RedeemTx(tx, ctx, coupon, 2, 11, 100); err != nil {
+		t.Errorf("RedeemTx() after UnredeemTx() error = %v", err)
+	}
+	if err := repo.RedeemTx(tx, ctx, coupon, 3, 12, 100); err != ErrCouponUsedUp {
+		t.Errorf("RedeemTx() over the limit error = %v, want %v", err, ErrCouponUsedUp)
+	}
+}
@@ -0,0 +1,30 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+// newTestDB opens an empty DB with the schema of the server in a temporary directory
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := OpenDB(context.Background(), filepath.Join(t.TempDir(), "test.sqlite3"), filepath.Join("..", "sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func addTestUser(t *testing.T, db *sql.DB, name string) int64 {
+	t.Helper()
+	id, err := NewUserRepository(db).AddUser(context.Background(), domain.User{Name: name, Password: "password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
@@ -15,8 +15,14 @@ func PrepareDB(ctx context.Context) (*sql.DB, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get current path: %w")
 	}
+	return OpenDB(ctx, filepath.Join(path, "db", "mercari.sqlite3"), filepath.Join(path, "sql"))
+}
 
-	db, err := sql.Open("sqlite3", filepath.Join(path, "db", "mercari.sqlite3"))
+// OpenDB opens the DB file, creating it if needed, and brings it up to the schema of sqlDir
+func OpenDB(ctx context.Context, file string, sqlDir string) (*sql.DB, error) {
+	// transactions take the write lock when they begin, so that a read-then-write cannot
+	// interleave with another one. busy_timeout makes the others wait for it instead of failing.
+	db, err := sql.Open("sqlite3", file+"?_busy_timeout=5000&_txlock=immediate")
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create DB: %w")
 	}
@@ -25,7 +31,11 @@ func PrepareDB(ctx context.Context) (*sql.DB, error) {
 		return nil, errors.Wrap(err, "failed to ping DB: %w")
 	}
 
-	f, err := os.ReadFile(filepath.Join(path, "sql", "01_schema.sql"))
+	if err = migrate(ctx, db); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate DB: %w")
+	}
+
+	f, err := os.ReadFile(filepath.Join(sqlDir, "01_schema.sql"))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to open schema.sql %w")
 	}
@@ -34,5 +44,13 @@ func PrepareDB(ctx context.Context) (*sql.DB, error) {
 		return nil, errors.Wrap(err, "failed to exec query: %w")
 	}
 
+	if err = moveItemImages(ctx, db); err != nil {
+		return nil, errors.Wrap(err, "failed to move item images: %w")
+	}
+
+	if err = setupFullText(ctx, db); err != nil {
+		return nil, errors.Wrap(err, "failed to set up full-text search: %w")
+	}
+
 	return db, nil
 }
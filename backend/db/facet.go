@@ -0,0 +1,96 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+// ItemFacets counts the items of a query by category, by status and by price bucket.
+// Each count leaves out the query's own filter on the counted field, so that it tells how many items choosing the value would find.
+type ItemFacets struct {
+	Categories map[int64]int64
+	Statuses   map[domain.ItemStatus]int64
+	// Prices has a count per price bucket, the last one for the prices over every bound
+	Prices []int64
+}
+
+// GetItemFacets counts the items of the query in one pass over them. bounds are the ascending upper bounds of the price buckets,
+// which leave out the bound itself. Items whose status is not one of statuses are never counted, whatever the query's statuses are.
+func (r *ItemDBRepository) GetItemFacets(ctx context.Context, q ItemQuery, statuses []domain.ItemStatus, bounds []int64) (ItemFacets, error) {
+	facets := ItemFacets{
+		Categories: make(map[int64]int64),
+		Statuses:   make(map[domain.ItemStatus]int64),
+		Prices:     make([]int64, len(bounds)+1),
+	}
+
+	// the faceted filters are left to the groups, every other one is applied to the items
+	all := q
+	all.CategoryIDs, all.Statuses, all.PriceMin, all.PriceMax = nil, statuses, 0, 0
+	f := r.filterItems(all)
+
+	var args []any
+	bucket := "0"
+	if len(bounds) > 0 {
+		bucket = "CASE"
+		for i, bound := range bounds {
+			bucket += fmt.Sprintf(" WHEN %s < ? THEN %d", itemPrice, i)
+			args = append(args, bound)
+		}
+		bucket += fmt.Sprintf(" ELSE %d END", len(bounds))
+	}
+	priceMax := q.PriceMax
+	if priceMax == 0 {
+		priceMax = math.MaxInt64
+	}
+	args = append(args, q.PriceMin, priceMax)
+	args = append(args, f.args...)
+
+	rows, err := r.QueryContext(ctx, "SELECT COALESCE(items.category_id, 0), items.status, "+bucket+", "+itemPrice+" >= ? AND "+itemPrice+" <= ?, COUNT(*)"+f.String()+" GROUP BY 1, 2, 3, 4", args...)
+	if err != nil {
+		return facets, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var categoryID, count int64
+		var status domain.ItemStatus
+		var bucket int
+		var inPrice bool
+		if err := rows.Scan(&categoryID, &status, &bucket, &inPrice, &count); err != nil {
+			return facets, err
+		}
+		inCategory := len(q.CategoryIDs) == 0 || containsInt64(q.CategoryIDs, categoryID)
+		inStatus := len(q.Statuses) == 0 || containsStatus(q.Statuses, status)
+		if inStatus && inPrice {
+			facets.Categories[categoryID] += count
+		}
+		if inCategory && inPrice {
+			facets.Statuses[status] += count
+		}
+		if inCategory && inStatus {
+			facets.Prices[bucket] += count
+		}
+	}
+	return facets, rows.Err()
+}
+
+func containsInt64(values []int64, v int64) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsStatus(statuses []domain.ItemStatus, s domain.ItemStatus) bool {
+	for _, status := range statuses {
+		if status == s {
+			return true
+		}
+	}
+	return false
+}
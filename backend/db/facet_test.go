@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+func TestGetItemFacets(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	repo := NewItemRepository(db)
+	sellerID := addTestUser(t, db, "seller")
+
+	for _, item := range []struct {
+		categoryID int64
+		status     domain.ItemStatus
+		price      int64
+		deleted    bool
+	}{
+		{1, domain.ItemStatusOnSale, 500, false},
+		{1, domain.ItemStatusOnSale, 2000, false},
+		{2, domain.ItemStatusOnSale, 500, false},
+		{2, domain.ItemStatusSoldOut, 6000, false},
+		// drafts are not one of the statuses, and deleted items are never counted
+		{1, domain.ItemStatusInitial, 500, false},
+		{1, domain.ItemStatusOnSale, 500, true},
+	} {
+		id, err := repo.AddItem(ctx, domain.Item{Name: "item", Price: item.price, Description: "description", CategoryID: item.categoryID, UserID: sellerID, Status: item.status})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.deleted {
+			if _, err := db.Exec("UPDATE items SET deleted_at = DATETIME('now', 'localtime') WHERE id = ?", id); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	statuses := []domain.ItemStatus{domain.ItemStatusOnSale, domain.ItemStatusSoldOut}
+	bounds := []int64{1000, 5000}
+	tests := []struct {
+		name  string
+		query ItemQuery
+		want  ItemFacets
+	}{
+		{
+			name:  "no filter",
+			query: ItemQuery{},
+			want: ItemFacets{
+				Categories: map[int64]int64{1: 2, 2: 2},
+				Statuses:   map[domain.ItemStatus]int64{domain.ItemStatusOnSale: 3, domain.ItemStatusSoldOut: 1},
+				Prices:     []int64{2, 1, 1},
+			},
+		},
+		{
+			// each count leaves out its own filter
+			name:  "every filter",
+			query: ItemQuery{CategoryIDs: []int64{1}, Statuses: []domain.ItemStatus{domain.ItemStatusOnSale}, PriceMax: 999},
+			want: ItemFacets{
+				Categories: map[int64]int64{1: 1, 2: 1},
+				Statuses:   map[domain.ItemStatus]int64{domain.ItemStatusOnSale: 1},
+				Prices:     []int64{1, 1, 0},
+			},
+		},
+		{
+			name:  "price range",
+			query: ItemQuery{PriceMin: 1000, PriceMax: 10000},
+			want: ItemFacets{
+				Categories: map[int64]int64{1: 1, 2: 1},
+				Statuses:   map[domain.ItemStatus]int64{domain.ItemStatusOnSale: 1, domain.ItemStatusSoldOut: 1},
+				Prices:     []int64{2, 1, 1},
+			},
+		},
+		{
+			name:  "no match",
+			query: ItemQuery{Name: "nothing"},
+			want: ItemFacets{
+				Categories: map[int64]int64{},
+				Statuses:   map[domain.ItemStatus]int64{},
+				Prices:     []int64{0, 0, 0},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got, err := repo.GetItemFacets(ctx, tt.query, statuses, bounds)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: GetItemFacets() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	// without bounds every price is in one bucket
+	got, err := repo.GetItemFacets(ctx, ItemQuery{}, statuses, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Prices, []int64{4}) {
+		t.Errorf("GetItemFacets() prices without bounds = %v, want [4]", got.Prices)
+	}
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+type IdempotencyRepository interface {
+	Reserve(ctx context.Context, userID int64, key string, requestHash string) (domain.IdempotencyRecord, bool, error)
+	Complete(ctx context.Context, userID int64, key string, status int, contentType string, body []byte) error
+	Release(ctx context.Context, userID int64, key string) error
+}
+
+type IdempotencyDBRepository struct {
+	*sql.DB
+}
+
+func NewIdempotencyRepository(db *sql.DB) IdempotencyRepository {
+	return &IdempotencyDBRepository{DB: db}
+}
+
+// Reserve claims the key for a new request. When the key is already known it returns
+// the stored record and false instead.
+func (r *IdempotencyDBRepository) Reserve(ctx context.Context, userID int64, key string, requestHash string) (domain.IdempotencyRecord, bool, error) {
+	var record domain.IdempotencyRecord
+
+	// keys are kept for a day
+	if _, err := r.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE created_at < DATETIME('now', 'localtime', '-1 day')"); err != nil {
+		return record, false, err
+	}
+
+	res, err := r.ExecContext(ctx, "INSERT INTO idempotency_keys (user_id, key, request_hash) VALUES (?, ?, ?) ON CONFLICT DO NOTHING", userID, key, requestHash)
+	if err != nil {
+		return record, false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return record, false, err
+	}
+	if n == 1 {
+		return domain.IdempotencyRecord{UserID: userID, Key: key, RequestHash: requestHash}, true, nil
+	}
+
+	row := r.QueryRowContext(ctx, "SELECT user_id, key, request_hash, status, content_type, body, created_at FROM idempotency_keys WHERE user_id = ? AND key = ?", userID, key)
+	return record, false, row.Scan(&record.UserID, &record.Key, &record.RequestHash, &record.Status, &record.ContentType, &record.Body, &record.CreatedAt)
+}
+
+func (r *IdempotencyDBRepository) Complete(ctx context.Context, userID int64, key string, status int, contentType string, body []byte) error {
+	if _, err := r.ExecContext(ctx, "UPDATE idempotency_keys SET status = ?, content_type = ?, body = ? WHERE user_id = ? AND key = ?", status, contentType, body, userID, key); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Release forgets the key so that the request can be retried.
+func (r *IdempotencyDBRepository) Release(ctx context.Context, userID int64, key string) error {
+	if _, err := r.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE user_id = ? AND key = ?", userID, key); err != nil {
+		return err
+	}
+	return nil
+}
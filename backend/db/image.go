@@ -0,0 +1,195 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+// ImageRepository keeps the photos of items. Positions of an item are numbered from 0 without gaps.
+type ImageRepository interface {
+	AddImageTx(tx *sql.Tx, ctx context.Context, itemID int32, key string) (int, error)
+	GetImage(ctx context.Context, itemID int32, position int) (domain.ItemImage, error)
+	GetImages(ctx context.Context, itemID int32) ([]domain.ItemImage, error)
+	GetImagesTx(tx *sql.Tx, ctx context.Context, itemID int32) ([]domain.ItemImage, error)
+	ReorderImagesTx(tx *sql.Tx, ctx context.Context, itemID int32, ids []int64) error
+	DeleteImageTx(tx *sql.Tx, ctx context.Context, itemID int32, position int) error
+	GetBlobImages(ctx context.Context, limit int) ([]domain.ItemImage, error)
+	MoveImage(ctx context.Context, id int64, key string) error
+}
+
+type ImageDBRepository struct {
+	*sql.DB
+}
+
+func NewImageRepository(db *sql.DB) ImageRepository {
+	return &ImageDBRepository{DB: db}
+}
+
+const imageColumns = "id, item_id, position, COALESCE(image_key, ''), created_at"
+
+func scanImage(row interface{ Scan(...any) error }) (domain.ItemImage, error) {
+	var img domain.ItemImage
+	return img, row.Scan(&img.ID, &img.ItemID, &img.Position, &img.Key, &img.CreatedAt)
+}
+
+// AddImageTx appends the image stored with key after the other images of the item and returns its position
+func (r *ImageDBRepository) AddImageTx(tx *sql.Tx, ctx context.Context, itemID int32, key string) (int, error) {
+	position, err := addImageTx(tx, ctx, itemID, key)
+	if err != nil {
+		return 0, err
+	}
+	return position, touchItemTx(tx, ctx, itemID)
+}
+
+func addImageTx(tx *sql.Tx, ctx context.Context, itemID int32, key string) (int, error) {
+	row := tx.QueryRowContext(ctx, "INSERT INTO item_images (item_id, position, image_key) VALUES (?, (SELECT COUNT(*) FROM item_images WHERE item_id = ?), ?) RETURNING position",
+		itemID, itemID, key)
+	var position int
+	return position, row.Scan(&position)
+}
+
+// GetImage returns the image at the position unless the item was deleted. Data is set if the image is still in the DB.
+func (r *ImageDBRepository) GetImage(ctx context.Context, itemID int32, position int) (domain.ItemImage, error) {
+	row := r.QueryRowContext(ctx, "SELECT item_images.id, item_images.item_id, item_images.position, COALESCE(item_images.image_key, ''), item_images.image, item_images.created_at, items.updated_at "+
+		"FROM item_images JOIN items ON items.id = item_images.item_id WHERE item_images.item_id = ? AND item_images.position = ? AND items.deleted_at IS NULL",
+		itemID, position)
+	var img domain.ItemImage
+	return img, row.Scan(&img.ID, &img.ItemID, &img.Position, &img.Key, &img.Data, &img.CreatedAt, &img.UpdatedAt)
+}
+
+func (r *ImageDBRepository) GetImages(ctx context.Context, itemID int32) ([]domain.ItemImage, error) {
+	rows, err := r.QueryContext(ctx, "SELECT "+imageColumns+" FROM item_images WHERE item_id = ? ORDER BY position", itemID)
+	if err != nil {
+		return nil, err
+	}
+	return scanImages(rows)
+}
+
+func (r *ImageDBRepository) GetImagesTx(tx *sql.Tx, ctx context.Context, itemID int32) ([]domain.ItemImage, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT "+imageColumns+" FROM item_images WHERE item_id = ? ORDER BY position", itemID)
+	if err != nil {
+		return nil, err
+	}
+	return scanImages(rows)
+}
+
+func scanImages(rows *sql.Rows) ([]domain.ItemImage, error) {
+	defer rows.Close()
+
+	var images []domain.ItemImage
+	for rows.Next() {
+		img, err := scanImage(rows)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// ReorderImagesTx puts the images of the item in the order of ids, which has to hold every image of the item
+func (r *ImageDBRepository) ReorderImagesTx(tx *sql.Tx, ctx context.Context, itemID int32, ids []int64) error {
+	for i, id := range ids {
+		res, err := tx.ExecContext(ctx, "UPDATE item_images SET position = ? WHERE id = ? AND item_id = ?", i, id, itemID)
+		if err != nil {
+			return err
+		}
+		if err := checkUpdated(res); err != nil {
+			return err
+		}
+	}
+	return touchItemTx(tx, ctx, itemID)
+}
+
+// DeleteImageTx deletes the image at the position and moves the images after it forward
+func (r *ImageDBRepository) DeleteImageTx(tx *sql.Tx, ctx context.Context, itemID int32, position int) error {
+	res, err := tx.ExecContext(ctx, "DELETE FROM item_images WHERE item_id = ? AND position = ?", itemID, position)
+	if err != nil {
+		return err
+	}
+	if err := checkUpdated(res); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE item_images SET position = position - 1 WHERE item_id = ? AND position > ?", itemID, position); err != nil {
+		return err
+	}
+	return touchItemTx(tx, ctx, itemID)
+}
+
+// setCoverTx replaces the image at position 0, or adds it if the item has no image
+func setCoverTx(tx *sql.Tx, ctx context.Context, itemID int32, key string) error {
+	res, err := tx.ExecContext(ctx, "UPDATE item_images SET image_key = ?, image = NULL WHERE item_id = ? AND position = 0", key, itemID)
+	if err != nil {
+		return err
+	}
+	if err := checkUpdated(res); err == ErrConflict {
+		if _, err := addImageTx(tx, ctx, itemID, key); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	return touchItemTx(tx, ctx, itemID)
+}
+
+// touchItemTx moves the updated_at of the item forward, which is the Last-Modified of its images
+func touchItemTx(tx *sql.Tx, ctx context.Context, itemID int32) error {
+	_, err := tx.ExecContext(ctx, "UPDATE items SET updated_at = DATETIME('now', 'localtime') WHERE id = ?", itemID)
+	return err
+}
+
+// GetBlobImages returns up to limit images which are still stored in the DB, with their Data
+func (r *ImageDBRepository) GetBlobImages(ctx context.Context, limit int) ([]domain.ItemImage, error) {
+	rows, err := r.QueryContext(ctx, "SELECT id, item_id, position, image, created_at FROM item_images WHERE image_key IS NULL ORDER BY id LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []domain.ItemImage
+	for rows.Next() {
+		var img domain.ItemImage
+		if err := rows.Scan(&img.ID, &img.ItemID, &img.Position, &img.Data, &img.CreatedAt); err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// MoveImage replaces the data of an image stored in the DB with the key it was stored with in the ImageStore.
+// It returns ErrConflict if the image was already moved or deleted.
+func (r *ImageDBRepository) MoveImage(ctx context.Context, id int64, key string) error {
+	res, err := r.ExecContext(ctx, "UPDATE item_images SET image_key = ?, image = NULL WHERE id = ? AND image_key IS NULL", key, id)
+	if err != nil {
+		return err
+	}
+	return checkUpdated(res)
+}
+
+// moveItemImages makes the image of items listed before they had several images their cover.
+// It runs after the data is loaded, which still has items.image.
+func moveItemImages(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO item_images (item_id, position, image) SELECT id, 0, image FROM items "+
+		"WHERE image IS NOT NULL AND NOT EXISTS (SELECT 1 FROM item_images WHERE item_id = items.id)"); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE items SET image = NULL WHERE image IS NOT NULL"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
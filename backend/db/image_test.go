@@ -0,0 +1,46 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+// TestMoveItemImages loads an item the way the data of /initialize does, after the schema
+func TestMoveItemImages(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	image := []byte("image")
+	if _, err := db.Exec("INSERT INTO items (id, name, price, description, category_id, seller_id, image, status) VALUES (1, 'item', 1000, 'description', 1, 1, ?, 2)", image); err != nil {
+		t.Fatal(err)
+	}
+
+	// moving them twice must not add the cover twice
+	for i := 0; i < 2; i++ {
+		if err := moveItemImages(ctx, db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	images, err := NewImageRepository(db).GetImages(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 1 {
+		t.Fatalf("images = %d, want 1", len(images))
+	}
+	cover, err := NewImageRepository(db).GetImage(ctx, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cover.Data, image) {
+		t.Errorf("cover = %q, want %q", cover.Data, image)
+	}
+	var blobs int
+	if err := db.QueryRow("SELECT COUNT(*) FROM items WHERE image IS NOT NULL").Scan(&blobs); err != nil {
+		t.Fatal(err)
+	}
+	if blobs != 0 {
+		t.Errorf("items with an image = %d, want 0", blobs)
+	}
+}
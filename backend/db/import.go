@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+type ImportRepository interface {
+	AddImportJob(ctx context.Context, userID int64, total int) (int64, error)
+	GetImportJob(ctx context.Context, id int64) (domain.ImportJob, error)
+	SetImportProgress(ctx context.Context, id int64, processed int) error
+	FinishImportJobTx(tx *sql.Tx, ctx context.Context, job domain.ImportJob) error
+	FailRunningImportJobs(ctx context.Context, reason string) (int64, error)
+}
+
+type ImportDBRepository struct {
+	*sql.DB
+}
+
+func NewImportRepository(db *sql.DB) ImportRepository {
+	return &ImportDBRepository{DB: db}
+}
+
+func (r *ImportDBRepository) AddImportJob(ctx context.Context, userID int64, total int) (int64, error) {
+	row := r.QueryRowContext(ctx, "INSERT INTO import_jobs (user_id, status, total) VALUES (?, ?, ?) RETURNING id", userID, domain.ImportStatusRunning, total)
+	var id int64
+	return id, row.Scan(&id)
+}
+
+// GetImportJob returns the job with its rows
+func (r *ImportDBRepository) GetImportJob(ctx context.Context, id int64) (domain.ImportJob, error) {
+	var job domain.ImportJob
+	row := r.QueryRowContext(ctx, "SELECT id, user_id, status, total, processed, error, created_at, updated_at FROM import_jobs WHERE id = ?", id)
+	if err := row.Scan(&job.ID, &job.UserID, &job.Status, &job.Total, &job.Processed, &job.Error, &job.CreatedAt, &job.UpdatedAt); err != nil {
+		return job, err
+	}
+
+	rows, err := r.QueryContext(ctx, "SELECT row_no, COALESCE(item_id, 0), error FROM import_job_rows WHERE job_id = ? ORDER BY row_no", id)
+	if err != nil {
+		return job, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ir domain.ImportRow
+		if err := rows.Scan(&ir.Row, &ir.ItemID, &ir.Error); err != nil {
+			return job, err
+		}
+		job.Rows = append(job.Rows, ir)
+	}
+	return job, rows.Err()
+}
+
+func (r *ImportDBRepository) SetImportProgress(ctx context.Context, id int64, processed int) error {
+	_, err := r.ExecContext(ctx, "UPDATE import_jobs SET processed = ?, updated_at = DATETIME('now', 'localtime') WHERE id = ?", processed, id)
+	return err
+}
+
+// FinishImportJobTx saves the status, the error and the rows of the job. It returns ErrConflict if the job already finished.
+func (r *ImportDBRepository) FinishImportJobTx(tx *sql.Tx, ctx context.Context, job domain.ImportJob) error {
+	res, err := tx.ExecContext(ctx, "UPDATE import_jobs SET status = ?, processed = ?, error = ?, updated_at = DATETIME('now', 'localtime') WHERE id = ? AND status = ?",
+		job.Status, job.Processed, job.Error, job.ID, domain.ImportStatusRunning)
+	if err != nil {
+		return err
+	}
+	if err := checkUpdated(res); err != nil {
+		return err
+	}
+	for _, ir := range job.Rows {
+		var itemID any
+		if ir.ItemID != 0 {
+			itemID = ir.ItemID
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO import_job_rows (job_id, row_no, item_id, error) VALUES (?, ?, ?, ?)", job.ID, ir.Row, itemID, ir.Error); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FailRunningImportJobs fails the jobs which were running when the server stopped
+func (r *ImportDBRepository) FailRunningImportJobs(ctx context.Context, reason string) (int64, error) {
+	res, err := r.ExecContext(ctx, "UPDATE import_jobs SET status = ?, error = ?, updated_at = DATETIME('now', 'localtime') WHERE status = ?",
+		domain.ImportStatusFailed, reason, domain.ImportStatusRunning)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,169 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrUnbalancedJournal   = errors.New("ledger entries must sum to zero")
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrInsufficientPoints  = errors.New("insufficient points")
+)
+
+type LedgerRepository interface {
+	PostTx(tx *sql.Tx, ctx context.Context, kind domain.LedgerKind, itemID int32, entries []domain.LedgerEntry) (int64, error)
+	GetWalletEntries(ctx context.Context, userID int64, limit int64, offset int64) ([]domain.LedgerEntry, error)
+	GetBalance(ctx context.Context, account domain.LedgerAccount, userID int64) (int64, error)
+}
+
+type LedgerDBRepository struct {
+	*sql.DB
+}
+
+func NewLedgerRepository(db *sql.DB) LedgerRepository {
+	return &LedgerDBRepository{DB: db}
+}
+
+// PostTx appends a balanced journal and re-derives users.balance of every wallet it touches
+// from the sum of its entries.
+func (r *LedgerDBRepository) PostTx(tx *sql.Tx, ctx context.Context, kind domain.LedgerKind, itemID int32, entries []domain.LedgerEntry) (int64, error) {
+	var sum int64
+	for _, e := range entries {
+		sum += e.Amount
+	}
+	if len(entries) < 2 || sum != 0 {
+		return -1, ErrUnbalancedJournal
+	}
+
+	wallets := map[int64]bool{}
+	points := map[int64]bool{}
+	for _, e := range entries {
+		if e.Account == domain.LedgerAccountWallet && !wallets[e.UserID] {
+			wallets[e.UserID] = true
+			if err := r.openWalletTx(tx, ctx, e.UserID); err != nil {
+				return -1, err
+			}
+		}
+		if e.Account == domain.LedgerAccountPoints {
+			points[e.UserID] = true
+		}
+	}
+
+	journalID, err := r.insertJournalTx(tx, ctx, kind, itemID, entries)
+	if err != nil {
+		return -1, err
+	}
+
+	for userID := range wallets {
+		row := tx.QueryRowContext(ctx, "UPDATE users SET balance = (SELECT COALESCE(SUM(amount), 0) FROM ledger_entry WHERE account = ? AND user_id = ?) WHERE id = ? RETURNING balance",
+			domain.LedgerAccountWallet, userID, userID)
+		var balance int64
+		if err := row.Scan(&balance); err != nil {
+			return -1, err
+		}
+		if balance < 0 {
+			return -1, ErrInsufficientBalance
+		}
+	}
+	for userID := range points {
+		row := tx.QueryRowContext(ctx, "SELECT COALESCE(SUM(amount), 0) FROM ledger_entry WHERE account = ? AND user_id = ?", domain.LedgerAccountPoints, userID)
+		var balance int64
+		if err := row.Scan(&balance); err != nil {
+			return -1, err
+		}
+		if balance < 0 {
+			return -1, ErrInsufficientPoints
+		}
+	}
+	return journalID, nil
+}
+
+func (r *LedgerDBRepository) GetBalance(ctx context.Context, account domain.LedgerAccount, userID int64) (int64, error) {
+	row := r.QueryRowContext(ctx, "SELECT COALESCE(SUM(amount), 0) FROM ledger_entry WHERE account = ? AND user_id = ?", account, userID)
+	var balance int64
+	return balance, row.Scan(&balance)
+}
+
+// openWalletTx books the balance a user had before the ledger existed, so that the wallet
+// can be derived from entries from now on.
+func (r *LedgerDBRepository) openWalletTx(tx *sql.Tx, ctx context.Context, userID int64) error {
+	row := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM ledger_entry WHERE account = ? AND user_id = ?)", domain.LedgerAccountWallet, userID)
+	var opened bool
+	if err := row.Scan(&opened); err != nil {
+		return err
+	}
+	if opened {
+		return nil
+	}
+
+	row = tx.QueryRowContext(ctx, "SELECT balance FROM users WHERE id = ?", userID)
+	var balance int64
+	if err := row.Scan(&balance); err != nil {
+		return err
+	}
+	if balance == 0 {
+		return nil
+	}
+
+	_, err := r.insertJournalTx(tx, ctx, domain.LedgerKindOpening, 0, []domain.LedgerEntry{
+		{Account: domain.LedgerAccountExternal, Amount: -balance},
+		{Account: domain.LedgerAccountWallet, UserID: userID, Amount: balance},
+	})
+	return err
+}
+
+func (r *LedgerDBRepository) insertJournalTx(tx *sql.Tx, ctx context.Context, kind domain.LedgerKind, itemID int32, entries []domain.LedgerEntry) (int64, error) {
+	row := tx.QueryRowContext(ctx, "INSERT INTO ledger_journal (kind, item_id) VALUES (?, ?) RETURNING id", kind, nullInt32(itemID))
+	var journalID int64
+	if err := row.Scan(&journalID); err != nil {
+		return -1, err
+	}
+
+	for _, e := range entries {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO ledger_entry (journal_id, account, user_id, amount) VALUES (?, ?, ?, ?)",
+			journalID, e.Account, nullInt64(e.UserID), e.Amount); err != nil {
+			return -1, err
+		}
+	}
+	return journalID, nil
+}
+
+// GetWalletEntries returns the wallet entries of a user, newest first, with the balance after each entry.
+func (r *LedgerDBRepository) GetWalletEntries(ctx context.Context, userID int64, limit int64, offset int64) ([]domain.LedgerEntry, error) {
+	rows, err := r.QueryContext(ctx, `SELECT * FROM (
+		SELECT e.id, e.journal_id, e.account, e.user_id, e.amount, j.kind, j.item_id, SUM(e.amount) OVER (ORDER BY e.id), e.created_at
+		FROM ledger_entry e JOIN ledger_journal j ON e.journal_id = j.id
+		WHERE e.account = ? AND e.user_id = ?
+	) ORDER BY id desc LIMIT ? OFFSET ?`, domain.LedgerAccountWallet, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []domain.LedgerEntry
+	for rows.Next() {
+		var entry domain.LedgerEntry
+		var itemID sql.NullInt32
+		if err := rows.Scan(&entry.ID, &entry.JournalID, &entry.Account, &entry.UserID, &entry.Amount, &entry.Kind, &itemID, &entry.Balance, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entry.ItemID = itemID.Int32
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func nullInt32(v int32) sql.NullInt32 {
+	return sql.NullInt32{Int32: v, Valid: v != 0}
+}
+
+func nullInt64(v int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: v, Valid: v != 0}
+}
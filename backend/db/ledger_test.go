@@ -0,0 +1,150 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+func TestPostTx(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		entries func(buyer, seller int64) []domain.LedgerEntry
+		wantErr error
+		// the wallets after the journal, which start with 1000 for the buyer
+		wantBuyer  int64
+		wantSeller int64
+	}{
+		{
+			name: "balanced",
+			entries: func(buyer, seller int64) []domain.LedgerEntry {
+				return []domain.LedgerEntry{
+					{Account: domain.LedgerAccountWallet, UserID: buyer, Amount: -300},
+					{Account: domain.LedgerAccountWallet, UserID: seller, Amount: 270},
+					{Account: domain.LedgerAccountPlatform, Amount: 30},
+				}
+			},
+			wantBuyer:  700,
+			wantSeller: 270,
+		},
+		{
+			name: "unbalanced",
+			entries: func(buyer, seller int64) []domain.LedgerEntry {
+				return []domain.LedgerEntry{
+					{Account: domain.LedgerAccountWallet, UserID: buyer, Amount: -300},
+					{Account: domain.LedgerAccountWallet, UserID: seller, Amount: 200},
+				}
+			},
+			wantErr:   ErrUnbalancedJournal,
+			wantBuyer: 1000,
+		},
+		{
+			name: "single entry",
+			entries: func(buyer, seller int64) []domain.LedgerEntry {
+				return []domain.LedgerEntry{{Account: domain.LedgerAccountWallet, UserID: buyer, Amount: 0}}
+			},
+			wantErr:   ErrUnbalancedJournal,
+			wantBuyer: 1000,
+		},
+		{
+			name: "overdrawn wallet",
+			entries: func(buyer, seller int64) []domain.LedgerEntry {
+				return []domain.LedgerEntry{
+					{Account: domain.LedgerAccountWallet, UserID: buyer, Amount: -1001},
+					{Account: domain.LedgerAccountWallet, UserID: seller, Amount: 1001},
+				}
+			},
+			wantErr:   ErrInsufficientBalance,
+			wantBuyer: 1000,
+		},
+		{
+			name: "overdrawn points",
+			entries: func(buyer, seller int64) []domain.LedgerEntry {
+				return []domain.LedgerEntry{
+					{Account: domain.LedgerAccountPoints, UserID: buyer, Amount: -1},
+					{Account: domain.LedgerAccountWallet, UserID: seller, Amount: 1},
+				}
+			},
+			wantErr:   ErrInsufficientPoints,
+			wantBuyer: 1000,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			repo := NewLedgerRepository(db)
+			buyer, seller := addTestUser(t, db, "buyer"), addTestUser(t, db, "seller")
+			// a balance from before the ledger, which the first journal opens
+			if _, err := db.Exec("UPDATE users SET balance = 1000 WHERE id = ?", buyer); err != nil {
+				t.Fatal(err)
+			}
+
+			tx, err := db.BeginTx(ctx, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = repo.PostTx(tx, ctx, domain.LedgerKindPurchase, 0, tt.entries(buyer, seller))
+			if err != tt.wantErr {
+				t.Fatalf("PostTx() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				tx.Rollback()
+			} else if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+
+			for userID, want := range map[int64]int64{buyer: tt.wantBuyer, seller: tt.wantSeller} {
+				var balance int64
+				if err := db.QueryRow("SELECT balance FROM users WHERE id = ?", userID).Scan(&balance); err != nil {
+					t.Fatal(err)
+				}
+				if balance != want {
+					t.Errorf("users.balance of %d = %d, want %d", userID, balance, want)
+				}
+				if tt.wantErr == nil {
+					ledger, err := repo.GetBalance(ctx, domain.LedgerAccountWallet, userID)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if ledger != want {
+						t.Errorf("ledger balance of %d = %d, want %d", userID, ledger, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPostTxSumsEveryJournal(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	repo := NewLedgerRepository(db)
+	user := addTestUser(t, db, "user")
+
+	for _, amount := range []int64{500, -200, 300} {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.PostTx(tx, ctx, domain.LedgerKindTopUp, 0, []domain.LedgerEntry{
+			{Account: domain.LedgerAccountExternal, Amount: -amount},
+			{Account: domain.LedgerAccountWallet, UserID: user, Amount: amount},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := repo.GetWalletEntries(ctx, user, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].Balance != 600 || entries[2].Balance != 500 {
+		t.Errorf("GetWalletEntries() = %+v, want the balances 600, 300 and 500 from the newest", entries)
+	}
+}
@@ -0,0 +1,61 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+type LikeRepository interface {
+	AddLike(ctx context.Context, userID int64, itemID int32) error
+	DeleteLike(ctx context.Context, userID int64, itemID int32) error
+	GetLikeCount(ctx context.Context, itemID int32) (int64, error)
+}
+
+type LikeDBRepository struct {
+	*sql.DB
+}
+
+func NewLikeRepository(db *sql.DB) LikeRepository {
+	return &LikeDBRepository{DB: db}
+}
+
+// AddLike does nothing if the user already likes the item
+func (r *LikeDBRepository) AddLike(ctx context.Context, userID int64, itemID int32) error {
+	return r.changeLike(ctx, "INSERT OR IGNORE INTO likes (item_id, user_id) VALUES (?, ?)", itemID, userID, 1)
+}
+
+// DeleteLike does nothing if the user does not like the item
+func (r *LikeDBRepository) DeleteLike(ctx context.Context, userID int64, itemID int32) error {
+	return r.changeLike(ctx, "DELETE FROM likes WHERE item_id = ? AND user_id = ?", itemID, userID, -1)
+}
+
+// changeLike adds or deletes the like with query, and adds diff to the like count of the item if it did
+func (r *LikeDBRepository) changeLike(ctx context.Context, query string, itemID int32, userID int64, diff int) error {
+	tx, err := r.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, query, itemID, userID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE items SET like_count = like_count + ? WHERE id = ?", diff, itemID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *LikeDBRepository) GetLikeCount(ctx context.Context, itemID int32) (int64, error) {
+	row := r.QueryRowContext(ctx, "SELECT like_count FROM items WHERE id = ?", itemID)
+	var count int64
+	return count, row.Scan(&count)
+}
+
+// likeCount counts the users who like items.id
+const likeCount = "(SELECT COUNT(*) FROM likes WHERE item_id = items.id)"
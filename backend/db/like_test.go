@@ -0,0 +1,169 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+// checkItemCounts checks the like and view counts of the item, and that they are the ones counted from the likes and history tables
+func checkItemCounts(t *testing.T, db *sql.DB, itemID int32, wantLikes, wantViews int64) {
+	t.Helper()
+	ctx := context.Background()
+	likes, err := NewLikeRepository(db).GetLikeCount(ctx, itemID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	views, err := NewItemRepository(db).GetViewCount(ctx, itemID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if likes != wantLikes || views != wantViews {
+		t.Errorf("likes, views = %d, %d, want %d, %d", likes, views, wantLikes, wantViews)
+	}
+
+	var counted [2]int64
+	if err := db.QueryRow("SELECT "+likeCount+", "+viewCount+" FROM items WHERE id = ?", itemID).Scan(&counted[0], &counted[1]); err != nil {
+		t.Fatal(err)
+	}
+	if counted != [2]int64{likes, views} {
+		t.Errorf("counted likes, views = %v, want %d, %d", counted, likes, views)
+	}
+}
+
+func TestItemCounts(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	items := NewItemRepository(db)
+	likes := NewLikeRepository(db)
+	sellerID := addTestUser(t, db, "seller")
+	a := addTestUser(t, db, "a")
+	b := addTestUser(t, db, "b")
+	itemID, err := items.AddItem(ctx, domain.Item{Name: "item", Price: 1000, Description: "description", CategoryID: 1, UserID: sellerID, Status: domain.ItemStatusOnSale})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// liking twice and unliking what is not liked change nothing
+	for _, like := range []struct {
+		userID int64
+		add    bool
+	}{{a, true}, {a, true}, {b, true}, {b, false}, {b, false}} {
+		change := likes.DeleteLike
+		if like.add {
+			change = likes.AddLike
+		}
+		if err := change(ctx, like.userID, itemID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a user is counted once, the seller never and anonymous views always
+	for _, userID := range []int64{-1, -1, a, a, sellerID, b} {
+		if err := items.AddHistory(ctx, userID, itemID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkItemCounts(t, db, itemID, 1, 4)
+
+	// a purged user's like and view are no longer counted
+	if err := likes.AddLike(ctx, b, itemID); err != nil {
+		t.Fatal(err)
+	}
+	checkItemCounts(t, db, itemID, 2, 4)
+	if _, err := db.Exec("UPDATE users SET deleted_at = '2000-01-01 00:00:00' WHERE id = ?", b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewUserRepository(db).PurgeUsers(ctx, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	checkItemCounts(t, db, itemID, 1, 3)
+}
+
+func TestSearchItemsByCounts(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	items := NewItemRepository(db)
+	sellerID := addTestUser(t, db, "seller")
+	var users []int64
+	for _, name := range []string{"a", "b", "c"} {
+		users = append(users, addTestUser(t, db, name))
+	}
+
+	// the items are liked by 1, 3 and 2 users, and viewed by 2, 1 and 3
+	var ids []int32
+	for _, n := range []struct{ likes, views int }{{1, 2}, {3, 1}, {2, 3}} {
+		itemID, err := items.AddItem(ctx, domain.Item{Name: "item", Price: 1000, Description: "description", CategoryID: 1, UserID: sellerID, Status: domain.ItemStatusOnSale})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, itemID)
+		for _, userID := range users[:n.likes] {
+			if err := NewLikeRepository(db).AddLike(ctx, userID, itemID); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, userID := range users[:n.views] {
+			if err := items.AddHistory(ctx, userID, itemID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for _, tt := range []struct {
+		sort ItemSort
+		want []int32
+	}{
+		{ItemSortLikes, []int32{ids[1], ids[2], ids[0]}},
+		{ItemSortViews, []int32{ids[2], ids[0], ids[1]}},
+	} {
+		found, _, err := items.SearchItems(ctx, ItemQuery{Sort: tt.sort})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int32
+		for _, item := range found {
+			got = append(got, item.ID)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SearchItems() by %v = %v, want %v", tt.sort, got, tt.want)
+		}
+	}
+}
+
+// TestOpenDBCountsItems opens a DB from before the counts were kept
+func TestOpenDBCountsItems(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "test.sqlite3")
+	old, err := sql.Open("sqlite3", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{
+		"CREATE TABLE items (id integer primary key autoincrement, name varchar(50), price integer, description text, category_id integer, seller_id integer, image blob, status integer, created_at text NOT NULL DEFAULT (DATETIME('now', 'localtime')), updated_at text NOT NULL DEFAULT (DATETIME('now', 'localtime')), deleted_at text)",
+		"CREATE TABLE likes (item_id integer NOT NULL, user_id integer NOT NULL, created_at text NOT NULL DEFAULT (DATETIME('now', 'localtime')), PRIMARY KEY (item_id, user_id))",
+		"CREATE TABLE history (id integer primary key autoincrement, user_id integer, item_id integer, accesss_at text NOT NULL DEFAULT (DATETIME('now', 'localtime')))",
+		"INSERT INTO items (id, name, price, description, category_id, seller_id, status) VALUES (1, 'item', 1000, 'description', 1, 1, 2)",
+		"INSERT INTO likes (item_id, user_id) VALUES (1, 2), (1, 3)",
+		"INSERT INTO history (item_id, user_id) VALUES (1, 1), (1, 2), (1, 2), (1, NULL)",
+		// the migrations before the counts were applied
+		"PRAGMA user_version = 7",
+	} {
+		if _, err := old.Exec(query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	old.Close()
+
+	db, err := OpenDB(ctx, file, filepath.Join("..", "sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	checkItemCounts(t, db, 1, 2, 2)
+}
@@ -0,0 +1,227 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+	"github.com/pkg/errors"
+)
+
+// migration brings the tables of one older 01_schema.sql up to the next one
+type migration func(tx *sql.Tx, ctx context.Context) error
+
+// migrations are applied in order to a DB before 01_schema.sql. The schema only creates the tables which do not exist,
+// so a column added to an existing table needs a migration as well, appended at the end.
+// PRAGMA user_version counts the migrations a DB had, and they are not applied again. DBs from before user_version
+// was kept are at 0 whatever their tables are, so migrations check that they are needed, and do nothing on missing tables.
+var migrations = []migration{
+	// purchases were held in escrow, which needs several purchases of an item
+	migrateEscrowPurchase,
+	addColumns("purchase",
+		column{name: "cancel_requested_by", definition: "integer"},
+		column{name: "cancel_reason", definition: "text"},
+	),
+	addColumns("purchase",
+		column{name: "fee_rate_bp", definition: "integer NOT NULL DEFAULT 0"},
+		column{name: "fee_flat", definition: "integer NOT NULL DEFAULT 0"},
+		column{name: "fee_amount", definition: "integer NOT NULL DEFAULT 0"},
+		// the sellers of the purchases from before fees got their whole price
+		column{name: "seller_amount", definition: "integer NOT NULL DEFAULT 0", from: "price"},
+	),
+	addColumns("purchase",
+		column{name: "coupon_id", definition: "integer"},
+		column{name: "discount", definition: "integer NOT NULL DEFAULT 0"},
+		column{name: "points", definition: "integer NOT NULL DEFAULT 0"},
+	),
+	addColumns("items", column{name: "deleted_at", definition: "text"}),
+	addColumns("users", column{name: "deleted_at", definition: "text"}),
+	addColumns("item_images", column{name: "image_key", definition: "text"}),
+	// the most liked and most viewed sorts counted the likes and the views of every item
+	addItemCounts,
+	// items sold before shipped and completed items existed follow their latest purchase
+	migrateSoldItemStatus,
+	addColumns("payouts", column{name: "provider_ref", definition: "varchar(255) NOT NULL DEFAULT ''"}),
+	// the price sorts are keyed on the price or 0, which items_sort_price_idx indexes
+	dropPriceIndex,
+}
+
+// column is added with its definition, which must have a constant default. from is an expression of the row
+// to fill it with instead of the default.
+type column struct {
+	name       string
+	definition string
+	from       string
+}
+
+func addColumns(table string, columns ...column) migration {
+	return func(tx *sql.Tx, ctx context.Context) error {
+		existing, err := tableColumns(tx, ctx, table)
+		if err != nil || len(existing) == 0 {
+			return err
+		}
+		for _, c := range columns {
+			if existing[c.name] {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, c.name, c.definition)); err != nil {
+				return err
+			}
+			if c.from == "" {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET %s = %s", table, c.name, c.from)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// addItemCounts adds the like and view counts of items, counted from the likes and history tables which exist
+func addItemCounts(tx *sql.Tx, ctx context.Context) error {
+	existing, err := tableColumns(tx, ctx, "items")
+	if err != nil || len(existing) == 0 || existing["like_count"] {
+		return err
+	}
+	if err := addColumns("items",
+		column{name: "like_count", definition: "integer NOT NULL DEFAULT 0"},
+		column{name: "view_count", definition: "integer NOT NULL DEFAULT 0"},
+	)(tx, ctx); err != nil {
+		return err
+	}
+
+	for _, count := range []struct{ table, set string }{
+		{"likes", "like_count = " + likeCount},
+		{"history", "view_count = " + viewCount},
+	} {
+		columns, err := tableColumns(tx, ctx, count.table)
+		if err != nil {
+			return err
+		}
+		if len(columns) == 0 {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE items SET "+count.set); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateSoldItemStatus moves the sold items whose latest purchase was shipped to shipped, and the ones whose latest
+// purchase was received or completed to completed
+func migrateSoldItemStatus(tx *sql.Tx, ctx context.Context) error {
+	for _, table := range []string{"items", "purchase"} {
+		columns, err := tableColumns(tx, ctx, table)
+		if err != nil || len(columns) == 0 {
+			return err
+		}
+	}
+
+	latest := "(SELECT status FROM purchase WHERE item_id = items.id ORDER BY id desc LIMIT 1)"
+	if _, err := tx.ExecContext(ctx, "UPDATE items SET status = ? WHERE status = ? AND "+latest+" = ?",
+		domain.ItemStatusShipped, domain.ItemStatusSoldOut, domain.PurchaseStatusShipped); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, "UPDATE items SET status = ? WHERE status = ? AND "+latest+" IN (?, ?)",
+		domain.ItemStatusCompleted, domain.ItemStatusSoldOut, domain.PurchaseStatusReceived, domain.PurchaseStatusCompleted)
+	return err
+}
+
+func dropPriceIndex(tx *sql.Tx, ctx context.Context) error {
+	_, err := tx.ExecContext(ctx, "DROP INDEX IF EXISTS items_price_idx")
+	return err
+}
+
+// migrateEscrowPurchase rebuilds the purchase table keyed by item_id into the one of the first escrow purchases.
+// The buyers of these purchases paid the seller at once, so they are completed.
+func migrateEscrowPurchase(tx *sql.Tx, ctx context.Context) error {
+	existing, err := tableColumns(tx, ctx, "purchase")
+	if err != nil || len(existing) == 0 || existing["id"] {
+		return err
+	}
+
+	for _, query := range []string{
+		"ALTER TABLE purchase RENAME TO purchase_v0",
+		`CREATE TABLE purchase
+(
+    id           integer primary key autoincrement,
+    item_id      integer NOT NULL,
+    buyer_id     integer NOT NULL,
+    seller_id    integer NOT NULL,
+    price        integer NOT NULL,
+    status       integer NOT NULL,
+    created_at   text NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+    updated_at   text NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+    shipped_at   text,
+    received_at  text,
+    completed_at text,
+    cancelled_at text
+)`,
+		`INSERT INTO purchase (item_id, buyer_id, seller_id, price, status, completed_at)
+SELECT purchase_v0.item_id, COALESCE(purchase_v0.buyer_id, 0), COALESCE(items.seller_id, 0), COALESCE(items.price, 0), 4, DATETIME('now', 'localtime')
+FROM purchase_v0 LEFT JOIN items ON items.id = purchase_v0.item_id
+ORDER BY purchase_v0.item_id`,
+		"DROP TABLE purchase_v0",
+	} {
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tableColumns is empty if the table does not exist
+func tableColumns(tx *sql.Tx, ctx context.Context, table string) (map[string]bool, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+// migrate applies the migrations the DB did not have yet, each with the user_version it leads to
+func migrate(ctx context.Context, db *sql.DB) error {
+	var version int
+	if err := db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	for ; version < len(migrations); version++ {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if err := migrations[version](tx, ctx); err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, fmt.Sprintf("migration %d", version+1))
+		}
+		// PRAGMA cannot take parameters
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markMigrated records that a DB made from the current 01_schema.sql needs none of the migrations
+func markMigrated(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", len(migrations)))
+	return err
+}
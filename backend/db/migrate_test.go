@@ -0,0 +1,131 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+// baselineSchema is the schema from before the migrations, with a sold item
+const baselineSchema = `
+CREATE TABLE items
+(
+    id          integer primary key autoincrement,
+    name        varchar(50),
+    price       integer,
+    description text,
+    category_id integer,
+    seller_id   integer,
+    image       blob,
+    status      integer,
+    created_at  text NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+    updated_at  text NOT NULL DEFAULT (DATETIME('now', 'localtime'))
+);
+CREATE TABLE users
+(
+    id       integer primary key autoincrement,
+    name     varchar(50),
+    password binary(60),
+    balance  integer default 0
+);
+CREATE TABLE purchase
+(
+    item_id  integer primary key,
+    buyer_id integer
+);
+INSERT INTO users (name, balance) VALUES ('seller', 1500), ('buyer', 0);
+INSERT INTO items (name, price, description, category_id, seller_id, status) VALUES ('sold', 1500, 'sold item', 1, 1, 3);
+INSERT INTO purchase (item_id, buyer_id) VALUES (1, 2);
+`
+
+func TestOpenDBMigratesBaseline(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "test.sqlite3")
+
+	old, err := sql.Open("sqlite3", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.Exec(baselineSchema); err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+
+	// opening it again must not apply the migrations twice
+	for i := 0; i < 2; i++ {
+		db, err := OpenDB(ctx, file, filepath.Join("..", "sql"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var version int
+		if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+			t.Fatal(err)
+		}
+		if version != len(migrations) {
+			t.Errorf("user_version = %d, want %d", version, len(migrations))
+		}
+
+		p, err := NewPurchaseRepository(db).GetPurchaseByItemID(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.BuyerID != 2 || p.SellerID != 1 || p.Price != 1500 || p.SellerAmount != 1500 || p.Status != domain.PurchaseStatusCompleted {
+			t.Errorf("purchase = %+v, want a completed purchase of 1500 from 2 to 1", p)
+		}
+
+		item, err := NewItemRepository(db).GetItem(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.Status != domain.ItemStatusCompleted {
+			t.Errorf("item status = %v, want %v", item.Status, domain.ItemStatusCompleted)
+		}
+		db.Close()
+	}
+}
+
+func TestOpenDBMarksNewDB(t *testing.T) {
+	db := newTestDB(t)
+
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Errorf("user_version = %d, want %d", version, len(migrations))
+	}
+}
+
+func TestOpenDBRemapsSoldItemsOnce(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "test.sqlite3")
+	db, err := OpenDB(ctx, file, filepath.Join("..", "sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the status of a sold item is not changed once the DB is migrated, even if its purchase is completed
+	if _, err := db.Exec("INSERT INTO items (name, price, description, category_id, seller_id, status) VALUES ('sold', 1000, 'sold item', 1, 1, ?)", domain.ItemStatusSoldOut); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO purchase (item_id, buyer_id, seller_id, price, seller_amount, status) VALUES (1, 2, 1, 1000, 1000, ?)", domain.PurchaseStatusCompleted); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = OpenDB(ctx, file, filepath.Join("..", "sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	item, err := NewItemRepository(db).GetItem(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Status != domain.ItemStatusSoldOut {
+		t.Errorf("item status = %v, want %v", item.Status, domain.ItemStatusSoldOut)
+	}
+}
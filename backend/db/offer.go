@@ -0,0 +1,149 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+type OfferRepository interface {
+	AddOfferTx(tx *sql.Tx, ctx context.Context, offer domain.Offer) (int64, error)
+	GetOfferTx(tx *sql.Tx, ctx context.Context, id int64) (domain.Offer, error)
+	GetOffersByItemID(ctx context.Context, itemID int32) ([]domain.Offer, error)
+	GetOffersByBuyerID(ctx context.Context, buyerID int64) ([]domain.Offer, error)
+	GetOpenOfferTx(tx *sql.Tx, ctx context.Context, itemID int32, buyerID int64, now string) (domain.Offer, error)
+	GetAcceptedOfferTx(tx *sql.Tx, ctx context.Context, itemID int32, buyerID int64, now string) (domain.Offer, error)
+	UpdateOfferTx(tx *sql.Tx, ctx context.Context, offer domain.Offer, from domain.OfferStatus) error
+	UseOfferTx(tx *sql.Tx, ctx context.Context, id int64, purchaseID int64) error
+	CloseOffersTx(tx *sql.Tx, ctx context.Context, itemID int32) error
+	ExpireOffersTx(tx *sql.Tx, ctx context.Context, now string) ([]domain.Offer, error)
+}
+
+type OfferDBRepository struct {
+	*sql.DB
+}
+
+func NewOfferRepository(db *sql.DB) OfferRepository {
+	return &OfferDBRepository{DB: db}
+}
+
+const offerColumns = "id, item_id, buyer_id, seller_id, price, counter_price, status, COALESCE(purchase_id, 0), expires_at, created_at, updated_at"
+
+// open offers can still be accepted or purchased
+var openOfferStatuses = []any{domain.OfferStatusPending, domain.OfferStatusCountered, domain.OfferStatusAccepted}
+
+func scanOffer(row interface{ Scan(...any) error }) (domain.Offer, error) {
+	var o domain.Offer
+	return o, row.Scan(&o.ID, &o.ItemID, &o.BuyerID, &o.SellerID, &o.Price, &o.CounterPrice, &o.Status, &o.PurchaseID, &o.ExpiresAt, &o.CreatedAt, &o.UpdatedAt)
+}
+
+func (r *OfferDBRepository) AddOfferTx(tx *sql.Tx, ctx context.Context, offer domain.Offer) (int64, error) {
+	row := tx.QueryRowContext(ctx, "INSERT INTO offers (item_id, buyer_id, seller_id, price, status, expires_at) VALUES (?, ?, ?, ?, ?, ?) RETURNING id",
+		offer.ItemID, offer.BuyerID, offer.SellerID, offer.Price, domain.OfferStatusPending, offer.ExpiresAt)
+	var id int64
+	return id, row.Scan(&id)
+}
+
+func (r *OfferDBRepository) GetOfferTx(tx *sql.Tx, ctx context.Context, id int64) (domain.Offer, error) {
+	return scanOffer(tx.QueryRowContext(ctx, "SELECT "+offerColumns+" FROM offers WHERE id = ?", id))
+}
+
+func (r *OfferDBRepository) GetOffersByItemID(ctx context.Context, itemID int32) ([]domain.Offer, error) {
+	return r.queryOffers(ctx, "SELECT "+offerColumns+" FROM offers WHERE item_id = ? ORDER BY id desc", itemID)
+}
+
+func (r *OfferDBRepository) GetOffersByBuyerID(ctx context.Context, buyerID int64) ([]domain.Offer, error) {
+	return r.queryOffers(ctx, "SELECT "+offerColumns+" FROM offers WHERE buyer_id = ? ORDER BY id desc", buyerID)
+}
+
+func (r *OfferDBRepository) queryOffers(ctx context.Context, query string, args ...any) ([]domain.Offer, error) {
+	rows, err := r.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var offers []domain.Offer
+	for rows.Next() {
+		o, err := scanOffer(rows)
+		if err != nil {
+			return nil, err
+		}
+		offers = append(offers, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return offers, nil
+}
+
+// GetOpenOfferTx returns the offer of the buyer which is not settled nor expired yet
+func (r *OfferDBRepository) GetOpenOfferTx(tx *sql.Tx, ctx context.Context, itemID int32, buyerID int64, now string) (domain.Offer, error) {
+	args := append([]any{itemID, buyerID, now}, openOfferStatuses...)
+	return scanOffer(tx.QueryRowContext(ctx, "SELECT "+offerColumns+" FROM offers WHERE item_id = ? AND buyer_id = ? AND expires_at >= ? AND status IN (?, ?, ?) ORDER BY id desc LIMIT 1", args...))
+}
+
+func (r *OfferDBRepository) GetAcceptedOfferTx(tx *sql.Tx, ctx context.Context, itemID int32, buyerID int64, now string) (domain.Offer, error) {
+	return scanOffer(tx.QueryRowContext(ctx, "SELECT "+offerColumns+" FROM offers WHERE item_id = ? AND buyer_id = ? AND expires_at >= ? AND status = ? ORDER BY id desc LIMIT 1",
+		itemID, buyerID, now, domain.OfferStatusAccepted))
+}
+
+// UpdateOfferTx saves the status, counter price and expiry of the offer if its status is still from.
+// It returns ErrConflict otherwise.
+func (r *OfferDBRepository) UpdateOfferTx(tx *sql.Tx, ctx context.Context, offer domain.Offer, from domain.OfferStatus) error {
+	res, err := tx.ExecContext(ctx, "UPDATE offers SET status = ?, counter_price = ?, expires_at = ?, updated_at = DATETIME('now', 'localtime') WHERE id = ? AND status = ?",
+		offer.Status, offer.CounterPrice, offer.ExpiresAt, offer.ID, from)
+	if err != nil {
+		return err
+	}
+	return checkUpdated(res)
+}
+
+// UseOfferTx marks the accepted offer as purchased. It returns ErrConflict if it is no longer accepted.
+func (r *OfferDBRepository) UseOfferTx(tx *sql.Tx, ctx context.Context, id int64, purchaseID int64) error {
+	res, err := tx.ExecContext(ctx, "UPDATE offers SET status = ?, purchase_id = ?, updated_at = DATETIME('now', 'localtime') WHERE id = ? AND status = ?",
+		domain.OfferStatusPurchased, purchaseID, id, domain.OfferStatusAccepted)
+	if err != nil {
+		return err
+	}
+	return checkUpdated(res)
+}
+
+// CloseOffersTx closes the open offers of an item sold to someone else
+func (r *OfferDBRepository) CloseOffersTx(tx *sql.Tx, ctx context.Context, itemID int32) error {
+	args := append([]any{domain.OfferStatusClosed, itemID}, openOfferStatuses...)
+	if _, err := tx.ExecContext(ctx, "UPDATE offers SET status = ?, updated_at = DATETIME('now', 'localtime') WHERE item_id = ? AND status IN (?, ?, ?)", args...); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ExpireOffersTx marks the open offers which ran out of time as expired, and returns them as they were
+func (r *OfferDBRepository) ExpireOffersTx(tx *sql.Tx, ctx context.Context, now string) ([]domain.Offer, error) {
+	args := append([]any{now}, openOfferStatuses...)
+	rows, err := tx.QueryContext(ctx, "SELECT "+offerColumns+" FROM offers WHERE expires_at < ? AND status IN (?, ?, ?)", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var offers []domain.Offer
+	for rows.Next() {
+		o, err := scanOffer(rows)
+		if err != nil {
+			return nil, err
+		}
+		offers = append(offers, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, o := range offers {
+		if _, err := tx.ExecContext(ctx, "UPDATE offers SET status = ?, updated_at = DATETIME('now', 'localtime') WHERE id = ?", domain.OfferStatusExpired, o.ID); err != nil {
+			return nil, err
+		}
+	}
+	return offers, nil
+}
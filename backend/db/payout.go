@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+type PayoutRepository interface {
+	AddPayoutTx(tx *sql.Tx, ctx context.Context, userID int64, amount int64) (int64, error)
+	GetPayout(ctx context.Context, id int64) (domain.Payout, error)
+	GetPayoutTx(tx *sql.Tx, ctx context.Context, id int64) (domain.Payout, error)
+	GetPayoutsByUserID(ctx context.Context, userID int64) ([]domain.Payout, error)
+	GetPayoutsByStatus(ctx context.Context, status domain.PayoutStatus) ([]domain.Payout, error)
+	UpdatePayoutStatus(ctx context.Context, id int64, from domain.PayoutStatus, to domain.PayoutStatus) error
+	UpdatePayoutStatusTx(tx *sql.Tx, ctx context.Context, id int64, from domain.PayoutStatus, result domain.PayoutResult) error
+	UpdateProviderRef(ctx context.Context, id int64, ref string) error
+}
+
+type PayoutDBRepository struct {
+	*sql.DB
+}
+
+func NewPayoutRepository(db *sql.DB) PayoutRepository {
+	return &PayoutDBRepository{DB: db}
+}
+
+const payoutColumns = "id, user_id, amount, status, reference, reason, provider_ref, created_at, updated_at"
+
+func scanPayout(row interface{ Scan(...any) error }) (domain.Payout, error) {
+	var p domain.Payout
+	return p, row.Scan(&p.ID, &p.UserID, &p.Amount, &p.Status, &p.Reference, &p.Reason, &p.ProviderRef, &p.CreatedAt, &p.UpdatedAt)
+}
+
+func (r *PayoutDBRepository) AddPayoutTx(tx *sql.Tx, ctx context.Context, userID int64, amount int64) (int64, error) {
+	row := tx.QueryRowContext(ctx, "INSERT INTO payouts (user_id, amount, status) VALUES (?, ?, ?) RETURNING id", userID, amount, domain.PayoutStatusPending)
+	var id int64
+	return id, row.Scan(&id)
+}
+
+func (r *PayoutDBRepository) GetPayout(ctx context.Context, id int64) (domain.Payout, error) {
+	return scanPayout(r.QueryRowContext(ctx, "SELECT "+payoutColumns+" FROM payouts WHERE id = ?", id))
+}
+
+func (r *PayoutDBRepository) GetPayoutTx(tx *sql.Tx, ctx context.Context, id int64) (domain.Payout, error) {
+	return scanPayout(tx.QueryRowContext(ctx, "SELECT "+payoutColumns+" FROM payouts WHERE id = ?", id))
+}
+
+func (r *PayoutDBRepository) GetPayoutsByUserID(ctx context.Context, userID int64) ([]domain.Payout, error) {
+	return r.queryPayouts(ctx, "SELECT "+payoutColumns+" FROM payouts WHERE user_id = ? ORDER BY id desc", userID)
+}
+
+// GetPayoutsByStatus returns the payouts oldest first, so that they are processed in order
+func (r *PayoutDBRepository) GetPayoutsByStatus(ctx context.Context, status domain.PayoutStatus) ([]domain.Payout, error) {
+	return r.queryPayouts(ctx, "SELECT "+payoutColumns+" FROM payouts WHERE status = ? ORDER BY id", status)
+}
+
+func (r *PayoutDBRepository) queryPayouts(ctx context.Context, query string, args ...any) ([]domain.Payout, error) {
+	rows, err := r.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payouts []domain.Payout
+	for rows.Next() {
+		p, err := scanPayout(rows)
+		if err != nil {
+			return nil, err
+		}
+		payouts = append(payouts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return payouts, nil
+}
+
+// UpdatePayoutStatus moves the payout from one status to another. It returns ErrConflict if the status is no longer from.
+func (r *PayoutDBRepository) UpdatePayoutStatus(ctx context.Context, id int64, from domain.PayoutStatus, to domain.PayoutStatus) error {
+	res, err := r.ExecContext(ctx, "UPDATE payouts SET status = ?, updated_at = DATETIME('now', 'localtime') WHERE id = ? AND status = ?", to, id, from)
+	if err != nil {
+		return err
+	}
+	return checkUpdated(res)
+}
+
+// UpdatePayoutStatusTx settles the payout with the result. It returns ErrConflict if the status is no longer from.
+func (r *PayoutDBRepository) UpdatePayoutStatusTx(tx *sql.Tx, ctx context.Context, id int64, from domain.PayoutStatus, result domain.PayoutResult) error {
+	res, err := tx.ExecContext(ctx, "UPDATE payouts SET status = ?, reference = ?, reason = ?, updated_at = DATETIME('now', 'localtime') WHERE id = ? AND status = ?",
+		result.Status, result.Reference, result.Reason, id, from)
+	if err != nil {
+		return err
+	}
+	return checkUpdated(res)
+}
+
+// UpdateProviderRef keeps the reference of the transfer of a processing payout, which the provider has not finished.
+// It returns ErrConflict if the payout is no longer processing.
+func (r *PayoutDBRepository) UpdateProviderRef(ctx context.Context, id int64, ref string) error {
+	res, err := r.ExecContext(ctx, "UPDATE payouts SET provider_ref = ?, updated_at = DATETIME('now', 'localtime') WHERE id = ? AND status = ?", ref, id, domain.PayoutStatusProcessing)
+	if err != nil {
+		return err
+	}
+	return checkUpdated(res)
+}
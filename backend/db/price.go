@@ -0,0 +1,80 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+// PriceHistoryRepository reads the price changes EditItem records
+type PriceHistoryRepository interface {
+	GetPriceHistory(ctx context.Context, itemID int32) ([]domain.PriceChange, error)
+	GetHighestPrices(ctx context.Context, itemIDs []int32) (map[int32]int64, error)
+}
+
+type PriceHistoryDBRepository struct {
+	*sql.DB
+}
+
+func NewPriceHistoryRepository(db *sql.DB) PriceHistoryRepository {
+	return &PriceHistoryDBRepository{DB: db}
+}
+
+// GetPriceHistory returns the price changes of the item, the latest first
+func (r *PriceHistoryDBRepository) GetPriceHistory(ctx context.Context, itemID int32) ([]domain.PriceChange, error) {
+	rows, err := r.QueryContext(ctx, "SELECT id, item_id, old_price, new_price, created_at FROM price_history WHERE item_id = ? ORDER BY id desc", itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []domain.PriceChange
+	for rows.Next() {
+		var p domain.PriceChange
+		if err := rows.Scan(&p.ID, &p.ItemID, &p.OldPrice, &p.NewPrice, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// GetHighestPrices returns the highest price each of the items had before its current one. Items whose price never changed are missing.
+func (r *PriceHistoryDBRepository) GetHighestPrices(ctx context.Context, itemIDs []int32) (map[int32]int64, error) {
+	prices := make(map[int32]int64)
+	if len(itemIDs) == 0 {
+		return prices, nil
+	}
+	args := make([]any, len(itemIDs))
+	for i, id := range itemIDs {
+		args[i] = id
+	}
+
+	rows, err := r.QueryContext(ctx, "SELECT item_id, MAX(old_price) FROM price_history WHERE item_id IN ("+placeholders(len(itemIDs))+") GROUP BY item_id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var itemID int32
+		var price int64
+		if err := rows.Scan(&itemID, &price); err != nil {
+			return nil, err
+		}
+		prices[itemID] = price
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return prices, nil
+}
+
+func addPriceChangeTx(tx *sql.Tx, ctx context.Context, itemID int32, oldPrice int64, newPrice int64) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO price_history (item_id, old_price, new_price) VALUES (?, ?, ?)", itemID, oldPrice, newPrice)
+	return err
+}
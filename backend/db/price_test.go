@@ -0,0 +1,53 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+func TestGetHighestPrices(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	repo := NewItemRepository(db)
+	sellerID := addTestUser(t, db, "seller")
+
+	addItem := func(prices ...int64) int32 {
+		t.Helper()
+		itemID, err := repo.AddItem(ctx, domain.Item{Name: "item", Price: 1000, Description: "description", CategoryID: 1, UserID: sellerID, Status: domain.ItemStatusOnSale})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, price := range prices {
+			if _, err := repo.EditItem(ctx, domain.Item{ID: itemID, Price: price, UserID: sellerID}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return itemID
+	}
+	changed := addItem(800, 1200, 900)
+	other := addItem(500)
+	unchanged := addItem()
+
+	prices := NewPriceHistoryRepository(db)
+	for _, tt := range []struct {
+		itemIDs []int32
+		want    map[int32]int64
+	}{
+		// the prices of the other items are not read
+		{[]int32{changed, unchanged}, map[int32]int64{changed: 1200}},
+		{[]int32{changed, other}, map[int32]int64{changed: 1200, other: 1000}},
+		{[]int32{unchanged}, map[int32]int64{}},
+		{nil, map[int32]int64{}},
+	} {
+		got, err := prices.GetHighestPrices(ctx, tt.itemIDs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GetHighestPrices(%v) = %v, want %v", tt.itemIDs, got, tt.want)
+		}
+	}
+}
@@ -3,17 +3,30 @@ package db
 import (
 	"context"
 	"database/sql"
-	"strings"
+	"strconv"
+	"time"
 
 	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+	"github.com/pkg/errors"
+)
+
+// TimeLayout is the format of DATETIME('now', 'localtime')
+const TimeLayout = "2006-01-02 15:04:05"
+
+var (
+	// ErrConflict means a conditional update found the row already changed by another request
+	ErrConflict = errors.New("conflict")
+	// ErrNotEditable means the status of the item does not let the seller change it, see domain.ItemStatus.Editable
+	ErrNotEditable = errors.New("item cannot be edited in its status")
 )
 
 type UserRepository interface {
 	AddUser(ctx context.Context, user domain.User) (int64, error)
 	GetUser(ctx context.Context, id int64) (domain.User, error)
 	GetUserTx(tx *sql.Tx, ctx context.Context, id int64) (domain.User, error)
-	UpdateBalance(ctx context.Context, id int64, balance int64) error
-	UpdateBalanceTx(tx *sql.Tx, ctx context.Context, id int64, balance int64) error
+	DeleteUserTx(tx *sql.Tx, ctx context.Context, id int64) error
+	RestoreUser(ctx context.Context, id int64) error
+	PurgeUsers(ctx context.Context, deletedBefore time.Time) (int64, error)
 }
 
 type UserDBRepository struct {
@@ -30,94 +43,158 @@ func (r *UserDBRepository) AddUser(ctx context.Context, user domain.User) (int64
 	return id, row.Scan(&id)
 }
 
-func (r *UserDBRepository) GetUser(ctx context.Context, id int64) (domain.User, error) {
-	row := r.QueryRowContext(ctx, "SELECT * FROM users WHERE id = ?", id)
+const userColumns = "id, name, password, balance, COALESCE(deleted_at, '')"
 
+func scanUser(row interface{ Scan(...any) error }) (domain.User, error) {
 	var user domain.User
-	return user, row.Scan(&user.ID, &user.Name, &user.Password, &user.Balance)
+	return user, row.Scan(&user.ID, &user.Name, &user.Password, &user.Balance, &user.DeletedAt)
 }
 
-func (r *UserDBRepository) GetUserTx(tx *sql.Tx, ctx context.Context, id int64) (domain.User, error) {
-	row := tx.QueryRowContext(ctx, "SELECT * FROM users WHERE id = ?", id)
+func (r *UserDBRepository) GetUser(ctx context.Context, id int64) (domain.User, error) {
+	return scanUser(r.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = ? AND deleted_at IS NULL", id))
+}
 
-	var user domain.User
-	return user, row.Scan(&user.ID, &user.Name, &user.Password, &user.Balance)
+func (r *UserDBRepository) GetUserTx(tx *sql.Tx, ctx context.Context, id int64) (domain.User, error) {
+	return scanUser(tx.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = ? AND deleted_at IS NULL", id))
 }
 
-func (r *UserDBRepository) UpdateBalance(ctx context.Context, id int64, balance int64) error {
-	if _, err := r.ExecContext(ctx, "UPDATE users SET balance = ? WHERE id = ?", balance, id); err != nil {
+// DeleteUserTx soft deletes the user. It returns ErrConflict if the user is already deleted.
+func (r *UserDBRepository) DeleteUserTx(tx *sql.Tx, ctx context.Context, id int64) error {
+	res, err := tx.ExecContext(ctx, "UPDATE users SET deleted_at = DATETIME('now', 'localtime') WHERE id = ? AND deleted_at IS NULL", id)
+	if err != nil {
 		return err
 	}
-	return nil
+	return checkUpdated(res)
 }
 
-func (r *UserDBRepository) UpdateBalanceTx(tx *sql.Tx, ctx context.Context, id int64, balance int64) error {
-	if _, err := tx.ExecContext(ctx, "UPDATE users SET balance = ? WHERE id = ?", balance, id); err != nil {
+// RestoreUser undoes DeleteUserTx. It returns ErrConflict if the user is not deleted.
+func (r *UserDBRepository) RestoreUser(ctx context.Context, id int64) error {
+	res, err := r.ExecContext(ctx, "UPDATE users SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL", id)
+	if err != nil {
 		return err
 	}
-	return nil
+	return checkUpdated(res)
+}
+
+// PurgeUsers hard deletes the users deleted before deletedBefore along with their view history.
+// Their purchases and ledger entries are kept for the other party and for accounting.
+func (r *UserDBRepository) PurgeUsers(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	tx, err := r.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	before := deletedBefore.Format(TimeLayout)
+	// the items no longer count the likes and the views of the users
+	purged := "SELECT id FROM users WHERE deleted_at < ?"
+	if _, err := tx.ExecContext(ctx, "UPDATE items SET like_count = like_count - (SELECT COUNT(*) FROM likes WHERE item_id = items.id AND user_id IN ("+purged+")) WHERE id IN (SELECT item_id FROM likes WHERE user_id IN ("+purged+"))", before, before); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE items SET view_count = view_count - (SELECT COUNT(DISTINCT user_id) FROM history WHERE item_id = items.id AND user_id != items.seller_id AND user_id IN ("+purged+")) WHERE id IN (SELECT item_id FROM history WHERE user_id IN ("+purged+"))", before, before); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM history WHERE user_id IN (SELECT id FROM users WHERE deleted_at < ?)", before); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM likes WHERE user_id IN (SELECT id FROM users WHERE deleted_at < ?)", before); err != nil {
+		return 0, err
+	}
+	res, err := tx.ExecContext(ctx, "DELETE FROM users WHERE deleted_at < ?", before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
 }
 
 type ItemRepository interface {
 	AddItem(ctx context.Context, item domain.Item) (int32, error)
+	AddItemTx(tx *sql.Tx, ctx context.Context, item domain.Item) (int32, error)
 	GetItem(ctx context.Context, id int32) (domain.Item, error)
 	GetItemTx(tx *sql.Tx, ctx context.Context, id int32) (domain.Item, error)
-	GetItemImage(ctx context.Context, id int32) ([]byte, error)
-	GetOnSaleItems(ctx context.Context) ([]domain.Item, error)
-	GetItemsByUserID(ctx context.Context, userID int64) ([]domain.Item, error)
-	GetItemsByName(ctx context.Context, name string) ([]domain.Item, error)
-	GetOnSaleItemsByNameAndPrice(ctx context.Context, name string, priceMin int64, priceMax int64) ([]domain.Item, error)
-	GetOnSaleItemsByNameAndPriceAndCategory(ctx context.Context, name string, priceMin int64, priceMax int64, category_id int64) ([]domain.Item, error)
-	GetItemsByNameAndPrice(ctx context.Context, name string, priceMin int64, priceMax int64) ([]domain.Item, error)
-	GetItemsByNameAndPriceAndCategory(ctx context.Context, name string, priceMin int64, priceMax int64, category_id int64) ([]domain.Item, error)
+	GetItemWithDeletedTx(tx *sql.Tx, ctx context.Context, id int32) (domain.Item, error)
+	SearchItems(ctx context.Context, q ItemQuery) ([]domain.Item, *ItemCursor, error)
+	GetItemFacets(ctx context.Context, q ItemQuery, statuses []domain.ItemStatus, bounds []int64) (ItemFacets, error)
+	GetItemsByUserIDTx(tx *sql.Tx, ctx context.Context, userID int64) ([]domain.Item, error)
 	GetCategory(ctx context.Context, id int64) (domain.Category, error)
 	GetCategories(ctx context.Context) ([]domain.Category, error)
-	UpdateItemStatus(ctx context.Context, id int32, status domain.ItemStatus) error
-	UpdateItemStatusTx(tx *sql.Tx, ctx context.Context, id int32, status domain.ItemStatus) error
+	UpdateItemStatus(ctx context.Context, id int32, from domain.ItemStatus, to domain.ItemStatus) error
+	UpdateItemStatusTx(tx *sql.Tx, ctx context.Context, id int32, from domain.ItemStatus, to domain.ItemStatus) error
+	DeleteItemTx(tx *sql.Tx, ctx context.Context, id int32, from domain.ItemStatus) error
+	RestoreItemTx(tx *sql.Tx, ctx context.Context, id int32, from domain.ItemStatus) error
+	PurgeItems(ctx context.Context, deletedBefore time.Time) (int64, []string, error)
 	AddHistory(ctx context.Context, userID int64, itemID int32) error
 	GetViewCount(ctx context.Context, itemID int32) (int64, error)
+	// EditItem returns ErrNotEditable if the item cannot be edited anymore
 	EditItem(ctx context.Context, item domain.Item) (int32, error)
-	GetItemsByBuyerID(ctx context.Context, buyerID int64) ([]domain.Item, error)
 }
 
 type ItemDBRepository struct {
 	*sql.DB
+	// fullText is set if the searches use the FTS5 index of setupFullText
+	fullText bool
 }
 
 func NewItemRepository(db *sql.DB) ItemRepository {
-	return &ItemDBRepository{DB: db}
+	return &ItemDBRepository{DB: db, fullText: HasFullText(context.Background(), db)}
 }
 
+// AddItem adds item.ImageKey as the cover of the item
 func (r *ItemDBRepository) AddItem(ctx context.Context, item domain.Item) (int32, error) {
-	row := r.QueryRowContext(ctx, "INSERT INTO items (name, price, description, category_id, seller_id, image, status) VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id", item.Name, item.Price, item.Description, item.CategoryID, item.UserID, item.Image, item.Status)
+	tx, err := r.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
 
-	var id int32
-	return id, row.Scan(&id)
+	id, err := r.AddItemTx(tx, ctx, item)
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
 }
 
-func (r *ItemDBRepository) GetItem(ctx context.Context, id int32) (domain.Item, error) {
-	row := r.QueryRowContext(ctx, "SELECT * FROM items WHERE id = ?", id)
+func (r *ItemDBRepository) AddItemTx(tx *sql.Tx, ctx context.Context, item domain.Item) (int32, error) {
+	row := tx.QueryRowContext(ctx, "INSERT INTO items (name, price, description, category_id, seller_id, status) VALUES (?, ?, ?, ?, ?, ?) RETURNING id", item.Name, item.Price, item.Description, item.CategoryID, item.UserID, item.Status)
 
-	var item domain.Item
-	return item, row.Scan(&item.ID, &item.Name, &item.Price, &item.Description, &item.CategoryID, &item.UserID, &item.Image, &item.Status, &item.CreatedAt, &item.UpdatedAt)
+	var id int32
+	if err := row.Scan(&id); err != nil {
+		return 0, err
+	}
+	if item.ImageKey != "" {
+		if _, err := addImageTx(tx, ctx, id, item.ImageKey); err != nil {
+			return 0, err
+		}
+	}
+	return id, nil
 }
 
-func (r *ItemDBRepository) GetItemTx(tx *sql.Tx, ctx context.Context, id int32) (domain.Item, error) {
-	row := tx.QueryRowContext(ctx, "SELECT * FROM items WHERE id = ?", id)
+const itemColumns = "id, name, COALESCE(price, 0), description, category_id, seller_id, status, created_at, updated_at, COALESCE(deleted_at, '')"
 
+func scanItem(row interface{ Scan(...any) error }) (domain.Item, error) {
 	var item domain.Item
-	return item, row.Scan(&item.ID, &item.Name, &item.Price, &item.Description, &item.CategoryID, &item.UserID, &item.Image, &item.Status, &item.CreatedAt, &item.UpdatedAt)
+	return item, row.Scan(&item.ID, &item.Name, &item.Price, &item.Description, &item.CategoryID, &item.UserID, &item.Status, &item.CreatedAt, &item.UpdatedAt, &item.DeletedAt)
+}
+
+func (r *ItemDBRepository) GetItem(ctx context.Context, id int32) (domain.Item, error) {
+	return scanItem(r.QueryRowContext(ctx, "SELECT "+itemColumns+" FROM items WHERE id = ? AND deleted_at IS NULL", id))
+}
 
+func (r *ItemDBRepository) GetItemTx(tx *sql.Tx, ctx context.Context, id int32) (domain.Item, error) {
+	return scanItem(tx.QueryRowContext(ctx, "SELECT "+itemColumns+" FROM items WHERE id = ? AND deleted_at IS NULL", id))
 }
 
-func (r *ItemDBRepository) GetItemImage(ctx context.Context, id int32) ([]byte, error) {
-	row := r.QueryRowContext(ctx, "SELECT image FROM items WHERE id = ?", id)
-	var image []byte
-	return image, row.Scan(&image)
+// GetItemWithDeletedTx also returns the item if it was deleted
+func (r *ItemDBRepository) GetItemWithDeletedTx(tx *sql.Tx, ctx context.Context, id int32) (domain.Item, error) {
+	return scanItem(tx.QueryRowContext(ctx, "SELECT "+itemColumns+" FROM items WHERE id = ?", id))
 }
 
-func (r *ItemDBRepository) GetOnSaleItems(ctx context.Context) ([]domain.Item, error) {
-	rows, err := r.QueryContext(ctx, "SELECT * FROM items WHERE status = ? ORDER BY updated_at desc", domain.ItemStatusOnSale)
+func (r *ItemDBRepository) GetItemsByUserIDTx(tx *sql.Tx, ctx context.Context, userID int64) ([]domain.Item, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT "+itemColumns+" FROM items WHERE deleted_at IS NULL AND seller_id = ?", userID)
 	if err != nil {
 		return nil, err
 	}
@@ -125,8 +202,8 @@ func (r *ItemDBRepository) GetOnSaleItems(ctx context.Context) ([]domain.Item, e
 
 	var items []domain.Item
 	for rows.Next() {
-		var item domain.Item
-		if err := rows.Scan(&item.ID, &item.Name, &item.Price, &item.Description, &item.CategoryID, &item.UserID, &item.Image, &item.Status, &item.CreatedAt, &item.UpdatedAt); err != nil {
+		item, err := scanItem(rows)
+		if err != nil {
 			return nil, err
 		}
 		items = append(items, item)
@@ -137,147 +214,124 @@ func (r *ItemDBRepository) GetOnSaleItems(ctx context.Context) ([]domain.Item, e
 	return items, nil
 }
 
-func (r *ItemDBRepository) GetItemsByUserID(ctx context.Context, userID int64) ([]domain.Item, error) {
-	rows, err := r.QueryContext(ctx, "SELECT * FROM items WHERE seller_id = ?", userID)
+// UpdateItemStatus moves the item from one status to another. It returns a *domain.ItemTransitionError
+// if the lifecycle does not allow it, and ErrConflict if the status is no longer from.
+func (r *ItemDBRepository) UpdateItemStatus(ctx context.Context, id int32, from domain.ItemStatus, to domain.ItemStatus) error {
+	if err := domain.TransitionItem(from, to); err != nil {
+		return err
+	}
+	res, err := r.ExecContext(ctx, "UPDATE items SET status = ? WHERE id = ? AND status = ?", to, id, from)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
+	return checkUpdated(res)
+}
 
-	var items []domain.Item
-	for rows.Next() {
-		var item domain.Item
-		if err := rows.Scan(&item.ID, &item.Name, &item.Price, &item.Description, &item.CategoryID, &item.UserID, &item.Image, &item.Status, &item.CreatedAt, &item.UpdatedAt); err != nil {
-			return nil, err
-		}
-		items = append(items, item)
+// UpdateItemStatusTx is UpdateItemStatus within tx
+func (r *ItemDBRepository) UpdateItemStatusTx(tx *sql.Tx, ctx context.Context, id int32, from domain.ItemStatus, to domain.ItemStatus) error {
+	if err := domain.TransitionItem(from, to); err != nil {
+		return err
 	}
-	if err := rows.Err(); err != nil {
-		return nil, err
+	res, err := tx.ExecContext(ctx, "UPDATE items SET status = ? WHERE id = ? AND status = ?", to, id, from)
+	if err != nil {
+		return err
 	}
-	return items, nil
+	return checkUpdated(res)
 }
 
-func (r *ItemDBRepository) GetItemsByName(ctx context.Context, name string) ([]domain.Item, error) {
-	rows, err := r.QueryContext(ctx, "SELECT * FROM items WHERE name LIKE ?", "%"+name+"%")
+// DeleteItemTx soft deletes the item in status from, see domain.DeletedStatus for the status it takes
+func (r *ItemDBRepository) DeleteItemTx(tx *sql.Tx, ctx context.Context, id int32, from domain.ItemStatus) error {
+	to, err := domain.DeletedStatus(from)
 	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var items []domain.Item
-	for rows.Next() {
-		var item domain.Item
-		if err := rows.Scan(&item.ID, &item.Name, &item.Price, &item.Description, &item.CategoryID, &item.UserID, &item.Image, &item.Status, &item.CreatedAt, &item.UpdatedAt); err != nil {
-			return nil, err
-		}
-		items = append(items, item)
+		return err
 	}
-	if err := rows.Err(); err != nil {
-		return nil, err
+	res, err := tx.ExecContext(ctx, "UPDATE items SET status = ?, deleted_at = DATETIME('now', 'localtime') WHERE id = ? AND status = ? AND deleted_at IS NULL", to, id, from)
+	if err != nil {
+		return err
 	}
-	return items, nil
+	return checkUpdated(res)
 }
 
-func (r *ItemDBRepository) GetOnSaleItemsByNameAndPrice(ctx context.Context, name string, priceMin int64, priceMax int64) ([]domain.Item, error) {
-	rows, err := r.QueryContext(ctx, "SELECT * FROM items WHERE name LIKE ? AND price >= ? AND price <= ? AND status = ? ORDER BY updated_at desc",
-		"%"+name+"%", priceMin, priceMax, domain.ItemStatusOnSale)
+// RestoreItemTx undoes DeleteItemTx for the deleted item in status from, see domain.RestoredStatus for the status it takes
+func (r *ItemDBRepository) RestoreItemTx(tx *sql.Tx, ctx context.Context, id int32, from domain.ItemStatus) error {
+	to, err := domain.RestoredStatus(from)
 	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var items []domain.Item
-	for rows.Next() {
-		var item domain.Item
-		if err := rows.Scan(&item.ID, &item.Name, &item.Price, &item.Description, &item.CategoryID, &item.UserID, &item.Image, &item.Status, &item.CreatedAt, &item.UpdatedAt); err != nil {
-			return nil, err
-		}
-		items = append(items, item)
+		return err
 	}
-	if err := rows.Err(); err != nil {
-		return nil, err
+	res, err := tx.ExecContext(ctx, "UPDATE items SET status = ?, deleted_at = NULL WHERE id = ? AND status = ? AND deleted_at IS NOT NULL", to, id, from)
+	if err != nil {
+		return err
 	}
-	return items, nil
+	return checkUpdated(res)
 }
 
-func (r *ItemDBRepository) GetOnSaleItemsByNameAndPriceAndCategory(ctx context.Context, name string, priceMin int64, priceMax int64, category_id int64) ([]domain.Item, error) {
-	rows, err := r.QueryContext(ctx, "SELECT * FROM items WHERE name LIKE ? AND price >= ? AND price <= ? AND category_id = ? AND status = ? ORDER BY updated_at desc",
-		"%"+name+"%", priceMin, priceMax, category_id, domain.ItemStatusOnSale)
+// PurgeItems hard deletes the items deleted before deletedBefore along with their images and view history,
+// and returns the keys of the images to delete from the ImageStore.
+// Items which were sold are kept so that they stay in the buyer's purchase history.
+func (r *ItemDBRepository) PurgeItems(ctx context.Context, deletedBefore time.Time) (int64, []string, error) {
+	tx, err := r.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var items []domain.Item
-	for rows.Next() {
-		var item domain.Item
-		if err := rows.Scan(&item.ID, &item.Name, &item.Price, &item.Description, &item.CategoryID, &item.UserID, &item.Image, &item.Status, &item.CreatedAt, &item.UpdatedAt); err != nil {
-			return nil, err
-		}
-		items = append(items, item)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
+		return 0, nil, err
 	}
-	return items, nil
-}
+	defer tx.Rollback()
 
-func (r *ItemDBRepository) GetItemsByNameAndPrice(ctx context.Context, name string, priceMin int64, priceMax int64) ([]domain.Item, error) {
-	rows, err := r.QueryContext(ctx, "SELECT * FROM items WHERE name LIKE ? AND price >= ? AND price <= ? AND status IN (?,?) ORDER BY updated_at desc",
-		"%"+name+"%", priceMin, priceMax, domain.ItemStatusOnSale, domain.ItemStatusSoldOut)
+	const purgeable = "SELECT id FROM items WHERE deleted_at < ? AND NOT EXISTS (SELECT 1 FROM purchase WHERE purchase.item_id = items.id AND purchase.status != ?)"
+	before := deletedBefore.Format(TimeLayout)
+	rows, err := tx.QueryContext(ctx, "SELECT image_key FROM item_images WHERE image_key IS NOT NULL AND item_id IN ("+purgeable+")", before, domain.PurchaseStatusCancelled)
 	if err != nil {
-		return nil, err
+		return 0, nil, err
 	}
 	defer rows.Close()
-
-	var items []domain.Item
+	var keys []string
 	for rows.Next() {
-		var item domain.Item
-		if err := rows.Scan(&item.ID, &item.Name, &item.Price, &item.Description, &item.CategoryID, &item.UserID, &item.Image, &item.Status, &item.CreatedAt, &item.UpdatedAt); err != nil {
-			return nil, err
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return 0, nil, err
 		}
-		items = append(items, item)
+		keys = append(keys, key)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return 0, nil, err
 	}
-	return items, nil
-}
 
-func (r *ItemDBRepository) GetItemsByNameAndPriceAndCategory(ctx context.Context, name string, priceMin int64, priceMax int64, category_id int64) ([]domain.Item, error) {
-	rows, err := r.QueryContext(ctx, "SELECT * FROM items WHERE name LIKE ? AND price >= ? AND price <= ? AND category_id = ? AND status IN (?,?) ORDER BY updated_at desc",
-		"%"+name+"%", priceMin, priceMax, category_id, domain.ItemStatusOnSale, domain.ItemStatusSoldOut)
-	if err != nil {
-		return nil, err
+	if _, err := tx.ExecContext(ctx, "DELETE FROM history WHERE item_id IN ("+purgeable+")", before, domain.PurchaseStatusCancelled); err != nil {
+		return 0, nil, err
 	}
-	defer rows.Close()
-
-	var items []domain.Item
-	for rows.Next() {
-		var item domain.Item
-		if err := rows.Scan(&item.ID, &item.Name, &item.Price, &item.Description, &item.CategoryID, &item.UserID, &item.Image, &item.Status, &item.CreatedAt, &item.UpdatedAt); err != nil {
-			return nil, err
-		}
-		items = append(items, item)
+	if _, err := tx.ExecContext(ctx, "DELETE FROM item_revision_changes WHERE revision_id IN (SELECT id FROM item_revisions WHERE item_id IN ("+purgeable+"))", before, domain.PurchaseStatusCancelled); err != nil {
+		return 0, nil, err
 	}
-	if err := rows.Err(); err != nil {
-		return nil, err
+	if _, err := tx.ExecContext(ctx, "DELETE FROM item_revisions WHERE item_id IN ("+purgeable+")", before, domain.PurchaseStatusCancelled); err != nil {
+		return 0, nil, err
 	}
-	return items, nil
-}
-
-func (r *ItemDBRepository) UpdateItemStatus(ctx context.Context, id int32, status domain.ItemStatus) error {
-	if _, err := r.ExecContext(ctx, "UPDATE items SET status = ? WHERE id = ?", status, id); err != nil {
-		return err
+	if _, err := tx.ExecContext(ctx, "DELETE FROM price_history WHERE item_id IN ("+purgeable+")", before, domain.PurchaseStatusCancelled); err != nil {
+		return 0, nil, err
 	}
-	return nil
+	if _, err := tx.ExecContext(ctx, "DELETE FROM likes WHERE item_id IN ("+purgeable+")", before, domain.PurchaseStatusCancelled); err != nil {
+		return 0, nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM item_images WHERE item_id IN ("+purgeable+")", before, domain.PurchaseStatusCancelled); err != nil {
+		return 0, nil, err
+	}
+	res, err := tx.ExecContext(ctx, "DELETE FROM items WHERE id IN ("+purgeable+")", before, domain.PurchaseStatusCancelled)
+	if err != nil {
+		return 0, nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, nil, err
+	}
+	return n, keys, tx.Commit()
 }
 
-func (r *ItemDBRepository) UpdateItemStatusTx(tx *sql.Tx, ctx context.Context, id int32, status domain.ItemStatus) error {
-	if _, err := tx.ExecContext(ctx, "UPDATE items SET status = ? WHERE id = ?", status, id); err != nil {
+// checkUpdated returns ErrConflict if a conditional update changed nothing
+func checkUpdated(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
 		return err
 	}
+	if n == 0 {
+		return ErrConflict
+	}
 	return nil
 }
 
@@ -309,62 +363,138 @@ func (r *ItemDBRepository) GetCategories(ctx context.Context) ([]domain.Category
 	return cats, nil
 }
 
+// AddHistory records a view of the item by the user, or by an anonymous user if userID is -1.
+// The view count of the item counts the first view of each user other than its seller, and every anonymous view.
 func (r *ItemDBRepository) AddHistory(ctx context.Context, userID int64, itemID int32) error {
-	if userID == -1 {
-		_, err := r.ExecContext(ctx, "INSERT INTO history (item_id) VALUES (?)", itemID)
-		return err
-  } else {
-		_, err := r.ExecContext(ctx, "INSERT INTO history (user_id, item_id) VALUES (?, ?)", userID, itemID)
+	tx, err := r.BeginTx(ctx, nil)
+	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+
+	if userID == -1 {
+		if _, err := tx.ExecContext(ctx, "UPDATE items SET view_count = view_count + 1 WHERE id = ?", itemID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO history (item_id) VALUES (?)", itemID); err != nil {
+			return err
+		}
+	} else {
+		if _, err := tx.ExecContext(ctx, "UPDATE items SET view_count = view_count + 1 WHERE id = ? AND seller_id != ? AND NOT EXISTS (SELECT 1 FROM history WHERE item_id = ? AND user_id = ?)", itemID, userID, itemID, userID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO history (user_id, item_id) VALUES (?, ?)", userID, itemID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
+// viewCount counts the users who viewed items.id other than its seller, and every anonymous view
+const viewCount = "(SELECT COUNT(DISTINCT user_id) + COUNT(CASE WHEN user_id IS NULL THEN 1 END) FROM history WHERE item_id = items.id AND (user_id IS NULL OR user_id != items.seller_id))"
+
 func (r *ItemDBRepository) GetViewCount(ctx context.Context, itemID int32) (int64, error) {
-	row := r.QueryRowContext(ctx, "SELECT COUNT(DISTINCT user_id) + COUNT(CASE WHEN user_id IS NULL THEN 1 END) from history WHERE item_id = ? AND (user_id IS NULL OR user_id != (SELECT seller_id FROM items WHERE items.id = history.item_id))", itemID)
+	row := r.QueryRowContext(ctx, "SELECT view_count FROM items WHERE id = ?", itemID)
 
 	var count int64
 	return count, row.Scan(&count)
 }
 
+// countItemActivity counts the like and view counts of every item again from the likes and history tables
+func countItemActivity(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "UPDATE items SET like_count = "+likeCount+", view_count = "+viewCount)
+	return err
+}
+
+// EditItem updates the fields of item which are set, and records the ones which changed as a revision by item.UserID
 func (r *ItemDBRepository) EditItem(ctx context.Context, item domain.Item) (int32, error) {
+	tx, err := r.BeginTx(ctx, nil)
+	if err != nil {
+		return -1, err
+	}
+	defer tx.Rollback()
+
+	old, err := scanItem(tx.QueryRowContext(ctx, "SELECT "+itemColumns+" FROM items WHERE id = ? AND deleted_at IS NULL", item.ID))
+	if err != nil {
+		return -1, err
+	}
+	if !old.Status.Editable() {
+		return -1, ErrNotEditable
+	}
+
 	updateQuery := "UPDATE items SET "
 	updateValues := []interface{}{}
+	var changes []domain.ItemChange
 
 	if item.Name != "" {
 		updateQuery += "name=?, "
 		updateValues = append(updateValues, item.Name)
+		if item.Name != old.Name {
+			changes = append(changes, domain.ItemChange{Field: domain.ItemFieldName, Old: old.Name, New: item.Name})
+		}
 	}
 	if item.Price != 0 {
 		updateQuery += "price=?, "
 		updateValues = append(updateValues, item.Price)
+		if item.Price != old.Price {
+			changes = append(changes, domain.ItemChange{Field: domain.ItemFieldPrice, Old: strconv.FormatInt(old.Price, 10), New: strconv.FormatInt(item.Price, 10)})
+		}
 	}
 	if item.Description != "" {
 		updateQuery += "description=?, "
 		updateValues = append(updateValues, item.Description)
+		if item.Description != old.Description {
+			changes = append(changes, domain.ItemChange{Field: domain.ItemFieldDescription, Old: old.Description, New: item.Description})
+		}
 	}
 	if item.CategoryID != 0 {
 		updateQuery += "category_id=?, "
 		updateValues = append(updateValues, item.CategoryID)
+		if item.CategoryID != old.CategoryID {
+			changes = append(changes, domain.ItemChange{Field: domain.ItemFieldCategory, Old: strconv.FormatInt(old.CategoryID, 10), New: strconv.FormatInt(item.CategoryID, 10)})
+		}
 	}
 	if item.UserID != 0 {
 		updateQuery += "seller_id=?, "
 		updateValues = append(updateValues, item.UserID)
 	}
-	if item.Image != nil {
-		updateQuery += "image=?, "
-		updateValues = append(updateValues, item.Image)
-	}
-
-	updateQuery = strings.TrimSuffix(updateQuery, ", ")
 
-	updateQuery += " WHERE id=?"
+	updateQuery += "updated_at = DATETIME('now', 'localtime') WHERE id=? AND status=? AND deleted_at IS NULL"
 
-	updateValues = append(updateValues, item.ID)
+	updateValues = append(updateValues, item.ID, old.Status)
 
-	_, err := r.ExecContext(ctx, updateQuery, updateValues...)
+	res, err := tx.ExecContext(ctx, updateQuery, updateValues...)
 	if err != nil {
 		return -1, err
 	}
+	if err := checkUpdated(res); err != nil {
+		return -1, err
+	}
+	// the image replaces the cover
+	if item.ImageKey != "" {
+		var oldKey string
+		row := tx.QueryRowContext(ctx, "SELECT COALESCE(image_key, '') FROM item_images WHERE item_id = ? AND position = 0", item.ID)
+		if err := row.Scan(&oldKey); err != nil && err != sql.ErrNoRows {
+			return -1, err
+		}
+		if err := setCoverTx(tx, ctx, item.ID, item.ImageKey); err != nil {
+			return -1, err
+		}
+		changes = append(changes, domain.ItemChange{Field: domain.ItemFieldImage, Old: oldKey, New: item.ImageKey})
+	}
+	if len(changes) > 0 {
+		if err := addRevisionTx(tx, ctx, item.ID, item.UserID, changes); err != nil {
+			return -1, err
+		}
+	}
+	if item.Price != 0 && item.Price != old.Price {
+		if err := addPriceChangeTx(tx, ctx, item.ID, old.Price, item.Price); err != nil {
+			return -1, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return -1, err
+	}
 
 	//row := r.QueryRowContext(ctx, "SELECT * FROM items WHERE id=?", item.ID)
 
@@ -373,42 +503,130 @@ func (r *ItemDBRepository) EditItem(ctx context.Context, item domain.Item) (int3
 	return item.ID, nil
 }
 
-func (r *ItemDBRepository) GetItemsByBuyerID(ctx context.Context, buyerID int64) ([]domain.Item, error) {
-	rows, err := r.QueryContext(ctx, "SELECT items.id, name, price, category_id, status FROM items JOIN purchase ON items.id = purchase.item_id WHERE purchase.buyer_id = ?", buyerID)
+type PurchaseRepository interface {
+	AddPurchaseTx(tx *sql.Tx, ctx context.Context, purchase domain.Purchase) (int64, error)
+	GetPurchaseByItemID(ctx context.Context, itemID int32) (domain.Purchase, error)
+	GetPurchaseByItemIDTx(tx *sql.Tx, ctx context.Context, itemID int32) (domain.Purchase, error)
+	// GetShippedPurchasesBefore leaves out the purchases with a cancel request
+	GetShippedPurchasesBefore(ctx context.Context, shippedBefore time.Time) ([]domain.Purchase, error)
+	UpdatePurchaseStatusTx(tx *sql.Tx, ctx context.Context, id int64, status domain.PurchaseStatus) error
+	UpdateCancelRequestTx(tx *sql.Tx, ctx context.Context, id int64, requestedBy int64, reason string) error
+	GetSalesReport(ctx context.Context, from string, to string) ([]domain.SalesReport, error)
+	CountOpenPurchasesByUserTx(tx *sql.Tx, ctx context.Context, userID int64) (int64, error)
+}
+
+type PurchaseDBRepository struct {
+	*sql.DB
+}
+
+func NewPurchaseRepository(db *sql.DB) PurchaseRepository {
+	return &PurchaseDBRepository{DB: db}
+}
+
+const purchaseColumns = "id, item_id, buyer_id, seller_id, price, COALESCE(coupon_id, 0), discount, points, fee_rate_bp, fee_flat, fee_amount, seller_amount, status, created_at, updated_at, " +
+	"COALESCE(shipped_at, ''), COALESCE(received_at, ''), COALESCE(completed_at, ''), COALESCE(cancelled_at, ''), " +
+	"COALESCE(cancel_requested_by, 0), COALESCE(cancel_reason, '')"
+
+func scanPurchase(row interface{ Scan(...any) error }) (domain.Purchase, error) {
+	var p domain.Purchase
+	return p, row.Scan(&p.ID, &p.ItemID, &p.BuyerID, &p.SellerID, &p.Price, &p.CouponID, &p.Discount, &p.Points, &p.Fee.RateBP, &p.Fee.Flat, &p.Fee.Amount, &p.SellerAmount, &p.Status, &p.CreatedAt, &p.UpdatedAt, &p.ShippedAt, &p.ReceivedAt, &p.CompletedAt, &p.CancelledAt, &p.CancelRequestedBy, &p.CancelReason)
+}
+
+func (r *PurchaseDBRepository) AddPurchaseTx(tx *sql.Tx, ctx context.Context, purchase domain.Purchase) (int64, error) {
+	row := tx.QueryRowContext(ctx, "INSERT INTO purchase (item_id, buyer_id, seller_id, price, coupon_id, discount, points, fee_rate_bp, fee_flat, fee_amount, seller_amount, status) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id",
+		purchase.ItemID, purchase.BuyerID, purchase.SellerID, purchase.Price, nullInt64(purchase.CouponID), purchase.Discount, purchase.Points, purchase.Fee.RateBP, purchase.Fee.Flat, purchase.Fee.Amount, purchase.SellerAmount, purchase.Status)
+	var id int64
+	return id, row.Scan(&id)
+}
+
+// GetPurchaseByItemID returns the latest purchase of the item
+func (r *PurchaseDBRepository) GetPurchaseByItemID(ctx context.Context, itemID int32) (domain.Purchase, error) {
+	return scanPurchase(r.QueryRowContext(ctx, "SELECT "+purchaseColumns+" FROM purchase WHERE item_id = ? ORDER BY id desc LIMIT 1", itemID))
+}
+
+func (r *PurchaseDBRepository) GetPurchaseByItemIDTx(tx *sql.Tx, ctx context.Context, itemID int32) (domain.Purchase, error) {
+	return scanPurchase(tx.QueryRowContext(ctx, "SELECT "+purchaseColumns+" FROM purchase WHERE item_id = ? ORDER BY id desc LIMIT 1", itemID))
+}
+
+func (r *PurchaseDBRepository) GetShippedPurchasesBefore(ctx context.Context, shippedBefore time.Time) ([]domain.Purchase, error) {
+	rows, err := r.QueryContext(ctx, "SELECT "+purchaseColumns+" FROM purchase WHERE status = ? AND shipped_at < ? AND cancel_requested_by IS NULL",
+		domain.PurchaseStatusShipped, shippedBefore.Format(TimeLayout))
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var items []domain.Item
+	var purchases []domain.Purchase
 	for rows.Next() {
-		var item domain.Item
-		if err := rows.Scan(&item.ID, &item.Name, &item.Price, &item.CategoryID, &item.Status); err != nil {
+		p, err := scanPurchase(rows)
+		if err != nil {
 			return nil, err
 		}
-		items = append(items, item)
+		purchases = append(purchases, p)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
-	return items, nil
+	return purchases, nil
 }
 
-type PurchaseRepository interface {
-	AddPurchaseTx(tx *sql.Tx, ctx context.Context, itemID int32, buyerID int64) error
+// UpdatePurchaseStatusTx also stamps the time the purchase reached the status
+func (r *PurchaseDBRepository) UpdatePurchaseStatusTx(tx *sql.Tx, ctx context.Context, id int64, status domain.PurchaseStatus) error {
+	query := "UPDATE purchase SET status = ?, updated_at = DATETIME('now', 'localtime')"
+	switch status {
+	case domain.PurchaseStatusShipped:
+		query += ", shipped_at = DATETIME('now', 'localtime')"
+	case domain.PurchaseStatusReceived:
+		query += ", received_at = DATETIME('now', 'localtime')"
+	case domain.PurchaseStatusCompleted:
+		query += ", completed_at = DATETIME('now', 'localtime')"
+	case domain.PurchaseStatusCancelled:
+		query += ", cancelled_at = DATETIME('now', 'localtime')"
+	}
+	if _, err := tx.ExecContext(ctx, query+" WHERE id = ?", status, id); err != nil {
+		return err
+	}
+	return nil
 }
 
-type PurchaseDBRepository struct {
-	*sql.DB
-}
+// GetSalesReport sums up the purchases made in [from, to) which are not cancelled, per category
+func (r *PurchaseDBRepository) GetSalesReport(ctx context.Context, from string, to string) ([]domain.SalesReport, error) {
+	rows, err := r.QueryContext(ctx, `SELECT items.category_id, COUNT(*), SUM(purchase.price), SUM(purchase.fee_amount), SUM(purchase.seller_amount)
+		FROM purchase JOIN items ON items.id = purchase.item_id
+		WHERE purchase.status != ? AND purchase.created_at >= ? AND purchase.created_at < ?
+		GROUP BY items.category_id ORDER BY items.category_id`, domain.PurchaseStatusCancelled, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-func NewPurchaseRepository(db *sql.DB) PurchaseRepository {
-	return &PurchaseDBRepository{DB: db}
+	var reports []domain.SalesReport
+	for rows.Next() {
+		var report domain.SalesReport
+		if err := rows.Scan(&report.CategoryID, &report.Count, &report.Price, &report.Fee, &report.SellerAmount); err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return reports, nil
 }
 
-func (r *PurchaseDBRepository) AddPurchaseTx(tx *sql.Tx, ctx context.Context, itemID int32, buyerID int64) error {
-	if _, err := tx.ExecContext(ctx, "INSERT INTO purchase (item_id, buyer_id) VALUES (?, ?)", itemID, buyerID); err != nil {
+// UpdateCancelRequestTx records who asked to cancel and why. requestedBy 0 withdraws the request.
+func (r *PurchaseDBRepository) UpdateCancelRequestTx(tx *sql.Tx, ctx context.Context, id int64, requestedBy int64, reason string) error {
+	if _, err := tx.ExecContext(ctx, "UPDATE purchase SET cancel_requested_by = ?, cancel_reason = ?, updated_at = DATETIME('now', 'localtime') WHERE id = ?",
+		nullInt64(requestedBy), reason, id); err != nil {
 		return err
 	}
 	return nil
 }
+
+// CountOpenPurchasesByUserTx counts the purchases of the user as a buyer or a seller which are not settled yet
+func (r *PurchaseDBRepository) CountOpenPurchasesByUserTx(tx *sql.Tx, ctx context.Context, userID int64) (int64, error) {
+	row := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM purchase WHERE (buyer_id = ? OR seller_id = ?) AND status IN (?, ?, ?)",
+		userID, userID, domain.PurchaseStatusPaid, domain.PurchaseStatusShipped, domain.PurchaseStatusReceived)
+	var count int64
+	return count, row.Scan(&count)
+}
@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+func TestEditItemStatus(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	repo := NewItemRepository(db)
+	sellerID := addTestUser(t, db, "seller")
+
+	for _, tt := range []struct {
+		status  domain.ItemStatus
+		wantErr error
+	}{
+		{domain.ItemStatusInitial, nil},
+		{domain.ItemStatusOnSale, nil},
+		{domain.ItemStatusPaused, nil},
+		{domain.ItemStatusSoldOut, ErrNotEditable},
+		{domain.ItemStatusCompleted, ErrNotEditable},
+		{domain.ItemStatusCancelled, ErrNotEditable},
+	} {
+		itemID, err := repo.AddItem(ctx, domain.Item{Name: "item", Price: 1000, Description: "description", CategoryID: 1, UserID: sellerID, Status: tt.status})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.EditItem(ctx, domain.Item{ID: itemID, Price: 500, UserID: sellerID}); err != tt.wantErr {
+			t.Errorf("EditItem() of a %v item error = %v, want %v", tt.status, err, tt.wantErr)
+		}
+
+		item, err := repo.GetItem(ctx, itemID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := int64(500)
+		if tt.wantErr != nil {
+			want = 1000
+		}
+		if item.Price != want {
+			t.Errorf("price of a %v item = %d, want %d", tt.status, item.Price, want)
+		}
+	}
+}
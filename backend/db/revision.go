@@ -0,0 +1,95 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+// RevisionRepository reads the revisions EditItem records for every edit of an item
+type RevisionRepository interface {
+	GetRevisions(ctx context.Context, itemID int32) ([]domain.ItemRevision, error)
+	GetChangedAt(ctx context.Context, itemID int32) (map[domain.ItemField]string, error)
+}
+
+type RevisionDBRepository struct {
+	*sql.DB
+}
+
+func NewRevisionRepository(db *sql.DB) RevisionRepository {
+	return &RevisionDBRepository{DB: db}
+}
+
+// GetRevisions returns the revisions of the item, the latest first
+func (r *RevisionDBRepository) GetRevisions(ctx context.Context, itemID int32) ([]domain.ItemRevision, error) {
+	rows, err := r.QueryContext(ctx, "SELECT item_revisions.id, item_revisions.item_id, item_revisions.user_id, item_revisions.created_at, "+
+		"item_revision_changes.field, item_revision_changes.old_value, item_revision_changes.new_value "+
+		"FROM item_revisions JOIN item_revision_changes ON item_revision_changes.revision_id = item_revisions.id "+
+		"WHERE item_revisions.item_id = ? ORDER BY item_revisions.id desc, item_revision_changes.rowid", itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []domain.ItemRevision
+	for rows.Next() {
+		var rev domain.ItemRevision
+		var change domain.ItemChange
+		if err := rows.Scan(&rev.ID, &rev.ItemID, &rev.UserID, &rev.CreatedAt, &change.Field, &change.Old, &change.New); err != nil {
+			return nil, err
+		}
+		// the changes of a revision come in a row
+		if n := len(revisions); n > 0 && revisions[n-1].ID == rev.ID {
+			revisions[n-1].Changes = append(revisions[n-1].Changes, change)
+			continue
+		}
+		rev.Changes = []domain.ItemChange{change}
+		revisions = append(revisions, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// GetChangedAt returns when each field of the item was last changed. Fields never changed are missing.
+func (r *RevisionDBRepository) GetChangedAt(ctx context.Context, itemID int32) (map[domain.ItemField]string, error) {
+	rows, err := r.QueryContext(ctx, "SELECT item_revision_changes.field, MAX(item_revisions.created_at) "+
+		"FROM item_revisions JOIN item_revision_changes ON item_revision_changes.revision_id = item_revisions.id "+
+		"WHERE item_revisions.item_id = ? GROUP BY item_revision_changes.field", itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changedAt := make(map[domain.ItemField]string)
+	for rows.Next() {
+		var field domain.ItemField
+		var at string
+		if err := rows.Scan(&field, &at); err != nil {
+			return nil, err
+		}
+		changedAt[field] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return changedAt, nil
+}
+
+// addRevisionTx records an edit of the item by the user
+func addRevisionTx(tx *sql.Tx, ctx context.Context, itemID int32, userID int64, changes []domain.ItemChange) error {
+	row := tx.QueryRowContext(ctx, "INSERT INTO item_revisions (item_id, user_id) VALUES (?, ?) RETURNING id", itemID, userID)
+	var id int64
+	if err := row.Scan(&id); err != nil {
+		return err
+	}
+	for _, change := range changes {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO item_revision_changes (revision_id, field, old_value, new_value) VALUES (?, ?, ?, ?)",
+			id, change.Field, change.Old, change.New); err != nil {
+			return err
+		}
+	}
+	return nil
+}
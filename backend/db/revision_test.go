@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+func TestEditItemRevisions(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	items := NewItemRepository(db)
+	revisions := NewRevisionRepository(db)
+	sellerID := addTestUser(t, db, "seller")
+	itemID, err := items.AddItem(ctx, domain.Item{Name: "item", Price: 1000, Description: "description", CategoryID: 1, UserID: sellerID, Status: domain.ItemStatusOnSale})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the name and the description are sent as they were, only the price changes
+	if _, err := items.EditItem(ctx, domain.Item{ID: itemID, Name: "item", Price: 800, Description: "description", UserID: sellerID}); err != nil {
+		t.Fatal(err)
+	}
+	// nothing changes, which is no revision
+	if _, err := items.EditItem(ctx, domain.Item{ID: itemID, Price: 800, UserID: sellerID}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := items.EditItem(ctx, domain.Item{ID: itemID, Description: "new description", CategoryID: 2, UserID: sellerID}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := revisions.GetRevisions(ctx, itemID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]domain.ItemChange{
+		{
+			{Field: domain.ItemFieldDescription, Old: "description", New: "new description"},
+			{Field: domain.ItemFieldCategory, Old: "1", New: "2"},
+		},
+		{{Field: domain.ItemFieldPrice, Old: "1000", New: "800"}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("revisions = %+v, want %d", got, len(want))
+	}
+	for i, rev := range got {
+		if rev.ItemID != itemID || rev.UserID != sellerID || !reflect.DeepEqual(rev.Changes, want[i]) {
+			t.Errorf("revision %d = %+v, want the changes %+v by %d", i, rev, want[i], sellerID)
+		}
+	}
+
+	changedAt, err := revisions.GetChangedAt(ctx, itemID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []domain.ItemField{domain.ItemFieldPrice, domain.ItemFieldDescription, domain.ItemFieldCategory} {
+		if changedAt[field] == "" {
+			t.Errorf("changed at of %s is missing", field)
+		}
+	}
+	if at, ok := changedAt[domain.ItemFieldName]; ok {
+		t.Errorf("changed at of the name = %q, want missing", at)
+	}
+}
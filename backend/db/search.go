@@ -0,0 +1,338 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+	"github.com/pkg/errors"
+)
+
+// fullTextSchema indexes the name and the description of items in trigrams, so that words are found anywhere,
+// also in Japanese text without spaces. The index reads the text from items and triggers keep it in sync.
+const fullTextSchema = `
+CREATE VIRTUAL TABLE IF NOT EXISTS items_fts USING fts5(name, description, content='items', content_rowid='id', tokenize='trigram');
+
+CREATE TRIGGER IF NOT EXISTS items_fts_insert AFTER INSERT ON items BEGIN
+    INSERT INTO items_fts (rowid, name, description) VALUES (new.id, new.name, new.description);
+END;
+
+CREATE TRIGGER IF NOT EXISTS items_fts_delete AFTER DELETE ON items BEGIN
+    INSERT INTO items_fts (items_fts, rowid, name, description) VALUES ('delete', old.id, old.name, old.description);
+END;
+
+CREATE TRIGGER IF NOT EXISTS items_fts_update AFTER UPDATE OF name, description ON items BEGIN
+    INSERT INTO items_fts (items_fts, rowid, name, description) VALUES ('delete', old.id, old.name, old.description);
+    INSERT INTO items_fts (rowid, name, description) VALUES (new.id, new.name, new.description);
+END;
+
+-- items may have been recreated without the triggers by /initialize
+INSERT INTO items_fts (items_fts) VALUES ('rebuild');
+`
+
+// the triggers of fullTextSchema would fail every write to items without FTS5
+const dropFullTextTriggers = `
+DROP TRIGGER IF EXISTS items_fts_insert;
+DROP TRIGGER IF EXISTS items_fts_delete;
+DROP TRIGGER IF EXISTS items_fts_update;
+`
+
+// minTrigramLength is the shortest word the trigram index can find
+const minTrigramLength = 3
+
+// HasFullText tells whether SQLite was built with FTS5, which go-sqlite3 does with the sqlite_fts5 build tag.
+// Without it searches use LIKE.
+func HasFullText(ctx context.Context, db *sql.DB) bool {
+	var used bool
+	if err := db.QueryRowContext(ctx, "SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&used); err != nil {
+		return false
+	}
+	return used
+}
+
+// setupFullText creates and fills the search index of items, or removes its triggers if FTS5 is not available
+func setupFullText(ctx context.Context, db *sql.DB) error {
+	if !HasFullText(ctx, db) {
+		_, err := db.ExecContext(ctx, dropFullTextTriggers)
+		return err
+	}
+	_, err := db.ExecContext(ctx, fullTextSchema)
+	return err
+}
+
+// searchTerm is a word or a "quoted phrase" of a search, and prefix is set for word*
+type searchTerm struct {
+	text   string
+	prefix bool
+}
+
+// parseSearch splits a search into its terms. Every term has to be found in the name or the description.
+func parseSearch(search string) []searchTerm {
+	var terms []searchTerm
+	for search = strings.TrimSpace(search); search != ""; search = strings.TrimSpace(search) {
+		var term searchTerm
+		if search[0] == '"' {
+			// an unclosed quote runs to the end
+			end := strings.IndexByte(search[1:], '"')
+			if end < 0 {
+				term.text, search = search[1:], ""
+			} else {
+				term.text, search = search[1:end+1], search[end+2:]
+			}
+			if strings.HasPrefix(search, "*") {
+				term.prefix, search = true, search[1:]
+			}
+		} else {
+			end := strings.IndexFunc(search, unicode.IsSpace)
+			if end < 0 {
+				end = len(search)
+			}
+			term.text, search = search[:end], search[end:]
+			if strings.HasSuffix(term.text, "*") {
+				term.text, term.prefix = strings.TrimRight(term.text, "*"), true
+			}
+		}
+		if term.text = strings.TrimSpace(term.text); term.text != "" {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// fullTextFilter returns the FTS5 query of the terms long enough for the trigram index,
+// and a LIKE condition on the name and the description for the others
+func fullTextFilter(terms []searchTerm) (match string, where string, args []any) {
+	var matches, conditions []string
+	for _, term := range terms {
+		if utf8.RuneCountInString(term.text) < minTrigramLength {
+			conditions = append(conditions, "(items.name LIKE ? OR items.description LIKE ?)")
+			args = append(args, "%"+term.text+"%", "%"+term.text+"%")
+			continue
+		}
+		// a quoted FTS5 string is a phrase, with its quotes doubled
+		m := `"` + strings.ReplaceAll(term.text, `"`, `""`) + `"`
+		if term.prefix {
+			m += "*"
+		}
+		matches = append(matches, m)
+	}
+	return strings.Join(matches, " "), strings.Join(conditions, " AND "), args
+}
+
+// ItemSort is the order of the items SearchItems returns
+type ItemSort int
+
+const (
+	// ItemSortUpdated puts the recently updated items first
+	ItemSortUpdated ItemSort = iota
+	// ItemSortRelevance puts the items most relevant to the searched name first.
+	// It is ItemSortUpdated without a name to search or without the FTS5 index.
+	ItemSortRelevance
+	// ItemSortNewest puts the recently listed items first
+	ItemSortNewest
+	ItemSortPriceAsc
+	ItemSortPriceDesc
+	// ItemSortViews puts the items viewed by the most users first, counted as in GetViewCount
+	ItemSortViews
+	ItemSortLikes
+)
+
+// itemPrice is the price items are sorted and filtered by. Items listed before the price was required have none,
+// and are taken as free.
+const itemPrice = "COALESCE(items.price, 0)"
+
+// itemOrders are the column each sort is keyed on, and whether it is descending. Ties are broken by the id in the same direction.
+var itemOrders = map[ItemSort]struct {
+	key  string
+	desc bool
+}{
+	ItemSortUpdated:   {"items.updated_at", true},
+	ItemSortRelevance: {"matches.rank", false},
+	ItemSortNewest:    {"items.created_at", true},
+	ItemSortPriceAsc:  {itemPrice, false},
+	ItemSortPriceDesc: {itemPrice, true},
+	ItemSortViews:     {"items.view_count", true},
+	ItemSortLikes:     {"items.like_count", true},
+}
+
+// ItemCursor is the position of an item in a sort. SearchItems continues after it.
+type ItemCursor struct {
+	// Key is the value of the item's sort key, which only SearchItems knows for some sorts
+	Key any
+	ID  int32
+}
+
+// ItemQuery selects the items of SearchItems. Zero fields do not filter.
+type ItemQuery struct {
+	// Name is searched for as described in parseSearch
+	Name        string
+	PriceMin    int64
+	PriceMax    int64
+	CategoryIDs []int64
+	Statuses    []domain.ItemStatus
+	SellerID    int64
+	// BuyerID selects the items the user bought, without cancelled purchases
+	BuyerID      int64
+	CreatedAfter time.Time
+	// WithDeleted also returns deleted items
+	WithDeleted bool
+	Sort        ItemSort
+	After       *ItemCursor
+	// Limit is the most items returned
+	Limit int
+}
+
+// itemFilter is the FROM and the WHERE of the items of a query, with their arguments in order
+type itemFilter struct {
+	from       string
+	conditions []string
+	args       []any
+	// matched is set if from joins the full-text matches and their rank
+	matched bool
+}
+
+func (f *itemFilter) where(condition string, args ...any) {
+	f.conditions = append(f.conditions, condition)
+	f.args = append(f.args, args...)
+}
+
+func (f itemFilter) String() string {
+	if len(f.conditions) == 0 {
+		return " FROM " + f.from
+	}
+	return " FROM " + f.from + " WHERE " + strings.Join(f.conditions, " AND ")
+}
+
+// filterItems returns the filter of every field of the query but Sort, After and Limit
+func (r *ItemDBRepository) filterItems(q ItemQuery) itemFilter {
+	f := itemFilter{from: "items"}
+	if !r.fullText {
+		if q.Name != "" {
+			f.where("items.name LIKE ?", "%"+q.Name+"%")
+		}
+	} else if terms := parseSearch(q.Name); len(terms) > 0 {
+		match, like, likeArgs := fullTextFilter(terms)
+		if match != "" {
+			f.from += " JOIN (SELECT rowid AS match_id, rank FROM items_fts WHERE items_fts MATCH ?) AS matches ON matches.match_id = items.id"
+			f.args = append(f.args, match)
+			f.matched = true
+		}
+		if like != "" {
+			f.where(like, likeArgs...)
+		}
+	}
+
+	if q.PriceMin != 0 {
+		f.where(itemPrice+" >= ?", q.PriceMin)
+	}
+	if q.PriceMax != 0 {
+		f.where(itemPrice+" <= ?", q.PriceMax)
+	}
+	if len(q.CategoryIDs) > 0 {
+		var ids []any
+		for _, id := range q.CategoryIDs {
+			ids = append(ids, id)
+		}
+		f.where("items.category_id IN ("+placeholders(len(ids))+")", ids...)
+	}
+	if len(q.Statuses) > 0 {
+		var statuses []any
+		for _, status := range q.Statuses {
+			statuses = append(statuses, status)
+		}
+		f.where("items.status IN ("+placeholders(len(statuses))+")", statuses...)
+	}
+	if q.SellerID != 0 {
+		f.where("items.seller_id = ?", q.SellerID)
+	}
+	if q.BuyerID != 0 {
+		f.where("items.id IN (SELECT item_id FROM purchase WHERE buyer_id = ? AND status != ?)", q.BuyerID, domain.PurchaseStatusCancelled)
+	}
+	if !q.CreatedAfter.IsZero() {
+		f.where("items.created_at > ?", q.CreatedAfter.Format(TimeLayout))
+	}
+	if !q.WithDeleted {
+		f.where("items.deleted_at IS NULL")
+	}
+	return f
+}
+
+// SearchItems returns the items of the query. If Limit cut the results, it also returns the cursor to continue from.
+func (r *ItemDBRepository) SearchItems(ctx context.Context, q ItemQuery) ([]domain.Item, *ItemCursor, error) {
+	order, ok := itemOrders[q.Sort]
+	if !ok {
+		return nil, nil, errors.Errorf("unknown sort %d", q.Sort)
+	}
+	f := r.filterItems(q)
+	if q.Sort == ItemSortRelevance && !f.matched {
+		order = itemOrders[ItemSortUpdated]
+	}
+
+	direction, op := "asc", ">"
+	if order.desc {
+		direction, op = "desc", "<"
+	}
+	if q.After != nil {
+		f.where(fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND items.id %[2]s ?))", order.key, op), q.After.Key, q.After.Key, q.After.ID)
+	}
+
+	query := "SELECT " + itemColumns + ", " + order.key + f.String() + " ORDER BY " + order.key + " " + direction + ", items.id " + direction
+	args := f.args
+	if q.Limit > 0 {
+		// one more tells whether there is a next page
+		query += " LIMIT ?"
+		args = append(args, q.Limit+1)
+	}
+
+	rows, err := r.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var items []domain.Item
+	var next *ItemCursor
+	for rows.Next() {
+		if q.Limit > 0 && len(items) == q.Limit {
+			next.ID = items[len(items)-1].ID
+			return items, next, nil
+		}
+		var key any
+		item, err := scanItem(keyedRow{rows, &key})
+		if err != nil {
+			return nil, nil, err
+		}
+		items = append(items, item)
+		next = &ItemCursor{Key: key}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	return items, nil, nil
+}
+
+// keyedRow scans the sort key SearchItems selects after the item
+type keyedRow struct {
+	*sql.Rows
+	key *any
+}
+
+func (r keyedRow) Scan(dest ...any) error {
+	if err := r.Rows.Scan(append(dest, r.key)...); err != nil {
+		return err
+	}
+	// text comes as bytes, which would not survive being encoded in a cursor
+	if b, ok := (*r.key).([]byte); ok {
+		*r.key = string(b)
+	}
+	return nil
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
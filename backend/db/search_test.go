@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+func TestParseSearch(t *testing.T) {
+	tests := []struct {
+		search string
+		want   []searchTerm
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"red shirt", []searchTerm{{text: "red"}, {text: "shirt"}}},
+		{"  red\tshirt  ", []searchTerm{{text: "red"}, {text: "shirt"}}},
+		{`"red shirt"`, []searchTerm{{text: "red shirt"}}},
+		{`"red shirt" blue`, []searchTerm{{text: "red shirt"}, {text: "blue"}}},
+		{"shi*", []searchTerm{{text: "shi", prefix: true}}},
+		{"shi**", []searchTerm{{text: "shi", prefix: true}}},
+		{`"red shi"*`, []searchTerm{{text: "red shi", prefix: true}}},
+		// an unclosed quote runs to the end
+		{`blue "red shirt`, []searchTerm{{text: "blue"}, {text: "red shirt"}}},
+		{`"" * "  "`, nil},
+		{"赤いシャツ", []searchTerm{{text: "赤いシャツ"}}},
+	}
+	for _, tt := range tests {
+		if got := parseSearch(tt.search); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSearch(%q) = %+v, want %+v", tt.search, got, tt.want)
+		}
+	}
+}
+
+func TestFullTextFilter(t *testing.T) {
+	tests := []struct {
+		search    string
+		wantMatch string
+		wantWhere string
+		wantArgs  []any
+	}{
+		{"red shirt", `"red" "shirt"`, "", nil},
+		{`"red shirt" shi*`, `"red shirt" "shi"*`, "", nil},
+		// quotes are doubled in FTS5 strings
+		{`it"s`, `"it""s"`, "", nil},
+		// words too short for trigrams are looked up with LIKE
+		{"T shirt", `"shirt"`, "(items.name LIKE ? OR items.description LIKE ?)", []any{"%T%", "%T%"}},
+		{"赤い", "", "(items.name LIKE ? OR items.description LIKE ?)", []any{"%赤い%", "%赤い%"}},
+		{"赤いシャツ", `"赤いシャツ"`, "", nil},
+	}
+	for _, tt := range tests {
+		match, where, args := fullTextFilter(parseSearch(tt.search))
+		if match != tt.wantMatch || where != tt.wantWhere || !reflect.DeepEqual(args, tt.wantArgs) {
+			t.Errorf("fullTextFilter(%q) = %q, %q, %v, want %q, %q, %v", tt.search, match, where, args, tt.wantMatch, tt.wantWhere, tt.wantArgs)
+		}
+	}
+}
+
+// TestSearchItems covers the filters of the GetItemsBy* variants SearchItems replaced, and their combinations
+func TestSearchItems(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	repo := NewItemRepository(db)
+	sellerID := addTestUser(t, db, "seller")
+	otherID := addTestUser(t, db, "other")
+	buyerID := addTestUser(t, db, "buyer")
+
+	var ids []int32
+	for _, item := range []domain.Item{
+		{Name: "red camera", Price: 1000, CategoryID: 1, UserID: sellerID, Status: domain.ItemStatusOnSale},
+		{Name: "blue camera", Price: 5000, CategoryID: 2, UserID: sellerID, Status: domain.ItemStatusOnSale},
+		{Name: "camera bag", Price: 3000, CategoryID: 1, UserID: otherID, Status: domain.ItemStatusSoldOut},
+		{Name: "red shoes", Price: 2000, CategoryID: 1, UserID: otherID, Status: domain.ItemStatusOnSale},
+		{Name: "old camera", Price: 500, CategoryID: 1, UserID: sellerID, Status: domain.ItemStatusOnSale},
+	} {
+		item.Description = "description"
+		id, err := repo.AddItem(ctx, item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if _, err := db.Exec("UPDATE items SET deleted_at = DATETIME('now', 'localtime') WHERE id = ?", ids[4]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO purchase (item_id, buyer_id, seller_id, price, seller_amount, status) VALUES (?, ?, ?, 3000, 3000, ?)", ids[2], buyerID, otherID, domain.PurchaseStatusPaid); err != nil {
+		t.Fatal(err)
+	}
+	onSale := []domain.ItemStatus{domain.ItemStatusOnSale}
+
+	// the items are all updated in the same second, so the latest updated first is the latest added first
+	tests := []struct {
+		name  string
+		query ItemQuery
+		want  []int32
+	}{
+		{"all", ItemQuery{}, []int32{ids[3], ids[2], ids[1], ids[0]}},
+		{"on sale", ItemQuery{Statuses: onSale}, []int32{ids[3], ids[1], ids[0]}},
+		{"seller", ItemQuery{SellerID: sellerID}, []int32{ids[1], ids[0]}},
+		{"seller with deleted", ItemQuery{SellerID: sellerID, WithDeleted: true}, []int32{ids[4], ids[1], ids[0]}},
+		{"buyer", ItemQuery{BuyerID: buyerID}, []int32{ids[2]}},
+		{"name", ItemQuery{Name: "camera"}, []int32{ids[2], ids[1], ids[0]}},
+		{"name and price", ItemQuery{Name: "camera", PriceMin: 1000, PriceMax: 3000}, []int32{ids[2], ids[0]}},
+		{"name, price and category", ItemQuery{Name: "camera", PriceMin: 2000, CategoryIDs: []int64{1}}, []int32{ids[2]}},
+		{"name and price on sale", ItemQuery{Name: "camera", PriceMax: 3000, Statuses: onSale}, []int32{ids[0]}},
+		{"categories on sale", ItemQuery{CategoryIDs: []int64{1, 2}, Statuses: onSale}, []int32{ids[3], ids[1], ids[0]}},
+		{"seller on sale in a category", ItemQuery{SellerID: otherID, CategoryIDs: []int64{1}, Statuses: onSale}, []int32{ids[3]}},
+		{"nothing", ItemQuery{Name: "camera", SellerID: otherID, Statuses: onSale}, nil},
+	}
+	for _, tt := range tests {
+		items, next, err := repo.SearchItems(ctx, tt.query)
+		if err != nil {
+			t.Fatalf("%s: SearchItems() error = %v", tt.name, err)
+		}
+		var got []int32
+		for _, item := range items {
+			got = append(got, item.ID)
+		}
+		if !reflect.DeepEqual(got, tt.want) || next != nil {
+			t.Errorf("%s: SearchItems() = %v, next %+v, want %v", tt.name, got, next, tt.want)
+		}
+	}
+}
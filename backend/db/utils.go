@@ -46,6 +46,24 @@ func Initialize(ctx context.Context, db *sql.DB) error {
 		}
 	}
 
+	if err = moveItemImages(ctx, db); err != nil {
+		return errors.Wrap(err, "Failed to move item images")
+	}
+
+	// the data only has the likes and the views
+	if err = countItemActivity(ctx, db); err != nil {
+		return errors.Wrap(err, "Failed to count likes and views")
+	}
+
+	// the cleanup dropped the search triggers with items
+	if err = setupFullText(ctx, db); err != nil {
+		return errors.Wrap(err, "Failed to set up full-text search")
+	}
+
+	if err = markMigrated(ctx, db); err != nil {
+		return errors.Wrap(err, "Failed to set the schema version")
+	}
+
 	return nil
 }
 
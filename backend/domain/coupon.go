@@ -0,0 +1,43 @@
+package domain
+
+type CouponDiscountType string
+
+const (
+	// CouponDiscountPercent takes DiscountValue percent off the price
+	CouponDiscountPercent CouponDiscountType = "percent"
+	// CouponDiscountAmount takes DiscountValue yen off the price
+	CouponDiscountAmount CouponDiscountType = "amount"
+)
+
+type Coupon struct {
+	ID            int64
+	Code          string
+	DiscountType  CouponDiscountType
+	DiscountValue int64
+	// MaxDiscount caps a percent discount, 0 means no cap
+	MaxDiscount int64
+	MinPrice    int64
+	// UsageLimit and PerUserLimit are the number of redemptions allowed, 0 means unlimited
+	UsageLimit   int64
+	PerUserLimit int64
+	UsedCount    int64
+	ExpiresAt    string
+	CreatedAt    string
+}
+
+func (c Coupon) Discount(price int64) int64 {
+	var discount int64
+	switch c.DiscountType {
+	case CouponDiscountPercent:
+		discount = price * c.DiscountValue / 100
+		if c.MaxDiscount != 0 && discount > c.MaxDiscount {
+			discount = c.MaxDiscount
+		}
+	case CouponDiscountAmount:
+		discount = c.DiscountValue
+	}
+	if discount > price {
+		discount = price
+	}
+	return discount
+}
@@ -0,0 +1,37 @@
+package domain
+
+// FeeRule is the commission charged to the seller. RateBP is in basis points (100 = 1%).
+type FeeRule struct {
+	RateBP int64
+	Flat   int64
+	Min    int64
+}
+
+// FeeSchedule applies the rule of the item category, or Default when the category has none
+type FeeSchedule struct {
+	Default    FeeRule
+	Categories map[int64]FeeRule
+}
+
+type Fee struct {
+	RateBP int64
+	Flat   int64
+	Amount int64
+}
+
+func (s FeeSchedule) Calculate(categoryID int64, price int64) Fee {
+	rule, ok := s.Categories[categoryID]
+	if !ok {
+		rule = s.Default
+	}
+
+	amount := price*rule.RateBP/10000 + rule.Flat
+	if amount < rule.Min {
+		amount = rule.Min
+	}
+	// the seller never pays more than the price
+	if amount > price {
+		amount = price
+	}
+	return Fee{RateBP: rule.RateBP, Flat: rule.Flat, Amount: amount}
+}
@@ -0,0 +1,12 @@
+package domain
+
+type IdempotencyRecord struct {
+	UserID      int64
+	Key         string
+	RequestHash string
+	// Status is 0 while the first request is still being processed
+	Status      int
+	ContentType string
+	Body        []byte
+	CreatedAt   string
+}
@@ -0,0 +1,30 @@
+package domain
+
+import (
+	"context"
+	"errors"
+)
+
+// ItemImage is one of the photos of an item. The image at position 0 is the cover.
+type ItemImage struct {
+	ID       int64
+	ItemID   int32
+	Position int
+	// Key locates the image in the ImageStore
+	Key string
+	// Data is only set for images stored in the DB before the ImageStore existed
+	Data      []byte
+	CreatedAt string
+	// UpdatedAt is the updated_at of the item, which moves when its images change. Only GetImage sets it.
+	UpdatedAt string
+}
+
+// ErrImageNotFound is returned by an ImageStore for a key it does not have
+var ErrImageNotFound = errors.New("image not found")
+
+// ImageStore keeps the image files outside of the DB, which only stores their keys
+type ImageStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,35 @@
+package domain
+
+type ImportStatus int
+
+const (
+	// ImportStatusRunning is checking the rows, then creating the items
+	ImportStatusRunning ImportStatus = iota + 1
+	// ImportStatusSucceeded created an item for every row
+	ImportStatusSucceeded
+	// ImportStatusFailed created no item. The rows with errors tell why.
+	ImportStatusFailed
+)
+
+// ImportJob is a bulk import of items from a manifest and an archive of images
+type ImportJob struct {
+	ID     int64
+	UserID int64
+	Status ImportStatus
+	// Total is the number of rows in the manifest, and Processed how many were checked so far
+	Total     int
+	Processed int
+	// Error is why the job failed, if not because of its rows
+	Error     string
+	Rows      []ImportRow
+	CreatedAt string
+	UpdatedAt string
+}
+
+// ImportRow is the result of a row of the manifest, numbered from 1.
+// ItemID is set once the item is created, Error if the row is invalid.
+type ImportRow struct {
+	Row    int
+	ItemID int32
+	Error  string
+}
@@ -1,13 +1,116 @@
 package domain
 
+import "fmt"
+
 type ItemStatus int
 
 const (
+	// ItemStatusInitial is a draft which is not listed yet
 	ItemStatusInitial ItemStatus = iota + 1
 	ItemStatusOnSale
+	// ItemStatusSoldOut means the item was paid and waits to be shipped
 	ItemStatusSoldOut
+	// ItemStatusPaused is hidden from buyers until the seller relists it
+	ItemStatusPaused
+	// ItemStatusReserved holds the item for the buyer whose offer was accepted, until they purchase it or the offer ends
+	ItemStatusReserved
+	ItemStatusShipped
+	// ItemStatusCompleted means the buyer received the item
+	ItemStatusCompleted
+	// ItemStatusCancelled means the seller withdrew the listing for good
+	ItemStatusCancelled
+	ItemStatusDeleted
 )
 
+var itemStatusNames = map[ItemStatus]string{
+	ItemStatusInitial:   "draft",
+	ItemStatusOnSale:    "on sale",
+	ItemStatusSoldOut:   "sold",
+	ItemStatusPaused:    "paused",
+	ItemStatusReserved:  "reserved",
+	ItemStatusShipped:   "shipped",
+	ItemStatusCompleted: "completed",
+	ItemStatusCancelled: "cancelled",
+	ItemStatusDeleted:   "deleted",
+}
+
+func (s ItemStatus) String() string {
+	if name, ok := itemStatusNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("ItemStatus(%d)", int(s))
+}
+
+// a purchase can be refunded while the item is sold or shipped, and also once the sale is completed.
+// Each refund puts the item back on sale.
+var itemTransitions = map[ItemStatus][]ItemStatus{
+	ItemStatusInitial: {ItemStatusOnSale, ItemStatusDeleted},
+	ItemStatusOnSale:  {ItemStatusSoldOut, ItemStatusReserved, ItemStatusPaused, ItemStatusCancelled, ItemStatusDeleted},
+	ItemStatusPaused:  {ItemStatusOnSale, ItemStatusCancelled, ItemStatusDeleted},
+	// an accepted offer is purchased, or goes back on sale once it is withdrawn or expires
+	ItemStatusReserved:  {ItemStatusSoldOut, ItemStatusOnSale},
+	ItemStatusSoldOut:   {ItemStatusShipped, ItemStatusOnSale},
+	ItemStatusShipped:   {ItemStatusCompleted, ItemStatusOnSale},
+	ItemStatusCompleted: {ItemStatusOnSale},
+	ItemStatusCancelled: {ItemStatusDeleted},
+	// deleted listings are restored as drafts
+	ItemStatusDeleted: {ItemStatusInitial},
+}
+
+// ItemTransitionError is returned for a status change the lifecycle does not allow
+type ItemTransitionError struct {
+	From ItemStatus
+	To   ItemStatus
+}
+
+func (e *ItemTransitionError) Error() string {
+	return fmt.Sprintf("item cannot go from %v to %v", e.From, e.To)
+}
+
+// TransitionItem is the only place deciding whether an item can change its status
+func TransitionItem(from ItemStatus, to ItemStatus) error {
+	for _, next := range itemTransitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+	return &ItemTransitionError{From: from, To: to}
+}
+
+// DeletedStatus returns the status an item takes when the seller deletes it. Listings become deleted,
+// while completed sales keep their status so that they stay in the buyer's purchase history.
+func DeletedStatus(from ItemStatus) (ItemStatus, error) {
+	if from == ItemStatusCompleted {
+		return from, nil
+	}
+	if err := TransitionItem(from, ItemStatusDeleted); err != nil {
+		return from, err
+	}
+	return ItemStatusDeleted, nil
+}
+
+// RestoredStatus returns the status a deleted item goes back to, undoing DeletedStatus. Deleted listings come back as drafts,
+// while completed sales kept their status.
+func RestoredStatus(from ItemStatus) (ItemStatus, error) {
+	if from == ItemStatusCompleted {
+		return from, nil
+	}
+	if err := TransitionItem(from, ItemStatusInitial); err != nil {
+		return from, err
+	}
+	return ItemStatusInitial, nil
+}
+
+// Sold reports whether the item was bought, whatever the progress of the delivery
+func (s ItemStatus) Sold() bool {
+	return s == ItemStatusSoldOut || s == ItemStatusShipped || s == ItemStatusCompleted
+}
+
+// Editable reports whether the seller can still change the listing
+func (s ItemStatus) Editable() bool {
+	return s == ItemStatusInitial || s == ItemStatusOnSale || s == ItemStatusPaused
+}
+
 type Item struct {
 	ID          int32
 	Name        string
@@ -15,10 +118,13 @@ type Item struct {
 	Description string
 	CategoryID  int64
 	UserID      int64
-	Image       []byte
-	Status      ItemStatus
-	CreatedAt   string
-	UpdatedAt   string
+	// ImageKey is the key of the new cover in the ImageStore when the item is added or edited
+	ImageKey  string
+	Status    ItemStatus
+	CreatedAt string
+	UpdatedAt string
+	// DeletedAt is empty unless the item was soft deleted
+	DeletedAt string
 }
 
 type Category struct {
@@ -27,7 +133,7 @@ type Category struct {
 }
 
 type History struct {
-	ID   int64
-	userID   int64
-	ItemID   int32
+	ID     int64
+	userID int64
+	ItemID int32
 }
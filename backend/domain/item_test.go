@@ -0,0 +1,94 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTransitionItem(t *testing.T) {
+	tests := []struct {
+		from ItemStatus
+		to   ItemStatus
+		ok   bool
+	}{
+		{ItemStatusInitial, ItemStatusOnSale, true},
+		{ItemStatusInitial, ItemStatusSoldOut, false},
+		{ItemStatusOnSale, ItemStatusSoldOut, true},
+		{ItemStatusOnSale, ItemStatusPaused, true},
+		{ItemStatusOnSale, ItemStatusShipped, false},
+		{ItemStatusPaused, ItemStatusOnSale, true},
+		{ItemStatusPaused, ItemStatusSoldOut, false},
+		{ItemStatusSoldOut, ItemStatusShipped, true},
+		{ItemStatusSoldOut, ItemStatusOnSale, true},
+		{ItemStatusSoldOut, ItemStatusDeleted, false},
+		{ItemStatusShipped, ItemStatusCompleted, true},
+		{ItemStatusCompleted, ItemStatusOnSale, true},
+		{ItemStatusCompleted, ItemStatusShipped, false},
+		{ItemStatusCancelled, ItemStatusOnSale, false},
+		{ItemStatusCancelled, ItemStatusDeleted, true},
+		{ItemStatusDeleted, ItemStatusInitial, true},
+		{ItemStatusDeleted, ItemStatusOnSale, false},
+		{ItemStatusOnSale, ItemStatusReserved, true},
+		{ItemStatusReserved, ItemStatusSoldOut, true},
+		{ItemStatusReserved, ItemStatusOnSale, true},
+		{ItemStatusReserved, ItemStatusPaused, false},
+		{ItemStatusPaused, ItemStatusReserved, false},
+		// 10 is no status
+		{ItemStatusOnSale, ItemStatus(10), false},
+		{ItemStatus(10), ItemStatusOnSale, false},
+	}
+	for _, tt := range tests {
+		err := TransitionItem(tt.from, tt.to)
+		if (err == nil) != tt.ok {
+			t.Errorf("TransitionItem(%v, %v) error = %v, want ok %v", tt.from, tt.to, err, tt.ok)
+		}
+		var transitionErr *ItemTransitionError
+		if err != nil && !errors.As(err, &transitionErr) {
+			t.Errorf("TransitionItem(%v, %v) error = %T, want *ItemTransitionError", tt.from, tt.to, err)
+		}
+	}
+}
+
+func TestDeleteAndRestoreItem(t *testing.T) {
+	tests := []struct {
+		from        ItemStatus
+		wantDeleted ItemStatus
+		// ok is false for the statuses an item cannot be deleted in
+		ok bool
+	}{
+		{ItemStatusInitial, ItemStatusDeleted, true},
+		{ItemStatusOnSale, ItemStatusDeleted, true},
+		{ItemStatusPaused, ItemStatusDeleted, true},
+		{ItemStatusCancelled, ItemStatusDeleted, true},
+		{ItemStatusCompleted, ItemStatusCompleted, true},
+		{ItemStatusSoldOut, 0, false},
+		{ItemStatusShipped, 0, false},
+	}
+	for _, tt := range tests {
+		deleted, err := DeletedStatus(tt.from)
+		if (err == nil) != tt.ok {
+			t.Errorf("DeletedStatus(%v) error = %v, want ok %v", tt.from, err, tt.ok)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if deleted != tt.wantDeleted {
+			t.Errorf("DeletedStatus(%v) = %v, want %v", tt.from, deleted, tt.wantDeleted)
+		}
+
+		// listings come back as drafts, sales as they were
+		want := ItemStatusInitial
+		if tt.from == ItemStatusCompleted {
+			want = ItemStatusCompleted
+		}
+		if restored, err := RestoredStatus(deleted); err != nil || restored != want {
+			t.Errorf("RestoredStatus(%v) = %v, %v, want %v", deleted, restored, err, want)
+		}
+	}
+
+	// items which were not deleted cannot be restored
+	if _, err := RestoredStatus(ItemStatusOnSale); err == nil {
+		t.Errorf("RestoredStatus(%v) error = nil, want an error", ItemStatusOnSale)
+	}
+}
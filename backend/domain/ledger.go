@@ -0,0 +1,44 @@
+package domain
+
+type LedgerAccount string
+
+const (
+	// LedgerAccountWallet is the spendable balance of a user
+	LedgerAccountWallet LedgerAccount = "wallet"
+	// LedgerAccountExternal is the counterpart for money entering or leaving the platform
+	LedgerAccountExternal LedgerAccount = "external"
+	// LedgerAccountEscrow holds paid money until the buyer receives the item
+	LedgerAccountEscrow LedgerAccount = "escrow"
+	// LedgerAccountPlatform collects the commission on sales
+	LedgerAccountPlatform LedgerAccount = "platform"
+	// LedgerAccountPoints is the promotional points of a user, which can be spent like money
+	LedgerAccountPoints LedgerAccount = "points"
+	// LedgerAccountPayout holds the money a user asked to withdraw until the payout is settled
+	LedgerAccountPayout LedgerAccount = "payout"
+)
+
+type LedgerKind string
+
+const (
+	LedgerKindOpening        LedgerKind = "opening"
+	LedgerKindTopUp          LedgerKind = "topup"
+	LedgerKindPurchase       LedgerKind = "purchase"
+	LedgerKindRelease        LedgerKind = "release"
+	LedgerKindRefund         LedgerKind = "refund"
+	LedgerKindPromotion      LedgerKind = "promotion"
+	LedgerKindWithdraw       LedgerKind = "withdraw"
+	LedgerKindPayout         LedgerKind = "payout"
+	LedgerKindPayoutRejected LedgerKind = "payout_rejected"
+)
+
+type LedgerEntry struct {
+	ID        int64
+	JournalID int64
+	Account   LedgerAccount
+	UserID    int64
+	Amount    int64
+	Kind      LedgerKind
+	ItemID    int32
+	Balance   int64
+	CreatedAt string
+}
@@ -0,0 +1,62 @@
+package domain
+
+type OfferStatus int
+
+const (
+	// OfferStatusPending waits for the seller
+	OfferStatusPending OfferStatus = iota + 1
+	// OfferStatusCountered waits for the buyer to answer the counter price of the seller
+	OfferStatusCountered
+	// OfferStatusAccepted lets the buyer purchase the item at the agreed price until it expires
+	OfferStatusAccepted
+	OfferStatusRejected
+	OfferStatusWithdrawn
+	OfferStatusExpired
+	// OfferStatusPurchased means the buyer bought the item at the agreed price
+	OfferStatusPurchased
+	// OfferStatusClosed means the item was sold to someone else
+	OfferStatusClosed
+)
+
+var offerTransitions = map[OfferStatus][]OfferStatus{
+	OfferStatusPending:   {OfferStatusCountered, OfferStatusAccepted, OfferStatusRejected, OfferStatusWithdrawn, OfferStatusExpired, OfferStatusClosed},
+	OfferStatusCountered: {OfferStatusAccepted, OfferStatusRejected, OfferStatusWithdrawn, OfferStatusExpired, OfferStatusClosed},
+	OfferStatusAccepted:  {OfferStatusPurchased, OfferStatusWithdrawn, OfferStatusExpired, OfferStatusClosed},
+}
+
+func (s OfferStatus) CanTransitionTo(next OfferStatus) bool {
+	for _, to := range offerTransitions[s] {
+		if to == next {
+			return true
+		}
+	}
+	return false
+}
+
+type Offer struct {
+	ID       int64
+	ItemID   int32
+	BuyerID  int64
+	SellerID int64
+	// Price is offered by the buyer and CounterPrice by the seller
+	Price        int64
+	CounterPrice int64
+	Status       OfferStatus
+	PurchaseID   int64
+	ExpiresAt    string
+	CreatedAt    string
+	UpdatedAt    string
+}
+
+// AgreedPrice is the price the buyer pays once the offer is accepted
+func (o Offer) AgreedPrice() int64 {
+	if o.CounterPrice != 0 {
+		return o.CounterPrice
+	}
+	return o.Price
+}
+
+// Expired reports whether the offer ran out of time. now is formatted like ExpiresAt.
+func (o Offer) Expired(now string) bool {
+	return o.Status.CanTransitionTo(OfferStatusExpired) && o.ExpiresAt < now
+}
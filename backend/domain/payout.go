@@ -0,0 +1,55 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+)
+
+type PayoutStatus int
+
+const (
+	// PayoutStatusPending means the money is held until the payout is approved or rejected
+	PayoutStatusPending PayoutStatus = iota + 1
+	// PayoutStatusPaid means the money left the platform
+	PayoutStatusPaid
+	// PayoutStatusRejected means the money was returned to the wallet
+	PayoutStatusRejected
+	// PayoutStatusProcessing means a PayoutProvider is transferring the money, which stays held until it is done
+	PayoutStatusProcessing
+)
+
+type Payout struct {
+	ID     int64
+	UserID int64
+	Amount int64
+	Status PayoutStatus
+	// Reference is the transfer id given by the provider
+	Reference string
+	Reason    string
+	// ProviderRef identifies a transfer the provider has not finished, whose result is polled with PayoutProvider.Status
+	ProviderRef string
+	CreatedAt   string
+	UpdatedAt   string
+}
+
+// IdempotencyKey identifies the transfer of the payout to a PayoutProvider
+func (p Payout) IdempotencyKey() string {
+	return fmt.Sprintf("payout-%d", p.ID)
+}
+
+// PayoutResult is the decision of a PayoutProvider. A pending result means the provider has not finished the transfer,
+// and its Reference identifies the transfer to poll.
+type PayoutResult struct {
+	Status    PayoutStatus
+	Reference string
+	Reason    string
+}
+
+// PayoutProvider sends the money of a payout to the bank account of the user.
+// A payout whose transfer failed is transferred again, so Transfer must send the money at most once per IdempotencyKey
+// and return the result of the first transfer when called again.
+type PayoutProvider interface {
+	Transfer(ctx context.Context, payout Payout) (PayoutResult, error)
+	// Status returns the result of a transfer which was pending, by its Reference
+	Status(ctx context.Context, ref string) (PayoutResult, error)
+}
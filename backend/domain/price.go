@@ -0,0 +1,19 @@
+package domain
+
+// PriceChange is a price edit of an item
+type PriceChange struct {
+	ID        int64
+	ItemID    int32
+	OldPrice  int64
+	NewPrice  int64
+	CreatedAt string
+}
+
+// PriceDropPercent is how much cheaper price is than the highest price before it, rounded down.
+// It is 0 if the price did not drop.
+func PriceDropPercent(highest, price int64) int64 {
+	if highest <= 0 || price >= highest {
+		return 0
+	}
+	return (highest - price) * 100 / highest
+}
@@ -0,0 +1,63 @@
+package domain
+
+type PurchaseStatus int
+
+const (
+	// PurchaseStatusPaid means the buyer paid and the money is held in escrow
+	PurchaseStatusPaid PurchaseStatus = iota + 1
+	PurchaseStatusShipped
+	PurchaseStatusReceived
+	// PurchaseStatusCompleted means the money was released to the seller
+	PurchaseStatusCompleted
+	// PurchaseStatusCancelled means the money was refunded to the buyer
+	PurchaseStatusCancelled
+)
+
+var purchaseTransitions = map[PurchaseStatus][]PurchaseStatus{
+	PurchaseStatusPaid:      {PurchaseStatusShipped, PurchaseStatusCancelled},
+	PurchaseStatusShipped:   {PurchaseStatusReceived, PurchaseStatusCancelled},
+	PurchaseStatusReceived:  {PurchaseStatusCompleted},
+	PurchaseStatusCompleted: {PurchaseStatusCancelled},
+}
+
+func (s PurchaseStatus) CanTransitionTo(next PurchaseStatus) bool {
+	for _, to := range purchaseTransitions[s] {
+		if to == next {
+			return true
+		}
+	}
+	return false
+}
+
+type SalesReport struct {
+	CategoryID   int64
+	Count        int64
+	Price        int64
+	Fee          int64
+	SellerAmount int64
+}
+
+type Purchase struct {
+	ID       int64
+	ItemID   int32
+	BuyerID  int64
+	SellerID int64
+	Price    int64
+	// the buyer paid Price - Discount - Points from the wallet, the platform pays Discount
+	CouponID int64
+	Discount int64
+	Points   int64
+	Fee      Fee
+	// SellerAmount is what the seller receives, Price minus Fee.Amount
+	SellerAmount int64
+	Status       PurchaseStatus
+	CreatedAt    string
+	UpdatedAt    string
+	ShippedAt    string
+	ReceivedAt   string
+	CompletedAt  string
+	CancelledAt  string
+	// CancelRequestedBy is the user who asked to cancel, 0 if nobody did
+	CancelRequestedBy int64
+	CancelReason      string
+}
@@ -0,0 +1,30 @@
+package domain
+
+// ItemField names a field of an item recorded in its revisions
+type ItemField string
+
+const (
+	ItemFieldName        ItemField = "name"
+	ItemFieldPrice       ItemField = "price"
+	ItemFieldDescription ItemField = "description"
+	ItemFieldCategory    ItemField = "category_id"
+	// ItemFieldImage is the cover. Its values are the keys in the ImageStore.
+	ItemFieldImage ItemField = "image"
+)
+
+// ItemRevision is one edit of an item
+type ItemRevision struct {
+	ID     int64
+	ItemID int32
+	// UserID is who edited the item
+	UserID    int64
+	Changes   []ItemChange
+	CreatedAt string
+}
+
+// ItemChange is a field changed by an edit, with its values before and after
+type ItemChange struct {
+	Field ItemField
+	Old   string
+	New   string
+}
@@ -5,4 +5,6 @@ type User struct {
 	Password string
 	Name     string
 	Balance  int64
+	// DeletedAt is empty unless the user was soft deleted
+	DeletedAt string
 }
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+type getSalesReportResponse struct {
+	CategoryID   int64 `json:"category_id"`
+	Count        int64 `json:"count"`
+	Price        int64 `json:"price"`
+	Fee          int64 `json:"fee"`
+	SellerAmount int64 `json:"seller_amount"`
+}
+
+var dateRegexp = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+// adminUserIDs are the users allowed to call admin endpoints, e.g. ADMIN_USER_IDS=1,2
+var adminUserIDs = parseUserIDs(getEnv("ADMIN_USER_IDS", ""))
+
+func parseUserIDs(s string) map[int64]bool {
+	ids := map[int64]bool{}
+	for _, v := range strings.Split(s, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err == nil {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+// AdminOnly rejects users who are not admins. It has to be used behind the jwt middleware.
+func AdminOnly(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, err := getUserID(c)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		}
+		if !adminUserIDs[userID] {
+			return echo.NewHTTPError(http.StatusForbidden, "Admin only.")
+		}
+		return next(c)
+	}
+}
+
+// GetSalesReport returns the sales and the commission per category. from and to are dates like 2023-05-01.
+func (h *Handler) GetSalesReport(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	from := c.QueryParam("from")
+	to := c.QueryParam("to")
+	if (from != "" && !dateRegexp.MatchString(from)) || (to != "" && !dateRegexp.MatchString(to)) {
+		return echo.NewHTTPError(http.StatusBadRequest, "from and to must be formatted as YYYY-MM-DD")
+	}
+	if to == "" {
+		to = "9999-12-31"
+	}
+
+	reports, err := h.PurchaseRepo.GetSalesReport(ctx, from, to)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	res := make([]getSalesReportResponse, len(reports))
+	for i, r := range reports {
+		res[i] = getSalesReportResponse{CategoryID: r.CategoryID, Count: r.Count, Price: r.Price, Fee: r.Fee, SellerAmount: r.SellerAmount}
+	}
+	return c.JSON(http.StatusOK, res)
+}
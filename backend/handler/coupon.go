@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/db"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+	"github.com/labstack/echo/v4"
+)
+
+type addCouponRequest struct {
+	Code          string                    `json:"code"`
+	DiscountType  domain.CouponDiscountType `json:"discount_type"`
+	DiscountValue int64                     `json:"discount_value"`
+	MaxDiscount   int64                     `json:"max_discount"`
+	MinPrice      int64                     `json:"min_price"`
+	UsageLimit    int64                     `json:"usage_limit"`
+	PerUserLimit  int64                     `json:"per_user_limit"`
+	// ExpiresAt is formatted as 2006-01-02 15:04:05 in local time
+	ExpiresAt string `json:"expires_at"`
+}
+
+type addCouponResponse struct {
+	ID int64 `json:"id"`
+}
+
+type getCouponResponse struct {
+	ID            int64                     `json:"id"`
+	Code          string                    `json:"code"`
+	DiscountType  domain.CouponDiscountType `json:"discount_type"`
+	DiscountValue int64                     `json:"discount_value"`
+	MaxDiscount   int64                     `json:"max_discount"`
+	MinPrice      int64                     `json:"min_price"`
+	UsageLimit    int64                     `json:"usage_limit"`
+	PerUserLimit  int64                     `json:"per_user_limit"`
+	UsedCount     int64                     `json:"used_count"`
+	ExpiresAt     string                    `json:"expires_at"`
+}
+
+type grantPointsRequest struct {
+	UserID int64 `json:"user_id"`
+	Points int64 `json:"points"`
+}
+
+type getPointsResponse struct {
+	Points int64 `json:"points"`
+}
+
+func (h *Handler) AddCoupon(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	req := new(addCouponRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	// validation
+	if len(req.Code) == 0 || len(req.Code) > 50 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Code must be 1 to 50 characters.")
+	}
+	switch req.DiscountType {
+	case domain.CouponDiscountPercent:
+		if req.DiscountValue <= 0 || req.DiscountValue > 100 {
+			return echo.NewHTTPError(http.StatusBadRequest, "Percent discount must be between 1 and 100.")
+		}
+	case domain.CouponDiscountAmount:
+		if req.DiscountValue <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "Discount must be greater than 0.")
+		}
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "discount_type must be percent or amount.")
+	}
+	if req.MaxDiscount < 0 || req.MinPrice < 0 || req.UsageLimit < 0 || req.PerUserLimit < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Limits cannot be negative.")
+	}
+	if _, err := time.ParseInLocation(db.TimeLayout, req.ExpiresAt, time.Local); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "expires_at must be formatted as YYYY-MM-DD hh:mm:ss")
+	}
+
+	id, err := h.CouponRepo.AddCoupon(ctx, domain.Coupon{
+		Code:          req.Code,
+		DiscountType:  req.DiscountType,
+		DiscountValue: req.DiscountValue,
+		MaxDiscount:   req.MaxDiscount,
+		MinPrice:      req.MinPrice,
+		UsageLimit:    req.UsageLimit,
+		PerUserLimit:  req.PerUserLimit,
+		ExpiresAt:     req.ExpiresAt,
+	})
+	if err != nil {
+		if err == db.ErrCouponExists {
+			return echo.NewHTTPError(http.StatusConflict, "Coupon code already exists.")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, addCouponResponse{ID: id})
+}
+
+func (h *Handler) GetCoupons(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	coupons, err := h.CouponRepo.GetCoupons(ctx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	res := make([]getCouponResponse, len(coupons))
+	for i, cp := range coupons {
+		res[i] = getCouponResponse{
+			ID:            cp.ID,
+			Code:          cp.Code,
+			DiscountType:  cp.DiscountType,
+			DiscountValue: cp.DiscountValue,
+			MaxDiscount:   cp.MaxDiscount,
+			MinPrice:      cp.MinPrice,
+			UsageLimit:    cp.UsageLimit,
+			PerUserLimit:  cp.PerUserLimit,
+			UsedCount:     cp.UsedCount,
+			ExpiresAt:     cp.ExpiresAt,
+		}
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// GrantPoints gives promotional points paid by the platform
+func (h *Handler) GrantPoints(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	req := new(grantPointsRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if req.Points <= 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Points must be greater than 0.")
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer tx.Rollback()
+
+	if _, err := h.UserRepo.GetUserTx(tx, ctx, req.UserID); err != nil {
+		// not found handling
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusPreconditionFailed, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if _, err := h.LedgerRepo.PostTx(tx, ctx, domain.LedgerKindPromotion, 0, []domain.LedgerEntry{
+		{Account: domain.LedgerAccountPlatform, Amount: -req.Points},
+		{Account: domain.LedgerAccountPoints, UserID: req.UserID, Amount: req.Points},
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, "successful")
+}
+
+func (h *Handler) GetPoints(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	points, err := h.LedgerRepo.GetBalance(ctx, domain.LedgerAccountPoints, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, getPointsResponse{Points: points})
+}
@@ -0,0 +1,255 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/db"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+	"github.com/labstack/echo/v4"
+)
+
+type purgeResponse struct {
+	Items int64 `json:"items"`
+	Users int64 `json:"users"`
+}
+
+// DeleteItem soft deletes the item. Items in the middle of a purchase cannot be deleted.
+func (h *Handler) DeleteItem(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	itemID, err := getItemID(c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer tx.Rollback()
+
+	item, err := h.ItemRepo.GetItemTx(tx, ctx, itemID)
+	if err != nil {
+		// not found handling
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if item.UserID != userID {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "You can only delete your own items.")
+	}
+	if err := h.deleteItemTx(tx, ctx, item); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	// deleted, delete from cache
+	CA.Delete(fmt.Sprintf(itemKey, itemID))
+	CA.Delete(fmt.Sprintf(imageKey, itemID))
+
+	return c.JSON(http.StatusOK, "successful")
+}
+
+func (h *Handler) deleteItemTx(tx *sql.Tx, ctx context.Context, item domain.Item) error {
+	if err := h.ItemRepo.DeleteItemTx(tx, ctx, item.ID, item.Status); err != nil {
+		return itemStatusError(err)
+	}
+	if err := h.OfferRepo.CloseOffersTx(tx, ctx, item.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return nil
+}
+
+// RestoreItem brings back an item the seller deleted. Deleted listings come back as drafts.
+func (h *Handler) RestoreItem(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	itemID, err := getItemID(c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer tx.Rollback()
+
+	item, err := h.ItemRepo.GetItemWithDeletedTx(tx, ctx, itemID)
+	if err != nil {
+		// not found handling
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if item.UserID != userID {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "You can only restore your own items.")
+	}
+	if item.DeletedAt == "" {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "This item is not deleted.")
+	}
+	if err := h.ItemRepo.RestoreItemTx(tx, ctx, itemID, item.Status); err != nil {
+		return itemStatusError(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, "successful")
+}
+
+// DeleteUser soft deletes the user and their items. Users can delete themselves and admins anyone.
+// The items they sold stay in the buyers' purchase history.
+func (h *Handler) DeleteUser(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	loginUserID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	userID, err := strconv.ParseInt(c.Param("userID"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid userID type")
+	}
+	if userID != loginUserID && !adminUserIDs[loginUserID] {
+		return echo.NewHTTPError(http.StatusForbidden, "You can only delete yourself.")
+	}
+
+	payouts, err := h.PayoutRepo.GetPayoutsByUserID(ctx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	for _, p := range payouts {
+		if p.Status == domain.PayoutStatusPending || p.Status == domain.PayoutStatusProcessing {
+			return echo.NewHTTPError(http.StatusPreconditionFailed, "Payouts are still pending.")
+		}
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer tx.Rollback()
+
+	user, err := h.UserRepo.GetUserTx(tx, ctx, userID)
+	if err != nil {
+		// not found handling
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if user.Balance != 0 {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "Withdraw the balance before deleting the account.")
+	}
+	open, err := h.PurchaseRepo.CountOpenPurchasesByUserTx(tx, ctx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if open > 0 {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "Purchases are still in progress.")
+	}
+
+	items, err := h.ItemRepo.GetItemsByUserIDTx(tx, ctx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	for _, item := range items {
+		if err := h.deleteItemTx(tx, ctx, item); err != nil {
+			return err
+		}
+	}
+	if err := h.UserRepo.DeleteUserTx(tx, ctx, userID); err != nil {
+		if err == db.ErrConflict {
+			return echo.NewHTTPError(http.StatusConflict, "The user was deleted by another request.")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	// deleted, delete from cache
+	for _, item := range items {
+		CA.Delete(fmt.Sprintf(itemKey, item.ID))
+		CA.Delete(fmt.Sprintf(imageKey, item.ID))
+	}
+
+	return c.JSON(http.StatusOK, "successful")
+}
+
+// RestoreUser brings back a deleted user. Their items are restored one by one by themselves.
+func (h *Handler) RestoreUser(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := strconv.ParseInt(c.Param("userID"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid userID type")
+	}
+
+	if err := h.UserRepo.RestoreUser(ctx, userID); err != nil {
+		// not found handling
+		if err == db.ErrConflict {
+			return echo.NewHTTPError(http.StatusNotFound, "No deleted user found.")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, "successful")
+}
+
+// Purge hard deletes what was deleted more than PurgeRetention ago
+func (h *Handler) Purge(c echo.Context) error {
+	items, users, err := h.purge(c.Request().Context())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, purgeResponse{Items: items, Users: users})
+}
+
+// PurgeDeleted is Purge run by the background job
+func (h *Handler) PurgeDeleted(ctx context.Context) error {
+	items, users, err := h.purge(ctx)
+	if err != nil {
+		return err
+	}
+	if items > 0 || users > 0 {
+		log.Printf("purged %d items and %d users", items, users)
+	}
+	return nil
+}
+
+func (h *Handler) purge(ctx context.Context) (int64, int64, error) {
+	before := time.Now().Add(-h.PurgeRetention)
+	items, keys, err := h.ItemRepo.PurgeItems(ctx, before)
+	if err != nil {
+		return 0, 0, err
+	}
+	h.deleteStoredImages(ctx, keys...)
+	users, err := h.UserRepo.PurgeUsers(ctx, before)
+	if err != nil {
+		return items, 0, err
+	}
+	return items, users, nil
+}
@@ -0,0 +1,261 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+	"github.com/labstack/echo/v4"
+)
+
+type getPurchaseResponse struct {
+	ID       int64 `json:"id"`
+	ItemID   int32 `json:"item_id"`
+	BuyerID  int64 `json:"buyer_id"`
+	SellerID int64 `json:"seller_id"`
+	Price    int64 `json:"price"`
+	// Discount and Points were paid by the platform and the buyer points
+	Discount int64 `json:"discount,omitempty"`
+	Points   int64 `json:"points,omitempty"`
+	// fee breakdown for the receipt
+	FeeRateBP    int64                 `json:"fee_rate_bp"`
+	FeeFlat      int64                 `json:"fee_flat"`
+	Fee          int64                 `json:"fee"`
+	SellerAmount int64                 `json:"seller_amount"`
+	Status       domain.PurchaseStatus `json:"status"`
+	CreatedAt    string                `json:"created_at"`
+	ShippedAt    string                `json:"shipped_at,omitempty"`
+	ReceivedAt   string                `json:"received_at,omitempty"`
+	CompletedAt  string                `json:"completed_at,omitempty"`
+	CancelledAt  string                `json:"cancelled_at,omitempty"`
+	// CancelRequestedBy is set while a cancel request is pending or after a cancellation
+	CancelRequestedBy int64  `json:"cancel_requested_by,omitempty"`
+	CancelReason      string `json:"cancel_reason,omitempty"`
+}
+
+func (h *Handler) GetPurchase(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	itemID, err := getItemID(c)
+	if err != nil {
+		return err
+	}
+
+	p, err := h.PurchaseRepo.GetPurchaseByItemID(ctx, itemID)
+	if err != nil {
+		// not found handling
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, "This item has not been purchased.")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	// only the parties of the purchase can see it
+	if userID != p.BuyerID && userID != p.SellerID {
+		return echo.NewHTTPError(http.StatusNotFound, "This item has not been purchased.")
+	}
+
+	return c.JSON(http.StatusOK, getPurchaseResponse{
+		ID:           p.ID,
+		ItemID:       p.ItemID,
+		BuyerID:      p.BuyerID,
+		SellerID:     p.SellerID,
+		Price:        p.Price,
+		Discount:     p.Discount,
+		Points:       p.Points,
+		FeeRateBP:    p.Fee.RateBP,
+		FeeFlat:      p.Fee.Flat,
+		Fee:          p.Fee.Amount,
+		SellerAmount: p.SellerAmount,
+		Status:       p.Status,
+		CreatedAt:    p.CreatedAt,
+		ShippedAt:    p.ShippedAt,
+		ReceivedAt:   p.ReceivedAt,
+		CompletedAt:  p.CompletedAt,
+		CancelledAt:  p.CancelledAt,
+
+		CancelRequestedBy: p.CancelRequestedBy,
+		CancelReason:      p.CancelReason,
+	})
+}
+
+// ShipItem is called by the seller after sending the item to the buyer
+func (h *Handler) ShipItem(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	itemID, err := getItemID(c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer tx.Rollback()
+
+	p, err := h.PurchaseRepo.GetPurchaseByItemIDTx(tx, ctx, itemID)
+	if err != nil {
+		// not found handling
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusPreconditionFailed, "This item has not been purchased.")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if p.SellerID != userID {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "You can only ship your own items.")
+	}
+	if !p.Status.CanTransitionTo(domain.PurchaseStatusShipped) {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "This purchase cannot be shipped.")
+	}
+
+	if err := h.PurchaseRepo.UpdatePurchaseStatusTx(tx, ctx, p.ID, domain.PurchaseStatusShipped); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if err := h.ItemRepo.UpdateItemStatusTx(tx, ctx, p.ItemID, domain.ItemStatusSoldOut, domain.ItemStatusShipped); err != nil {
+		return itemStatusError(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	// status changed, delete from cache
+	CA.Delete(fmt.Sprintf(itemKey, itemID))
+
+	return c.JSON(http.StatusOK, "successful")
+}
+
+// ReceiveItem is called by the buyer to confirm the receipt, which releases the money to the seller
+func (h *Handler) ReceiveItem(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	itemID, err := getItemID(c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer tx.Rollback()
+
+	p, err := h.PurchaseRepo.GetPurchaseByItemIDTx(tx, ctx, itemID)
+	if err != nil {
+		// not found handling
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusPreconditionFailed, "This item has not been purchased.")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if p.BuyerID != userID {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "You can only receive items you bought.")
+	}
+	if !p.Status.CanTransitionTo(domain.PurchaseStatusReceived) {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "This item has not been shipped.")
+	}
+
+	if err := h.receiveTx(tx, ctx, p); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	// status changed, delete from cache
+	CA.Delete(fmt.Sprintf(itemKey, itemID))
+
+	return c.JSON(http.StatusOK, "successful")
+}
+
+// receiveTx marks the purchase as received and pays the seller and the platform out of escrow
+func (h *Handler) receiveTx(tx *sql.Tx, ctx context.Context, p domain.Purchase) error {
+	if err := h.PurchaseRepo.UpdatePurchaseStatusTx(tx, ctx, p.ID, domain.PurchaseStatusReceived); err != nil {
+		return err
+	}
+
+	entries := []domain.LedgerEntry{
+		{Account: domain.LedgerAccountEscrow, Amount: -p.Price},
+		{Account: domain.LedgerAccountWallet, UserID: p.SellerID, Amount: p.SellerAmount},
+	}
+	if p.Fee.Amount != 0 {
+		entries = append(entries, domain.LedgerEntry{Account: domain.LedgerAccountPlatform, Amount: p.Fee.Amount})
+	}
+	if _, err := h.LedgerRepo.PostTx(tx, ctx, domain.LedgerKindRelease, p.ItemID, entries); err != nil {
+		return err
+	}
+
+	if err := h.ItemRepo.UpdateItemStatusTx(tx, ctx, p.ItemID, domain.ItemStatusShipped, domain.ItemStatusCompleted); err != nil {
+		return err
+	}
+	return h.PurchaseRepo.UpdatePurchaseStatusTx(tx, ctx, p.ID, domain.PurchaseStatusCompleted)
+}
+
+// ReleaseEscrow completes the purchases whose buyer did not confirm the receipt
+// within timeout after shipping. Purchases with a pending cancel request are left to it.
+// A purchase that fails is logged and retried on the next run, it does not hold back the others.
+func (h *Handler) ReleaseEscrow(ctx context.Context, timeout time.Duration) error {
+	purchases, err := h.PurchaseRepo.GetShippedPurchasesBefore(ctx, time.Now().Add(-timeout))
+	if err != nil {
+		return err
+	}
+
+	for _, p := range purchases {
+		released, err := h.releaseEscrow(ctx, p)
+		if err != nil {
+			log.Printf("failed to release escrow: item %v: %s", p.ItemID, err)
+			continue
+		}
+		if released {
+			log.Printf("escrow released: item %v", p.ItemID)
+		}
+	}
+	return nil
+}
+
+// releaseEscrow reports whether the purchase was completed, which it is not if it changed since it was listed
+func (h *Handler) releaseEscrow(ctx context.Context, p domain.Purchase) (bool, error) {
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// the buyer may have confirmed or asked to cancel in the meantime
+	current, err := h.PurchaseRepo.GetPurchaseByItemIDTx(tx, ctx, p.ItemID)
+	if err != nil {
+		return false, err
+	}
+	if current.ID != p.ID || !current.Status.CanTransitionTo(domain.PurchaseStatusReceived) || current.CancelRequestedBy != 0 {
+		return false, nil
+	}
+
+	if err := h.receiveTx(tx, ctx, current); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	// status changed, delete from cache
+	CA.Delete(fmt.Sprintf(itemKey, p.ItemID))
+	return true, nil
+}
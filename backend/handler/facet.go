@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/db"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+// facetsResponse counts the items of a search by category, status and price bucket, see db.ItemFacets
+type facetsResponse struct {
+	Categories []categoryFacet `json:"categories"`
+	Statuses   []statusFacet   `json:"statuses"`
+	Prices     []priceFacet    `json:"prices"`
+}
+
+type categoryFacet struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+type statusFacet struct {
+	Status domain.ItemStatus `json:"status"`
+	Count  int64             `json:"count"`
+}
+
+// priceFacet is a price bucket. Min and Max can be passed as they are as price-min and price-max, and the last bucket has no Max.
+type priceFacet struct {
+	Min   int64  `json:"min"`
+	Max   *int64 `json:"max"`
+	Count int64  `json:"count"`
+}
+
+// searchFacets counts the items of a search-detail query. Categories without items are left out.
+func (h *Handler) searchFacets(ctx context.Context, query db.ItemQuery) (*facetsResponse, error) {
+	facets, err := h.ItemRepo.GetItemFacets(ctx, query, searchStatuses, h.PriceBuckets)
+	if err != nil {
+		return nil, err
+	}
+	cats, err := h.ItemRepo.GetCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &facetsResponse{Categories: []categoryFacet{}}
+	for _, cat := range cats {
+		if count := facets.Categories[cat.ID]; count > 0 {
+			res.Categories = append(res.Categories, categoryFacet{ID: cat.ID, Name: cat.Name, Count: count})
+		}
+	}
+	for _, status := range searchStatuses {
+		res.Statuses = append(res.Statuses, statusFacet{Status: status, Count: facets.Statuses[status]})
+	}
+	var min int64
+	for i, count := range facets.Prices {
+		bucket := priceFacet{Min: min, Count: count}
+		if i < len(h.PriceBuckets) {
+			max := h.PriceBuckets[i] - 1
+			bucket.Max = &max
+			min = h.PriceBuckets[i]
+		}
+		res.Prices = append(res.Prices, bucket)
+	}
+	return res, nil
+}
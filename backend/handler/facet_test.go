@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/db"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+func TestSearchFacets(t *testing.T) {
+	ctx := context.Background()
+	h := newTestHandler(t)
+	h.PriceBuckets = []int64{1000, 5000}
+	if _, err := h.DB.Exec("INSERT INTO category (id, name) VALUES (1, 'book'), (2, 'fashion'), (3, 'toy')"); err != nil {
+		t.Fatal(err)
+	}
+	sellerID := addTestUser(t, h, 0)
+	for _, item := range []struct {
+		categoryID int64
+		status     domain.ItemStatus
+		price      int64
+	}{
+		{1, domain.ItemStatusOnSale, 500},
+		{1, domain.ItemStatusSoldOut, 2000},
+		{2, domain.ItemStatusOnSale, 6000},
+	} {
+		if _, err := h.ItemRepo.AddItem(ctx, domain.Item{Name: "item", Price: item.price, Description: "description", CategoryID: item.categoryID, UserID: sellerID, Status: item.status}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := h.searchFacets(ctx, db.ItemQuery{Statuses: searchStatuses})
+	if err != nil {
+		t.Fatal(err)
+	}
+	max := func(price int64) *int64 { return &price }
+	want := &facetsResponse{
+		// the toy category has no items
+		Categories: []categoryFacet{{ID: 1, Name: "book", Count: 2}, {ID: 2, Name: "fashion", Count: 1}},
+		Statuses: []statusFacet{
+			{Status: domain.ItemStatusOnSale, Count: 2},
+			{Status: domain.ItemStatusReserved, Count: 0},
+			{Status: domain.ItemStatusSoldOut, Count: 1},
+			{Status: domain.ItemStatusShipped, Count: 0},
+			{Status: domain.ItemStatusCompleted, Count: 0},
+		},
+		Prices: []priceFacet{
+			{Min: 0, Max: max(999), Count: 1},
+			{Min: 1000, Max: max(4999), Count: 1},
+			{Min: 5000, Count: 1},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("searchFacets() = %+v, want %+v", got, want)
+	}
+}
@@ -1,11 +1,9 @@
 package handler
 
 import (
-	"bytes"
 	"database/sql"
 	"fmt"
 	"github.com/patrickmn/go-cache"
-	"io"
 	"log"
 	"math"
 	"net/http"
@@ -15,6 +13,8 @@ import (
 
 	"github.com/1en0/mecari-build-hackathon-2023/backend/db"
 	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/imageproc"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/service"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 	"github.com/pkg/errors"
@@ -24,7 +24,7 @@ import (
 var (
 	logFile  = getEnv("LOGFILE", "access.log")
 	CA       *cache.Cache
-	itemKey  = "Item{%v}"
+	itemKey  = service.ItemKey
 	imageKey = "Image{%v}"
 )
 
@@ -60,14 +60,19 @@ type getOnSaleItemsResponse struct {
 	Name         string `json:"name"`
 	Price        int64  `json:"price"`
 	CategoryName string `json:"category_name"`
+	// PriceDropped is set if the price is lower than it was before, by PriceDropPercent
+	PriceDropped     bool  `json:"price_dropped"`
+	PriceDropPercent int64 `json:"price_drop_percent"`
 }
 
 type searchItemsResponse struct {
-	ID           int32  `json:"id"`
-	Name         string `json:"name"`
-	Price        int64  `json:"price"`
-	CategoryName string `json:"category_name"`
-	Status       int    `json:"status"`
+	ID               int32  `json:"id"`
+	Name             string `json:"name"`
+	Price            int64  `json:"price"`
+	CategoryName     string `json:"category_name"`
+	Status           int    `json:"status"`
+	PriceDropped     bool   `json:"price_dropped"`
+	PriceDropPercent int64  `json:"price_drop_percent"`
 }
 
 type getItemResponse struct {
@@ -80,6 +85,10 @@ type getItemResponse struct {
 	Description  string            `json:"description"`
 	Status       domain.ItemStatus `json:"status"`
 	Views        int64             `json:"views"`
+	Likes        int64             `json:"likes"`
+	// PriceChangedAt and DescriptionChangedAt are when the seller last edited them, empty if never
+	PriceChangedAt       string `json:"price_changed_at"`
+	DescriptionChangedAt string `json:"description_changed_at"`
 }
 
 type getCategoriesResponse struct {
@@ -114,7 +123,6 @@ type getPurchaseItemsResponse struct {
 	Status       domain.ItemStatus `json:"status"`
 }
 
-
 type addItemResponse struct {
 	ID int64 `json:"id"`
 }
@@ -131,6 +139,20 @@ type getBalanceResponse struct {
 	Balance int64 `json:"balance"`
 }
 
+type getBalanceHistoryResponse struct {
+	ID        int64             `json:"id"`
+	Kind      domain.LedgerKind `json:"kind"`
+	Amount    int64             `json:"amount"`
+	Balance   int64             `json:"balance"`
+	ItemID    int32             `json:"item_id,omitempty"`
+	CreatedAt string            `json:"created_at"`
+}
+
+type purchaseRequest struct {
+	CouponCode string `json:"coupon_code"`
+	Points     int64  `json:"points"`
+}
+
 type loginRequest struct {
 	UserID   int64  `json:"user_id"`
 	Password string `json:"password"`
@@ -143,10 +165,35 @@ type loginResponse struct {
 }
 
 type Handler struct {
-	DB       *sql.DB
-	UserRepo db.UserRepository
-	ItemRepo db.ItemRepository
+	DB           *sql.DB
+	UserRepo     db.UserRepository
+	ItemRepo     db.ItemRepository
 	PurchaseRepo db.PurchaseRepository
+	LedgerRepo   db.LedgerRepository
+	CouponRepo   db.CouponRepository
+	PayoutRepo   db.PayoutRepository
+	OfferRepo    db.OfferRepository
+	ImageRepo    db.ImageRepository
+	RevisionRepo db.RevisionRepository
+	PriceRepo    db.PriceHistoryRepository
+	ImportRepo   db.ImportRepository
+	LikeRepo     db.LikeRepository
+	Images       domain.ImageStore
+	Pipeline     *imageproc.Pipeline
+	Fees         domain.FeeSchedule
+	Purchases    *service.PurchaseService
+	// OfferTTL is how long an offer waits for an answer, and how long an accepted offer can be purchased
+	OfferTTL time.Duration
+	// PurgeRetention is how long deleted items and users are kept before they are purged
+	PurgeRetention time.Duration
+	// MaxItemImages is how many images an item can have
+	MaxItemImages int
+	// ImageMaxAge is how long browsers and CDNs use an image before checking whether it changed
+	ImageMaxAge time.Duration
+	// PriceBuckets are the ascending upper bounds of the price buckets of search facets
+	PriceBuckets []int64
+	// Imports has a slot for each import which can run at once, the others wait for one. Nil does not limit them.
+	Imports chan struct{}
 }
 
 func GetSecret() string {
@@ -262,19 +309,9 @@ func (h *Handler) AddItem(c echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
-
-	src, err := file.Open()
+	image, err := h.readUpload(file)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
-	}
-	defer src.Close()
-
-	var dest []byte
-	blob := bytes.NewBuffer(dest)
-	// TODO: pass very big file
-	// http.StatusBadRequest(400)
-	if _, err := io.Copy(blob, src); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		return err
 	}
 
 	_, err = h.ItemRepo.GetCategory(ctx, req.CategoryID)
@@ -284,23 +321,25 @@ func (h *Handler) AddItem(c echo.Context) error {
 		}
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
+	key, err := h.storeImage(ctx, image)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
 	newItem := domain.Item{
 		Name:        req.Name,
 		CategoryID:  req.CategoryID,
 		UserID:      userID,
 		Price:       req.Price,
 		Description: req.Description,
-		Image:       blob.Bytes(),
+		ImageKey:    key,
 		Status:      domain.ItemStatusInitial,
 	}
 	itemID, err := h.ItemRepo.AddItem(c.Request().Context(), newItem)
 	if err != nil {
+		h.deleteStoredImages(ctx, key)
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
-	// store in cache
-	CA.Set(fmt.Sprintf(imageKey, itemID), newItem.Image, cache.DefaultExpiration)
-
 	return c.JSON(http.StatusOK, addItemResponse{ID: int64(itemID)})
 }
 
@@ -333,26 +372,40 @@ func (h *Handler) Sell(c echo.Context) error {
 	if item.Status != domain.ItemStatusInitial {
 		return echo.NewHTTPError(http.StatusPreconditionFailed, "Item Status is not initial")
 	}
-	if err := h.ItemRepo.UpdateItemStatus(ctx, item.ID, domain.ItemStatusOnSale); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	if err := h.ItemRepo.UpdateItemStatus(ctx, item.ID, item.Status, domain.ItemStatusOnSale); err != nil {
+		return itemStatusError(err)
 	}
 
+	// status changed, delete from cache
+	CA.Delete(fmt.Sprintf(itemKey, item.ID))
+
 	return c.JSON(http.StatusOK, "successful")
 }
 
 func (h *Handler) GetOnSaleItems(c echo.Context) error {
 	ctx := c.Request().Context()
 
-	items, err := h.ItemRepo.GetOnSaleItems(ctx)
+	query := db.ItemQuery{Statuses: []domain.ItemStatus{domain.ItemStatusOnSale}}
+	paged, err := readList(c, &query)
+	if err != nil {
+		return err
+	}
+
+	items, next, err := h.ItemRepo.SearchItems(ctx, query)
 	// not found handling
-	if items == nil {
+	if items == nil && !paged {
 		return echo.NewHTTPError(http.StatusNotFound, "There is no item on sale")
 	}
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
-	var res []getOnSaleItemsResponse
+	highest, err := h.PriceRepo.GetHighestPrices(ctx, itemIDs(items))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	res := make([]getOnSaleItemsResponse, 0, len(items))
 	for _, item := range items {
 		cats, err := h.ItemRepo.GetCategories(ctx)
 		if err != nil {
@@ -360,12 +413,13 @@ func (h *Handler) GetOnSaleItems(c echo.Context) error {
 		}
 		for _, cat := range cats {
 			if cat.ID == item.CategoryID {
-				res = append(res, getOnSaleItemsResponse{ID: item.ID, Name: item.Name, Price: item.Price, CategoryName: cat.Name})
+				dropped, percent := priceDrop(highest, item)
+				res = append(res, getOnSaleItemsResponse{ID: item.ID, Name: item.Name, Price: item.Price, CategoryName: cat.Name, PriceDropped: dropped, PriceDropPercent: percent})
 			}
 		}
 	}
 
-	return c.JSON(http.StatusOK, res)
+	return pageJSON(c, paged, res, query.Sort, next)
 }
 
 func (h *Handler) GetItem(c echo.Context) error {
@@ -402,6 +456,11 @@ func (h *Handler) GetItem(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
+	changedAt, err := h.RevisionRepo.GetChangedAt(ctx, item.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
 	// Add history (Omitted for benchmarking)
 	/* err = h.ItemRepo.AddHistory(ctx, int64(-1), item.ID) // not login
 	if err != nil {
@@ -417,6 +476,9 @@ func (h *Handler) GetItem(c echo.Context) error {
 		Price:        item.Price,
 		Description:  item.Description,
 		Status:       item.Status,
+
+		PriceChangedAt:       changedAt[domain.ItemFieldPrice],
+		DescriptionChangedAt: changedAt[domain.ItemFieldDescription],
 	}
 
 	// save to cache
@@ -457,6 +519,15 @@ func (h *Handler) GetItemWithAuth(c echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
+	likes, err := h.LikeRepo.GetLikeCount(ctx, item.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	changedAt, err := h.RevisionRepo.GetChangedAt(ctx, item.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
 
 	// Add history
 	userID, _ := getUserID(c)
@@ -475,17 +546,30 @@ func (h *Handler) GetItemWithAuth(c echo.Context) error {
 		Description:  item.Description,
 		Status:       item.Status,
 		Views:        views,
+		Likes:        likes,
+
+		PriceChangedAt:       changedAt[domain.ItemFieldPrice],
+		DescriptionChangedAt: changedAt[domain.ItemFieldDescription],
 	})
 }
 
+// searchStatuses are the statuses search returns when sold items are included
+var searchStatuses = []domain.ItemStatus{domain.ItemStatusOnSale, domain.ItemStatusReserved, domain.ItemStatusSoldOut, domain.ItemStatusShipped, domain.ItemStatusCompleted}
+
 func (h *Handler) SearchItemsByName(c echo.Context) error {
 	ctx := c.Request().Context()
 
 	name := c.QueryParam("name")
 
-	items, err := h.ItemRepo.GetItemsByName(ctx, name)
+	query := db.ItemQuery{Name: name, Statuses: searchStatuses, Sort: db.ItemSortRelevance}
+	paged, err := readList(c, &query)
+	if err != nil {
+		return err
+	}
+
+	items, next, err := h.ItemRepo.SearchItems(ctx, query)
 
-	if items == nil {
+	if items == nil && !paged {
 		return echo.NewHTTPError(http.StatusNotFound, "There is no item containing the name")
 	}
 
@@ -493,7 +577,12 @@ func (h *Handler) SearchItemsByName(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
-	var res []searchItemsResponse
+	highest, err := h.PriceRepo.GetHighestPrices(ctx, itemIDs(items))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	res := make([]searchItemsResponse, 0, len(items))
 	for _, item := range items {
 		cats, err := h.ItemRepo.GetCategories(ctx)
 		if err != nil {
@@ -501,11 +590,12 @@ func (h *Handler) SearchItemsByName(c echo.Context) error {
 		}
 		for _, cat := range cats {
 			if cat.ID == item.CategoryID {
-				res = append(res, searchItemsResponse{ID: item.ID, Name: item.Name, Price: item.Price, Status: int(item.Status), CategoryName: cat.Name})
+				dropped, percent := priceDrop(highest, item)
+				res = append(res, searchItemsResponse{ID: item.ID, Name: item.Name, Price: item.Price, Status: int(item.Status), CategoryName: cat.Name, PriceDropped: dropped, PriceDropPercent: percent})
 			}
 		}
 	}
-	return c.JSON(http.StatusOK, res)
+	return pageJSON(c, paged, res, query.Sort, next)
 }
 
 func (h *Handler) SearchItemsDetail(c echo.Context) error {
@@ -537,27 +627,35 @@ func (h *Handler) SearchItemsDetail(c echo.Context) error {
 		}
 	}
 
-	var items []domain.Item
-
-	if c.QueryParam("category") == "" {
-		if isIncludeSoldOut {
-			items, err = h.ItemRepo.GetItemsByNameAndPrice(ctx, name, priceMin, priceMax)
-		} else {
-			items, err = h.ItemRepo.GetOnSaleItemsByNameAndPrice(ctx, name, priceMin, priceMax)
-		}
-	} else {
+	query := db.ItemQuery{Name: name, PriceMin: priceMin, PriceMax: priceMax, Statuses: []domain.ItemStatus{domain.ItemStatusOnSale}, Sort: db.ItemSortRelevance}
+	if isIncludeSoldOut {
+		query.Statuses = searchStatuses
+	}
+	if c.QueryParam("category") != "" {
 		category_id, err := strconv.ParseInt(c.QueryParam("category"), 10, 64)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "invalid category type")
 		}
-		if isIncludeSoldOut {
-			items, err = h.ItemRepo.GetItemsByNameAndPriceAndCategory(ctx, name, priceMin, priceMax, int64(category_id))
-		} else {
-			items, err = h.ItemRepo.GetOnSaleItemsByNameAndPriceAndCategory(ctx, name, priceMin, priceMax, int64(category_id))
+		query.CategoryIDs = []int64{category_id}
+	}
+	paged, err := readList(c, &query)
+	if err != nil {
+		return err
+	}
+	var withFacets bool
+	if c.QueryParam("facets") != "" {
+		withFacets, err = strconv.ParseBool(c.QueryParam("facets"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid facets type")
+		}
+		if withFacets && !paged {
+			return echo.NewHTTPError(http.StatusBadRequest, "Facets are returned by /v2/search-detail.")
 		}
 	}
 
-	if items == nil {
+	items, next, err := h.ItemRepo.SearchItems(ctx, query)
+
+	if items == nil && !paged {
 		return echo.NewHTTPError(http.StatusNotFound, "There is no item containing the name")
 	}
 
@@ -565,7 +663,12 @@ func (h *Handler) SearchItemsDetail(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
-	var res []searchItemsResponse
+	highest, err := h.PriceRepo.GetHighestPrices(ctx, itemIDs(items))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	res := make([]searchItemsResponse, 0, len(items))
 	for _, item := range items {
 		cats, err := h.ItemRepo.GetCategories(ctx)
 		if err != nil {
@@ -573,11 +676,24 @@ func (h *Handler) SearchItemsDetail(c echo.Context) error {
 		}
 		for _, cat := range cats {
 			if cat.ID == item.CategoryID {
-				res = append(res, searchItemsResponse{ID: item.ID, Name: item.Name, Price: item.Price, Status: int(item.Status), CategoryName: cat.Name})
+				dropped, percent := priceDrop(highest, item)
+				res = append(res, searchItemsResponse{ID: item.ID, Name: item.Name, Price: item.Price, Status: int(item.Status), CategoryName: cat.Name, PriceDropped: dropped, PriceDropPercent: percent})
 			}
 		}
 	}
-	return c.JSON(http.StatusOK, res)
+	if !withFacets {
+		return pageJSON(c, paged, res, query.Sort, next)
+	}
+
+	facets, err := h.searchFacets(ctx, query)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	cursor, err := encodeCursor(query.Sort, next)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, pageResponse{Items: res, NextCursor: cursor, Facets: facets})
 }
 
 func (h *Handler) GetUserItems(c echo.Context) error {
@@ -587,18 +703,32 @@ func (h *Handler) GetUserItems(c echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "invalid userID type")
 	}
+	loginUserID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	query := db.ItemQuery{SellerID: userID}
+	// drafts, paused and withdrawn listings are only shown to the seller
+	if loginUserID != userID {
+		query.Statuses = searchStatuses
+	}
+	paged, err := readList(c, &query)
+	if err != nil {
+		return err
+	}
 
-	items, err := h.ItemRepo.GetItemsByUserID(ctx, userID)
+	items, next, err := h.ItemRepo.SearchItems(ctx, query)
 
 	// not found handling
-	if items == nil {
+	if items == nil && !paged {
 		return echo.NewHTTPError(http.StatusNotFound, "No items found for user "+strconv.FormatInt(userID, 10))
 	}
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
-	var res []getUserItemsResponse
+	res := make([]getUserItemsResponse, 0, len(items))
 	for _, item := range items {
 		cats, err := h.ItemRepo.GetCategories(ctx)
 		if err != nil {
@@ -611,7 +741,7 @@ func (h *Handler) GetUserItems(c echo.Context) error {
 		}
 	}
 
-	return c.JSON(http.StatusOK, res)
+	return pageJSON(c, paged, res, query.Sort, next)
 }
 
 func (h *Handler) GetCategories(c echo.Context) error {
@@ -651,19 +781,23 @@ func (h *Handler) GetImage(c echo.Context) error {
 	if cachedImage, found := CA.Get(fmt.Sprintf(imageKey, itemID)); found {
 		// cache hit
 		log.Println(fmt.Sprintf("cache hit: image %v", itemID))
-		return c.Blob(http.StatusOK, "image/jpeg", cachedImage.([]byte))
+		return h.serveImage(c, cachedImage.(imageResponse))
 	}
 
 	// オーバーフローしていると。ここのint32(itemID)がバグって正常に処理ができないはず
-	data, err := h.ItemRepo.GetItemImage(ctx, int32(itemID))
+	image, err := h.readImage(ctx, int32(itemID), 0)
 	if err != nil {
+		// not found handling
+		if err == sql.ErrNoRows || err == domain.ErrImageNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
 	// save into cache
-	CA.Set(fmt.Sprintf(imageKey, itemID), data, cache.DefaultExpiration)
+	CA.Set(fmt.Sprintf(imageKey, itemID), image, cache.DefaultExpiration)
 
-	return c.Blob(http.StatusOK, "image/jpeg", data)
+	return h.serveImage(c, image)
 }
 
 func (h *Handler) AddBalance(c echo.Context) error {
@@ -683,9 +817,13 @@ func (h *Handler) AddBalance(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
 	}
 
-	user, err := h.UserRepo.GetUser(ctx, userID)
-
+	tx, err := h.DB.BeginTx(ctx, nil)
 	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer tx.Rollback()
+
+	if _, err := h.UserRepo.GetUserTx(tx, ctx, userID); err != nil {
 		// not found handling
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusPreconditionFailed, err.Error())
@@ -693,7 +831,15 @@ func (h *Handler) AddBalance(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
-	if err := h.UserRepo.UpdateBalance(ctx, userID, user.Balance+req.Balance); err != nil {
+	// money comes from outside of the platform
+	if _, err := h.LedgerRepo.PostTx(tx, ctx, domain.LedgerKindTopUp, 0, []domain.LedgerEntry{
+		{Account: domain.LedgerAccountExternal, Amount: -req.Balance},
+		{Account: domain.LedgerAccountWallet, UserID: userID, Amount: req.Balance},
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
@@ -721,7 +867,7 @@ func (h *Handler) GetBalance(c echo.Context) error {
 	return c.JSON(http.StatusOK, getBalanceResponse{Balance: user.Balance})
 }
 
-func (h *Handler) Purchase(c echo.Context) error {
+func (h *Handler) GetBalanceHistory(c echo.Context) error {
 	ctx := c.Request().Context()
 
 	userID, err := getUserID(c)
@@ -729,90 +875,32 @@ func (h *Handler) Purchase(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
 	}
 
-	itemID, err := strconv.Atoi(c.Param("itemID"))
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
-	}
-
-	// check whether itemID is within the range of int32
-	if itemID > math.MaxInt32 || itemID < math.MinInt32 {
-		return echo.NewHTTPError(http.StatusBadRequest, "ItemID out of range")
-	}
-
-	// balance consistency
-	tx, err := h.DB.BeginTx(ctx, nil)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
-	}
-	defer tx.Rollback()
-
-	item, err := h.ItemRepo.GetItemTx(tx, ctx, int32(itemID))
-	if err != nil {
-		//not found handling
-		if err == sql.ErrNoRows {
-			return echo.NewHTTPError(http.StatusPreconditionFailed, err.Error())
+	var limit int64 = 20
+	var offset int64 = 0
+	if c.QueryParam("limit") != "" {
+		limit, err = strconv.ParseInt(c.QueryParam("limit"), 10, 64)
+		if err != nil || limit <= 0 || limit > 100 {
+			return echo.NewHTTPError(http.StatusBadRequest, "limit must be between 1 and 100")
 		}
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
-	}
-
-	// update only when item status is on sale
-	if item.Status != domain.ItemStatusOnSale {
-		return echo.NewHTTPError(http.StatusPreconditionFailed, "This item is not on sale.")
 	}
-
-	// not to buy own items
-	if item.UserID == userID {
-		return echo.NewHTTPError(http.StatusPreconditionFailed, "Cannot buy your own item.")
-	}
-
-	user, err := h.UserRepo.GetUserTx(tx, ctx, userID)
-	if err != nil {
-		//not found handling
-		if err == sql.ErrNoRows {
-			return echo.NewHTTPError(http.StatusPreconditionFailed, err.Error())
+	if c.QueryParam("offset") != "" {
+		offset, err = strconv.ParseInt(c.QueryParam("offset"), 10, 64)
+		if err != nil || offset < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid offset")
 		}
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
-	//check if item.price > user.balance
-	if item.Price > user.Balance {
-		return echo.NewHTTPError(http.StatusBadRequest, "Your balance is not enough.")
-	}
-
-	// オーバーフローしていると。ここのint32(itemID)がバグって正常に処理ができないはず
-	if err := h.ItemRepo.UpdateItemStatusTx(tx, ctx, int32(itemID), domain.ItemStatusSoldOut); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
-	}
-
-	// status changed, delete from cache
-	CA.Delete(fmt.Sprintf(itemKey, itemID))
-
-	if err := h.UserRepo.UpdateBalanceTx(tx, ctx, userID, user.Balance-item.Price); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
-	}
-
-	sellerID := item.UserID
-
-	seller, err := h.UserRepo.GetUserTx(tx, ctx, sellerID)
-
+	entries, err := h.LedgerRepo.GetWalletEntries(ctx, userID, limit, offset)
 	if err != nil {
-		//not found handling
-		if err == sql.ErrNoRows {
-			return echo.NewHTTPError(http.StatusPreconditionFailed, err.Error())
-		}
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
-	}
-
-	if err := h.UserRepo.UpdateBalanceTx(tx, ctx, sellerID, seller.Balance+item.Price); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
-	// Commit the transaction
-	if err := tx.Commit(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	res := make([]getBalanceHistoryResponse, len(entries))
+	for i, e := range entries {
+		res[i] = getBalanceHistoryResponse{ID: e.ID, Kind: e.Kind, Amount: e.Amount, Balance: e.Balance, ItemID: e.ItemID, CreatedAt: e.CreatedAt}
 	}
 
-	return c.JSON(http.StatusOK, "successful")
+	return c.JSON(http.StatusOK, res)
 }
 
 func (h *Handler) EditItem(c echo.Context) error {
@@ -876,38 +964,53 @@ func (h *Handler) EditItem(c echo.Context) error {
 	file, err := c.FormFile("image")
 	if err != nil {
 		if err == http.ErrMissingFile {
-			newItem.Image = nil
+			newItem.ImageKey = ""
 		} else {
 			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 		}
 	} else {
-		src, err := file.Open()
+		image, err := h.readUpload(file)
+		if err != nil {
+			return err
+		}
+		newItem.ImageKey, err = h.storeImage(ctx, image)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 		}
-		defer src.Close()
+	}
 
-		var dest []byte
-		blob := bytes.NewBuffer(dest)
-		// TODO: pass very big file
-		// http.StatusBadRequest(400)
-		if _, err := io.Copy(blob, src); err != nil {
+	// the new image replaces the cover
+	var oldKey string
+	if newItem.ImageKey != "" {
+		images, err := h.ImageRepo.GetImages(ctx, newItem.ID)
+		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 		}
-		newItem.Image = blob.Bytes()
-
-		// update image cache
-		CA.Set(fmt.Sprintf(imageKey, itemID), newItem.Image, cache.DefaultExpiration)
+		if len(images) > 0 {
+			oldKey = images[0].Key
+		}
 	}
 
 	_, err = h.ItemRepo.EditItem(c.Request().Context(), newItem)
 
 	// status changed, delete from cache
 	CA.Delete(fmt.Sprintf(itemKey, itemID))
+	CA.Delete(fmt.Sprintf(imageKey, itemID))
 
 	if err != nil {
+		h.deleteStoredImages(ctx, newItem.ImageKey)
+		switch err {
+		// not found handling
+		case sql.ErrNoRows:
+			return echo.NewHTTPError(http.StatusPreconditionFailed, err.Error())
+		case db.ErrNotEditable:
+			return echo.NewHTTPError(http.StatusPreconditionFailed, "Only drafts, items on sale and paused items can be edited.")
+		case db.ErrConflict:
+			return echo.NewHTTPError(http.StatusConflict, "The item was changed by another request.")
+		}
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
+	h.deleteStoredImages(ctx, oldKey)
 
 	return c.JSON(http.StatusOK, editItemResponse{ID: int64(item.ID)})
 }
@@ -925,6 +1028,44 @@ func getUserID(c echo.Context) (int64, error) {
 	return claims.UserID, nil
 }
 
+func getItemID(c echo.Context) (int32, error) {
+	itemID, err := strconv.ParseInt(c.Param("itemID"), 10, 32)
+	if err != nil {
+		return -1, echo.NewHTTPError(http.StatusBadRequest, "invalid itemID")
+	}
+	return int32(itemID), nil
+}
+
+// itemIDs are the IDs of the items of a page, to read only their highest prices
+func itemIDs(items []domain.Item) []int32 {
+	ids := make([]int32, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
+
+// priceDrop compares the price of the item with the highest one it had, from GetHighestPrices
+func priceDrop(highest map[int32]int64, item domain.Item) (bool, int64) {
+	h, ok := highest[item.ID]
+	if !ok || item.Price >= h {
+		return false, 0
+	}
+	return true, domain.PriceDropPercent(h, item.Price)
+}
+
+// itemStatusError converts the errors of UpdateItemStatus into responses
+func itemStatusError(err error) error {
+	var transitionErr *domain.ItemTransitionError
+	if errors.As(err, &transitionErr) {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, transitionErr.Error())
+	}
+	if err == db.ErrConflict {
+		return echo.NewHTTPError(http.StatusConflict, "The item was changed by another request.")
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+}
+
 func getEnv(key string, defaultValue string) string {
 	value := os.Getenv(key)
 	if value == "" {
@@ -941,16 +1082,23 @@ func (h *Handler) GetPurchasedItems(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "invalid userID type")
 	}
 
-	items, err := h.ItemRepo.GetItemsByBuyerID(ctx, userID)
+	// the purchase history is kept even if the seller deletes the items
+	query := db.ItemQuery{BuyerID: userID, WithDeleted: true}
+	paged, err := readList(c, &query)
+	if err != nil {
+		return err
+	}
+
+	items, next, err := h.ItemRepo.SearchItems(ctx, query)
 
-	if items == nil {
+	if items == nil && !paged {
 		return echo.NewHTTPError(http.StatusNotFound, "No items found for user "+strconv.FormatInt(userID, 10))
 	}
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
-	var res []getPurchaseItemsResponse
+	res := make([]getPurchaseItemsResponse, 0, len(items))
 	for _, item := range items {
 		cats, err := h.ItemRepo.GetCategories(ctx)
 		if err != nil {
@@ -963,101 +1111,5 @@ func (h *Handler) GetPurchasedItems(c echo.Context) error {
 		}
 	}
 
-	return c.JSON(http.StatusOK, res)
-}
-
-func (h *Handler) PurchaseV2(c echo.Context) error {
-	ctx := c.Request().Context()
-
-	userID, err := getUserID(c)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
-	}
-
-	itemID, err := strconv.Atoi(c.Param("itemID"))
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
-	}
-
-	// check whether itemID is within the range of int32
-	if itemID > math.MaxInt32 || itemID < math.MinInt32 {
-		return echo.NewHTTPError(http.StatusBadRequest, "ItemID out of range")
-	}
-
-	// balance consistency
-	tx, err := h.DB.BeginTx(ctx, nil)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
-	}
-	defer tx.Rollback()
-
-	item, err := h.ItemRepo.GetItemTx(tx, ctx, int32(itemID))
-	if err != nil {
-		//not found handling
-		if err == sql.ErrNoRows {
-			return echo.NewHTTPError(http.StatusPreconditionFailed, err.Error())
-		}
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
-	}
-
-	// update only when item status is on sale
-	if item.Status != domain.ItemStatusOnSale {
-		return echo.NewHTTPError(http.StatusPreconditionFailed, "This item is not on sale.")
-	}
-
-	// not to buy own items
-	if item.UserID == userID {
-		return echo.NewHTTPError(http.StatusPreconditionFailed, "Cannot buy your own item.")
-	}
-
-	user, err := h.UserRepo.GetUserTx(tx, ctx, userID)
-	if err != nil {
-		//not found handling
-		if err == sql.ErrNoRows {
-			return echo.NewHTTPError(http.StatusPreconditionFailed, err.Error())
-		}
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
-	}
-
-	//check if item.price > user.balance
-	if item.Price > user.Balance {
-		return echo.NewHTTPError(http.StatusBadRequest, "Your balance is not enough.")
-	}
-
-	// オーバーフローしていると。ここのint32(itemID)がバグって正常に処理ができないはず
-	if err := h.ItemRepo.UpdateItemStatusTx(tx, ctx, int32(itemID), domain.ItemStatusSoldOut); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
-	}
-
-	if err := h.UserRepo.UpdateBalanceTx(tx, ctx, userID, user.Balance-item.Price); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
-	}
-
-	// Purchase Tableに追加
-	if err := h.PurchaseRepo.AddPurchaseTx(tx, ctx, item.ID, user.ID); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
-	}
-
-	sellerID := item.UserID
-
-	seller, err := h.UserRepo.GetUserTx(tx, ctx, sellerID)
-
-	if err != nil {
-		//not found handling
-		if err == sql.ErrNoRows {
-			return echo.NewHTTPError(http.StatusPreconditionFailed, err.Error())
-		}
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
-	}
-
-	if err := h.UserRepo.UpdateBalanceTx(tx, ctx, sellerID, seller.Balance+item.Price); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
-	}
-
-	// Commit the transaction
-	if err := tx.Commit(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
-	}
-
-	return c.JSON(http.StatusOK, "successful")
+	return pageJSON(c, paged, res, query.Sort, next)
 }
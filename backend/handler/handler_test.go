@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/db"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/patrickmn/go-cache"
+)
+
+// newTestHandler returns a Handler on an empty DB in a temporary directory
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	sqlDB, err := db.OpenDB(context.Background(), filepath.Join(t.TempDir(), "test.sqlite3"), filepath.Join("..", "sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	// handlers clear the items they change from the cache
+	CA = cache.New(time.Minute, time.Minute)
+
+	return &Handler{
+		DB:           sqlDB,
+		UserRepo:     db.NewUserRepository(sqlDB),
+		ItemRepo:     db.NewItemRepository(sqlDB),
+		PurchaseRepo: db.NewPurchaseRepository(sqlDB),
+		LedgerRepo:   db.NewLedgerRepository(sqlDB),
+		CouponRepo:   db.NewCouponRepository(sqlDB),
+		PayoutRepo:   db.NewPayoutRepository(sqlDB),
+		OfferRepo:    db.NewOfferRepository(sqlDB),
+		ImageRepo:    db.NewImageRepository(sqlDB),
+		RevisionRepo: db.NewRevisionRepository(sqlDB),
+		PriceRepo:    db.NewPriceHistoryRepository(sqlDB),
+		ImportRepo:   db.NewImportRepository(sqlDB),
+		LikeRepo:     db.NewLikeRepository(sqlDB),
+	}
+}
+
+// addTestUser adds a user with the balance topped up
+func addTestUser(t *testing.T, h *Handler, balance int64) int64 {
+	t.Helper()
+	ctx := context.Background()
+	userID, err := h.UserRepo.AddUser(ctx, domain.User{Name: "user", Password: "password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance == 0 {
+		return userID
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err := h.LedgerRepo.PostTx(tx, ctx, domain.LedgerKindTopUp, 0, []domain.LedgerEntry{
+		{Account: domain.LedgerAccountExternal, Amount: -balance},
+		{Account: domain.LedgerAccountWallet, UserID: userID, Amount: balance},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return userID
+}
+
+// addTestItem adds an item on sale in the category 1
+func addTestItem(t *testing.T, h *Handler, sellerID int64, price int64) int32 {
+	t.Helper()
+	if _, err := h.DB.Exec("INSERT OR IGNORE INTO category (id, name) VALUES (1, 'book')"); err != nil {
+		t.Fatal(err)
+	}
+	itemID, err := h.ItemRepo.AddItem(context.Background(), domain.Item{Name: "item", Price: price, Description: "description", CategoryID: 1, UserID: sellerID, Status: domain.ItemStatusOnSale})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return itemID
+}
+
+// newTestContext returns the context of a JSON request by the login user, with the path params given as name and value pairs
+func newTestContext(userID int64, method string, target string, body string, params ...string) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.Set("user", &jwt.Token{Claims: &JwtCustomClaims{UserID: userID}})
+	var names, values []string
+	for i := 0; i+1 < len(params); i += 2 {
+		names = append(names, params[i])
+		values = append(values, params[i+1])
+	}
+	c.SetParamNames(names...)
+	c.SetParamValues(values...)
+	return c, rec
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/db"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotencyReplayedHeader = "Idempotent-Replayed"
+)
+
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// Idempotency replays the stored response when a request is retried with the same Idempotency-Key.
+// It has to be used behind the jwt middleware because keys are scoped per user.
+func Idempotency(repo db.IdempotencyRepository) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx := c.Request().Context()
+
+			key := c.Request().Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				return next(c)
+			}
+			if len(key) > 255 {
+				return echo.NewHTTPError(http.StatusBadRequest, "Idempotency-Key is too long")
+			}
+
+			userID, err := getUserID(c)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+			}
+
+			body, err := io.ReadAll(c.Request().Body)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+			c.Request().Body = io.NopCloser(bytes.NewReader(body))
+
+			hash := sha256.New()
+			hash.Write([]byte(c.Request().Method + " " + c.Request().URL.Path + "\n"))
+			hash.Write(body)
+			requestHash := hex.EncodeToString(hash.Sum(nil))
+
+			record, reserved, err := repo.Reserve(ctx, userID, key, requestHash)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+			}
+			if !reserved {
+				if record.RequestHash != requestHash {
+					return echo.NewHTTPError(http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+				}
+				if record.Status == 0 {
+					return echo.NewHTTPError(http.StatusConflict, "A request with the same Idempotency-Key is being processed")
+				}
+				c.Response().Header().Set(IdempotencyReplayedHeader, "true")
+				return c.Blob(record.Status, record.ContentType, record.Body)
+			}
+
+			// a panic is recovered by the outer middleware, so the key is released before it goes on
+			defer func() {
+				if r := recover(); r != nil {
+					if err := repo.Release(ctx, userID, key); err != nil {
+						log.Printf("failed to release idempotency key %q: %v", key, err)
+					}
+					panic(r)
+				}
+			}()
+
+			rec := &idempotencyRecorder{ResponseWriter: c.Response().Writer}
+			c.Response().Writer = rec
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
+
+			// server errors are not stored so that the client can retry them
+			status := c.Response().Status
+			if status >= http.StatusInternalServerError {
+				err = repo.Release(ctx, userID, key)
+			} else {
+				err = repo.Complete(ctx, userID, key, status, c.Response().Header().Get(echo.HeaderContentType), rec.body.Bytes())
+			}
+			if err != nil {
+				log.Printf("failed to store idempotency key %q: %v", key, err)
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/db"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// newTestIdempotency serves POST /items behind Idempotency for the user 1 with the handler, which is counted by calls
+func newTestIdempotency(t *testing.T, handler echo.HandlerFunc) (*echo.Echo, db.IdempotencyRepository, *int) {
+	t.Helper()
+	repo := db.NewIdempotencyRepository(newTestHandler(t).DB)
+	calls := new(int)
+	e := echo.New()
+	e.Use(middleware.RecoverWithConfig(middleware.RecoverConfig{DisablePrintStack: true}))
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("user", &jwt.Token{Claims: &JwtCustomClaims{UserID: 1}})
+			return next(c)
+		}
+	})
+	e.POST("/items", func(c echo.Context) error {
+		*calls++
+		return handler(c)
+	}, Idempotency(repo))
+	return e, repo, calls
+}
+
+func serveIdempotent(e *echo.Echo, key string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/items", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(IdempotencyKeyHeader, key)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIdempotencyReplays(t *testing.T) {
+	e, _, calls := newTestIdempotency(t, func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]int{"id": 1})
+	})
+
+	first := serveIdempotent(e, "key", `{"name": "item"}`)
+	second := serveIdempotent(e, "key", `{"name": "item"}`)
+	if *calls != 1 {
+		t.Errorf("handler calls = %d, want 1", *calls)
+	}
+	if second.Code != first.Code || second.Body.String() != first.Body.String() {
+		t.Errorf("replay = %d %q, want %d %q", second.Code, second.Body.String(), first.Code, first.Body.String())
+	}
+	if second.Header().Get(IdempotencyReplayedHeader) != "true" {
+		t.Errorf("%s = %q, want true", IdempotencyReplayedHeader, second.Header().Get(IdempotencyReplayedHeader))
+	}
+
+	if rec := serveIdempotent(e, "key", `{"name": "other"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status of another body = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestIdempotencyInFlight(t *testing.T) {
+	var e *echo.Echo
+	var retry *httptest.ResponseRecorder
+	e, _, calls := newTestIdempotency(t, func(c echo.Context) error {
+		// the retry comes while the first request is still being handled
+		if retry == nil {
+			retry = serveIdempotent(e, "key", `{"name": "item"}`)
+		}
+		return c.JSON(http.StatusOK, "successful")
+	})
+
+	if rec := serveIdempotent(e, "key", `{"name": "item"}`); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if retry.Code != http.StatusConflict {
+		t.Errorf("status of the retry in flight = %d, want %d", retry.Code, http.StatusConflict)
+	}
+	if *calls != 1 {
+		t.Errorf("handler calls = %d, want 1", *calls)
+	}
+}
+
+func TestIdempotencyReleasesFailures(t *testing.T) {
+	tests := []struct {
+		name string
+		fail func() error
+	}{
+		{
+			name: "server error",
+			fail: func() error { return echo.NewHTTPError(http.StatusInternalServerError, "failed") },
+		},
+		{
+			name: "panic",
+			fail: func() error { panic("failed") },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failed := false
+			e, _, calls := newTestIdempotency(t, func(c echo.Context) error {
+				if !failed {
+					failed = true
+					return tt.fail()
+				}
+				return c.JSON(http.StatusOK, "successful")
+			})
+
+			if rec := serveIdempotent(e, "key", `{"name": "item"}`); rec.Code != http.StatusInternalServerError {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+			}
+			// the retry is handled again instead of replaying the failure
+			if rec := serveIdempotent(e, "key", `{"name": "item"}`); rec.Code != http.StatusOK {
+				t.Errorf("status of the retry = %d, want %d", rec.Code, http.StatusOK)
+			}
+			if *calls != 2 {
+				t.Errorf("handler calls = %d, want 2", *calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,431 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/db"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/imageproc"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/imagestore"
+	"github.com/labstack/echo/v4"
+)
+
+type addItemImageResponse struct {
+	Index int `json:"index"`
+}
+
+type getItemImagesResponse struct {
+	Index     int    `json:"index"`
+	URL       string `json:"url"`
+	CreatedAt string `json:"created_at"`
+}
+
+type reorderItemImagesRequest struct {
+	// Order lists the current indexes in the new order
+	Order []int `json:"order"`
+}
+
+// AddItemImage appends a photo to the item
+func (h *Handler) AddItemImage(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	itemID, err := getItemID(c)
+	if err != nil {
+		return err
+	}
+
+	file, err := c.FormFile("image")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	image, err := h.readUpload(file)
+	if err != nil {
+		return err
+	}
+	key, err := h.storeImage(ctx, image)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	// drop the stored image if it does not end up in the DB
+	committed := false
+	defer func() {
+		if !committed {
+			h.deleteStoredImages(ctx, key)
+		}
+	}()
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer tx.Rollback()
+
+	images, err := h.getImagesToEditTx(tx, ctx, itemID, userID)
+	if err != nil {
+		return err
+	}
+	if len(images) >= h.MaxItemImages {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, fmt.Sprintf("An item can have up to %d images.", h.MaxItemImages))
+	}
+	index, err := h.ImageRepo.AddImageTx(tx, ctx, itemID, key)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	committed = true
+
+	// the cover may have changed, delete from cache
+	CA.Delete(fmt.Sprintf(imageKey, itemID))
+
+	return c.JSON(http.StatusOK, addItemImageResponse{Index: index})
+}
+
+func (h *Handler) GetItemImages(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	itemID, err := getItemID(c)
+	if err != nil {
+		return err
+	}
+	if _, err := h.ItemRepo.GetItem(ctx, itemID); err != nil {
+		// not found handling
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	images, err := h.ImageRepo.GetImages(ctx, itemID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	res := make([]getItemImagesResponse, len(images))
+	for i, img := range images {
+		res[i] = getItemImagesResponse{
+			Index:     img.Position,
+			URL:       fmt.Sprintf("/items/%d/images/%d", itemID, img.Position),
+			CreatedAt: img.CreatedAt,
+		}
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// GetItemImageAt returns one photo of the item. Index 0 is the cover returned by GetImage.
+func (h *Handler) GetItemImageAt(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	itemID, err := getItemID(c)
+	if err != nil {
+		return err
+	}
+	index, err := getImageIndex(c)
+	if err != nil {
+		return err
+	}
+
+	image, err := h.readImage(ctx, itemID, index)
+	if err != nil {
+		// not found handling
+		if err == sql.ErrNoRows || err == domain.ErrImageNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return h.serveImage(c, image)
+}
+
+// GetThumbnail returns the small square version of the cover for list views.
+// Covers stored without a thumbnail, such as the ones migrate-images could not process, are returned as they are.
+func (h *Handler) GetThumbnail(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	itemID, err := getItemID(c)
+	if err != nil {
+		return err
+	}
+
+	image, err := h.readThumbnail(ctx, itemID)
+	if err != nil {
+		// not found handling
+		if err == sql.ErrNoRows || err == domain.ErrImageNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return h.serveImage(c, image)
+}
+
+func (h *Handler) ReorderItemImages(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	itemID, err := getItemID(c)
+	if err != nil {
+		return err
+	}
+	req := new(reorderItemImagesRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer tx.Rollback()
+
+	images, err := h.getImagesToEditTx(tx, ctx, itemID, userID)
+	if err != nil {
+		return err
+	}
+
+	// validation
+	if len(req.Order) != len(images) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Order must list every image once.")
+	}
+	ids := make([]int64, len(req.Order))
+	seen := make(map[int]bool, len(req.Order))
+	for i, index := range req.Order {
+		if index < 0 || index >= len(images) || seen[index] {
+			return echo.NewHTTPError(http.StatusBadRequest, "Order must list every image once.")
+		}
+		seen[index] = true
+		ids[i] = images[index].ID
+	}
+
+	if err := h.ImageRepo.ReorderImagesTx(tx, ctx, itemID, ids); err != nil {
+		if err == db.ErrConflict {
+			return echo.NewHTTPError(http.StatusConflict, "The images were changed by another request.")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	// the cover may have changed, delete from cache
+	CA.Delete(fmt.Sprintf(imageKey, itemID))
+
+	return c.JSON(http.StatusOK, "successful")
+}
+
+func (h *Handler) DeleteItemImage(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	itemID, err := getItemID(c)
+	if err != nil {
+		return err
+	}
+	index, err := getImageIndex(c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer tx.Rollback()
+
+	images, err := h.getImagesToEditTx(tx, ctx, itemID, userID)
+	if err != nil {
+		return err
+	}
+	if index >= len(images) {
+		return echo.NewHTTPError(http.StatusNotFound, "No image found.")
+	}
+	if len(images) == 1 {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "An item needs at least one image.")
+	}
+
+	if err := h.ImageRepo.DeleteImageTx(tx, ctx, itemID, index); err != nil {
+		if err == db.ErrConflict {
+			return echo.NewHTTPError(http.StatusConflict, "The images were changed by another request.")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	h.deleteStoredImages(ctx, images[index].Key)
+
+	// the cover may have changed, delete from cache
+	CA.Delete(fmt.Sprintf(imageKey, itemID))
+
+	return c.JSON(http.StatusOK, "successful")
+}
+
+// getImagesToEditTx returns the images of the item after checking the user can edit it
+func (h *Handler) getImagesToEditTx(tx *sql.Tx, ctx context.Context, itemID int32, userID int64) ([]domain.ItemImage, error) {
+	item, err := h.ItemRepo.GetItemTx(tx, ctx, itemID)
+	if err != nil {
+		// not found handling
+		if err == sql.ErrNoRows {
+			return nil, echo.NewHTTPError(http.StatusPreconditionFailed, err.Error())
+		}
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if item.UserID != userID {
+		return nil, echo.NewHTTPError(http.StatusPreconditionFailed, "Cannot edit other user's item")
+	}
+	if !item.Status.Editable() {
+		return nil, echo.NewHTTPError(http.StatusPreconditionFailed, fmt.Sprintf("Cannot edit a %v item", item.Status))
+	}
+
+	images, err := h.ImageRepo.GetImagesTx(tx, ctx, itemID)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return images, nil
+}
+
+func getImageIndex(c echo.Context) (int, error) {
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil || index < 0 {
+		return -1, echo.NewHTTPError(http.StatusBadRequest, "invalid index")
+	}
+	return index, nil
+}
+
+// readUpload reads the uploaded image through the Pipeline. Uploads which are not valid images are a 400.
+func (h *Handler) readUpload(file *multipart.FileHeader) (imageproc.Image, error) {
+	src, err := file.Open()
+	if err != nil {
+		return imageproc.Image{}, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer src.Close()
+
+	// read one byte over the limit so that the Pipeline rejects larger files without reading them whole
+	data, err := io.ReadAll(io.LimitReader(src, h.Pipeline.MaxBytes+1))
+	if err != nil {
+		return imageproc.Image{}, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	image, err := h.Pipeline.Process(data)
+	if err != nil {
+		return imageproc.Image{}, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return image, nil
+}
+
+// storeImage puts the image and its thumbnail in the ImageStore under a new key
+func (h *Handler) storeImage(ctx context.Context, image imageproc.Image) (string, error) {
+	key, err := imagestore.NewKey()
+	if err != nil {
+		return "", err
+	}
+	if err := h.Images.Put(ctx, key, image.Data); err != nil {
+		return "", err
+	}
+	if image.Thumbnail != nil {
+		if err := h.Images.Put(ctx, imagestore.ThumbnailKey(key), image.Thumbnail); err != nil {
+			h.deleteStoredImages(ctx, key)
+			return "", err
+		}
+	}
+	return key, nil
+}
+
+// readImage returns the image of the item at index from the ImageStore, or from the DB if it was not moved yet
+func (h *Handler) readImage(ctx context.Context, itemID int32, index int) (imageResponse, error) {
+	img, err := h.ImageRepo.GetImage(ctx, itemID, index)
+	if err != nil {
+		return imageResponse{}, err
+	}
+	data := img.Data
+	if img.Key != "" {
+		if data, err = h.Images.Get(ctx, img.Key); err != nil {
+			return imageResponse{}, err
+		}
+	}
+	return newImageResponse(data, img.UpdatedAt), nil
+}
+
+// readThumbnail returns the thumbnail of the cover, or the cover itself if it has no thumbnail
+func (h *Handler) readThumbnail(ctx context.Context, itemID int32) (imageResponse, error) {
+	img, err := h.ImageRepo.GetImage(ctx, itemID, 0)
+	if err != nil {
+		return imageResponse{}, err
+	}
+	data := img.Data
+	if img.Key != "" {
+		data, err = h.Images.Get(ctx, imagestore.ThumbnailKey(img.Key))
+		if err == domain.ErrImageNotFound {
+			data, err = h.Images.Get(ctx, img.Key)
+		}
+		if err != nil {
+			return imageResponse{}, err
+		}
+	}
+	return newImageResponse(data, img.UpdatedAt), nil
+}
+
+// imageResponse is an image with the validators of conditional requests. GetImage keeps it in the cache.
+type imageResponse struct {
+	data []byte
+	// etag is a hash of data
+	etag string
+	// modified is the updated_at of the item, zero if unknown
+	modified time.Time
+}
+
+func newImageResponse(data []byte, updatedAt string) imageResponse {
+	sum := sha256.Sum256(data)
+	modified, _ := time.ParseInLocation(db.TimeLayout, updatedAt, time.Local)
+	return imageResponse{data: data, etag: `"` + hex.EncodeToString(sum[:16]) + `"`, modified: modified}
+}
+
+// serveImage answers with the image, or with 304 if the client has it already.
+// Range requests are served too, so that large images can be resumed.
+func (h *Handler) serveImage(c echo.Context, image imageResponse) error {
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, imageproc.ContentType(image.data))
+	header.Set("ETag", image.etag)
+	header.Set(echo.HeaderCacheControl, fmt.Sprintf("public, max-age=%d", int(h.ImageMaxAge.Seconds())))
+	http.ServeContent(c.Response(), c.Request(), "", image.modified, bytes.NewReader(image.data))
+	return nil
+}
+
+// deleteStoredImages removes images which are no longer referenced, with their thumbnails.
+// Failures only leave unused files behind.
+func (h *Handler) deleteStoredImages(ctx context.Context, keys ...string) {
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		for _, k := range []string{key, imagestore.ThumbnailKey(key)} {
+			if err := h.Images.Delete(ctx, k); err != nil {
+				log.Printf("failed to delete image %s: %s", k, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestServeImage(t *testing.T) {
+	h := &Handler{ImageMaxAge: time.Minute}
+	data := []byte("\x89PNG\r\n\x1a\nimage data")
+	image := newImageResponse(data, "2023-01-02 03:04:05")
+
+	tests := []struct {
+		name     string
+		header   map[string]string
+		wantCode int
+		wantBody string
+	}{
+		{"get", nil, http.StatusOK, string(data)},
+		{"same etag", map[string]string{"If-None-Match": image.etag}, http.StatusNotModified, ""},
+		{"other etag", map[string]string{"If-None-Match": `"other"`}, http.StatusOK, string(data)},
+		{"not modified since", map[string]string{"If-Modified-Since": image.modified.UTC().Format(http.TimeFormat)}, http.StatusNotModified, ""},
+		{"range", map[string]string{"Range": "bytes=0-3"}, http.StatusPartialContent, "\x89PNG"},
+		{"range of the same image", map[string]string{"Range": "bytes=8-", "If-Range": image.etag}, http.StatusPartialContent, "image data"},
+		// the image changed since the client got the start, so it gets it whole
+		{"range of another image", map[string]string{"Range": "bytes=8-", "If-Range": `"other"`}, http.StatusOK, string(data)},
+		{"range out of the image", map[string]string{"Range": "bytes=100-"}, http.StatusRequestedRangeNotSatisfiable, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/items/1/image", nil)
+		for k, v := range tt.header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		if err := h.serveImage(echo.New().NewContext(req, rec), image); err != nil {
+			t.Fatalf("%s: serveImage() error = %v", tt.name, err)
+		}
+
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.wantCode)
+		}
+		if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+			t.Errorf("%s: body = %q, want %q", tt.name, rec.Body.String(), tt.wantBody)
+		}
+		if got := rec.Header().Get("ETag"); got != image.etag {
+			t.Errorf("%s: ETag = %q, want %q", tt.name, got, image.etag)
+		}
+		if got := rec.Header().Get(echo.HeaderCacheControl); got != "public, max-age=60" {
+			t.Errorf("%s: Cache-Control = %q", tt.name, got)
+		}
+		if tt.wantCode == http.StatusOK {
+			if got := rec.Header().Get(echo.HeaderContentType); got != "image/png" {
+				t.Errorf("%s: Content-Type = %q, want image/png", tt.name, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+	"github.com/labstack/echo/v4"
+)
+
+// maxImportRows is how many items one import can create
+const maxImportRows = 1000
+
+type importItemsResponse struct {
+	ID int64 `json:"id"`
+}
+
+type getImportJobResponse struct {
+	ID        int64               `json:"id"`
+	Status    domain.ImportStatus `json:"status"`
+	Total     int                 `json:"total"`
+	Processed int                 `json:"processed"`
+	Error     string              `json:"error,omitempty"`
+	Rows      []importRowResponse `json:"rows"`
+	CreatedAt string              `json:"created_at"`
+	UpdatedAt string              `json:"updated_at"`
+}
+
+type importRowResponse struct {
+	Row    int    `json:"row"`
+	ItemID int32  `json:"item_id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// importRow is an item of the manifest
+type importRow struct {
+	Name        string `json:"name"`
+	Price       int64  `json:"price"`
+	Description string `json:"description"`
+	CategoryID  int64  `json:"category_id"`
+	// Images are file names in the archive, the first one is the cover
+	Images []string `json:"images"`
+	// invalid is set if a CSV row cannot be read
+	invalid string
+}
+
+// ImportItems starts to create drafts from the "manifest" file, in CSV or JSON, with the images of the "images" zip file.
+// Every row is checked first, and either every item is created in one transaction or none is and the rows with errors are reported.
+// The progress is polled with GetImportJob.
+func (h *Handler) ImportItems(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	manifest, err := c.FormFile("manifest")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	rows, err := readManifest(manifest)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid manifest: %s", err))
+	}
+	if len(rows) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "The manifest has no rows.")
+	}
+	if len(rows) > maxImportRows {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("A manifest can have up to %d rows.", maxImportRows))
+	}
+
+	images, err := c.FormFile("images")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	archive, err := readArchive(images)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid images archive: %s", err))
+	}
+
+	jobID, err := h.ImportRepo.AddImportJob(ctx, userID, len(rows))
+	if err != nil {
+		archive.Close()
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	// the job outlives the request
+	go h.runImport(context.Background(), jobID, userID, rows, archive)
+
+	return c.JSON(http.StatusAccepted, importItemsResponse{ID: jobID})
+}
+
+// GetImportJob returns the progress of an import, and the result of every row once it finished
+func (h *Handler) GetImportJob(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	jobID, err := strconv.ParseInt(c.Param("jobID"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid jobID")
+	}
+
+	job, err := h.ImportRepo.GetImportJob(ctx, jobID)
+	if err != nil {
+		// not found handling
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	// other users' jobs do not exist for the user
+	if job.UserID != userID && !adminUserIDs[userID] {
+		return echo.NewHTTPError(http.StatusNotFound, "No import job found.")
+	}
+
+	res := getImportJobResponse{
+		ID:        job.ID,
+		Status:    job.Status,
+		Total:     job.Total,
+		Processed: job.Processed,
+		Error:     job.Error,
+		Rows:      make([]importRowResponse, len(job.Rows)),
+		CreatedAt: job.CreatedAt,
+		UpdatedAt: job.UpdatedAt,
+	}
+	for i, ir := range job.Rows {
+		res.Rows[i] = importRowResponse{Row: ir.Row, ItemID: ir.ItemID, Error: ir.Error}
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// runImport waits for a slot of h.Imports, and owns the archive from then on.
+// A panic fails the job instead of taking the server down, and the job would be left running otherwise.
+func (h *Handler) runImport(ctx context.Context, jobID int64, userID int64, rows []importRow, archive *importArchive) {
+	defer archive.Close()
+	if h.Imports != nil {
+		h.Imports <- struct{}{}
+		defer func() { <-h.Imports }()
+	}
+
+	job := domain.ImportJob{ID: jobID, Status: domain.ImportStatusSucceeded, Processed: len(rows)}
+
+	// the stored images of every row, deleted unless the items are created
+	keys := make([][]string, len(rows))
+	var stored []string
+	var processed int
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("import %d: panic: %v", jobID, r)
+			h.deleteStoredImages(ctx, stored...)
+			job.Rows = nil
+			h.failImport(ctx, job, processed, "The import failed on the server.")
+		}
+	}()
+	for i, row := range rows {
+		// once a row is invalid no item is created, the other rows are only checked
+		store := len(job.Rows) == 0
+		rowKeys, invalid, err := h.checkImportRow(ctx, row, archive.files, store)
+		keys[i] = rowKeys
+		stored = append(stored, rowKeys...)
+		if err != nil {
+			h.deleteStoredImages(ctx, stored...)
+			h.failImport(ctx, job, i, err.Error())
+			return
+		}
+		if invalid != "" {
+			job.Rows = append(job.Rows, domain.ImportRow{Row: i + 1, Error: invalid})
+		}
+		processed = i + 1
+		if err := h.ImportRepo.SetImportProgress(ctx, jobID, processed); err != nil {
+			log.Printf("import %d: failed to save the progress: %s", jobID, err)
+		}
+	}
+	if len(job.Rows) > 0 {
+		h.deleteStoredImages(ctx, stored...)
+		h.failImport(ctx, job, len(rows), fmt.Sprintf("%d of %d rows are invalid, no item was created.", len(job.Rows), len(rows)))
+		return
+	}
+
+	if err := h.createImportedItems(ctx, job, userID, rows, keys); err != nil {
+		h.deleteStoredImages(ctx, stored...)
+		h.failImport(ctx, job, len(rows), err.Error())
+	}
+}
+
+// checkImportRow checks the row and, if store is set, puts its images in the ImageStore.
+// invalid tells what is wrong with the row, and err is a failure of the server. The returned keys have to be deleted if the item is not created.
+func (h *Handler) checkImportRow(ctx context.Context, row importRow, archive map[string]*zip.File, store bool) (keys []string, invalid string, err error) {
+	switch {
+	case row.invalid != "":
+		return nil, row.invalid, nil
+	case row.Name == "":
+		return nil, "Name is required.", nil
+	case row.Price <= 0:
+		return nil, "Price must be greater than 0.", nil
+	case len(row.Images) == 0 || len(row.Images) > h.MaxItemImages:
+		return nil, fmt.Sprintf("An item needs 1 to %d images.", h.MaxItemImages), nil
+	}
+	if _, err := h.ItemRepo.GetCategory(ctx, row.CategoryID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, "invalid categoryID", nil
+		}
+		return nil, "", err
+	}
+
+	for _, name := range row.Images {
+		file, ok := archive[path.Clean(name)]
+		if !ok {
+			return keys, fmt.Sprintf("Image %s is not in the archive.", name), nil
+		}
+		src, err := file.Open()
+		if err != nil {
+			return keys, fmt.Sprintf("Image %s: %s", name, err), nil
+		}
+		// read one byte over the limit so that the Pipeline rejects larger files without reading them whole
+		data, err := io.ReadAll(io.LimitReader(src, h.Pipeline.MaxBytes+1))
+		src.Close()
+		if err != nil {
+			return keys, fmt.Sprintf("Image %s: %s", name, err), nil
+		}
+		image, err := h.Pipeline.Process(data)
+		if err != nil {
+			return keys, fmt.Sprintf("Image %s: %s", name, err), nil
+		}
+		if !store {
+			continue
+		}
+		key, err := h.storeImage(ctx, image)
+		if err != nil {
+			return keys, "", err
+		}
+		keys = append(keys, key)
+	}
+	return keys, "", nil
+}
+
+func (h *Handler) createImportedItems(ctx context.Context, job domain.ImportJob, userID int64, rows []importRow, keys [][]string) error {
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, row := range rows {
+		itemID, err := h.ItemRepo.AddItemTx(tx, ctx, domain.Item{
+			Name:        row.Name,
+			CategoryID:  row.CategoryID,
+			UserID:      userID,
+			Price:       row.Price,
+			Description: row.Description,
+			ImageKey:    keys[i][0],
+			Status:      domain.ItemStatusInitial,
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range keys[i][1:] {
+			if _, err := h.ImageRepo.AddImageTx(tx, ctx, itemID, key); err != nil {
+				return err
+			}
+		}
+		job.Rows = append(job.Rows, domain.ImportRow{Row: i + 1, ItemID: itemID})
+	}
+	if err := h.ImportRepo.FinishImportJobTx(tx, ctx, job); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// failImport saves the job as failed with its invalid rows
+func (h *Handler) failImport(ctx context.Context, job domain.ImportJob, processed int, reason string) {
+	job.Status = domain.ImportStatusFailed
+	job.Processed = processed
+	job.Error = reason
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err == nil {
+		defer tx.Rollback()
+		if err = h.ImportRepo.FinishImportJobTx(tx, ctx, job); err == nil {
+			err = tx.Commit()
+		}
+	}
+	if err != nil {
+		log.Printf("import %d: failed to save the failure %q: %s", job.ID, reason, err)
+	}
+}
+
+// readManifest reads a JSON array of rows, or a CSV file with a header of name, price, description, category_id and images.
+// In CSV, the images of a row are separated by semicolons.
+func readManifest(file *multipart.FileHeader) ([]importRow, error) {
+	src, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasSuffix(strings.ToLower(file.Filename), ".json") || bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		var rows []importRow
+		if err := json.Unmarshal(data, &rows); err != nil {
+			return nil, err
+		}
+		return rows, nil
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"name", "price", "category_id", "images"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("column %s is missing", name)
+		}
+	}
+	value := func(record []string, name string) string {
+		if i, ok := columns[name]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	rows := make([]importRow, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := importRow{Name: value(record, "name"), Description: value(record, "description")}
+		if row.Price, err = strconv.ParseInt(value(record, "price"), 10, 64); err != nil {
+			row.invalid = "Price must be a number."
+		}
+		if row.CategoryID, err = strconv.ParseInt(value(record, "category_id"), 10, 64); err != nil {
+			row.invalid = "invalid categoryID"
+		}
+		for _, name := range strings.Split(value(record, "images"), ";") {
+			if name = strings.TrimSpace(name); name != "" {
+				row.Images = append(row.Images, name)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// importArchive is the images zip of an import, copied to a temporary file since the upload is removed after the request
+type importArchive struct {
+	file *os.File
+	// files are the files of the zip by their names
+	files map[string]*zip.File
+}
+
+// Close removes the temporary file
+func (a *importArchive) Close() error {
+	a.file.Close()
+	return os.Remove(a.file.Name())
+}
+
+func readArchive(file *multipart.FileHeader) (*importArchive, error) {
+	src, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp("", "import-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	archive := &importArchive{file: tmp}
+	size, err := io.Copy(tmp, src)
+	if err != nil {
+		archive.Close()
+		return nil, err
+	}
+
+	r, err := zip.NewReader(tmp, size)
+	if err != nil {
+		archive.Close()
+		return nil, err
+	}
+	archive.files = make(map[string]*zip.File, len(r.File))
+	for _, f := range r.File {
+		if !f.FileInfo().IsDir() {
+			archive.files[path.Clean(f.Name)] = f
+		}
+	}
+	return archive, nil
+}
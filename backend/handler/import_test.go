@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/imageproc"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/imagestore"
+)
+
+// panicStore panics on Put, like a bug in the store would
+type panicStore struct {
+	*imagestore.MemoryStore
+}
+
+func (s panicStore) Put(ctx context.Context, key string, data []byte) error {
+	panic("put")
+}
+
+// newTestImport returns a job with a row of the image, and its archive
+func newTestImport(t *testing.T, h *Handler) (int64, int64, []importRow, *importArchive) {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := h.DB.Exec("INSERT INTO category (id, name) VALUES (1, 'category')"); err != nil {
+		t.Fatal(err)
+	}
+	userID := addTestUser(t, h, 0)
+	rows := []importRow{{Name: "item", Price: 1000, CategoryID: 1, Images: []string{"item.png"}}}
+	jobID, err := h.ImportRepo.AddImportJob(ctx, userID, len(rows))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	w, err := zw.Create("item.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(img.Bytes())
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("images", "images.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(zipped.Bytes())
+	mw.Close()
+	req := httptest.NewRequest("POST", "/items/import", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if err := req.ParseMultipartForm(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := readArchive(req.MultipartForm.File["images"][0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return userID, jobID, rows, archive
+}
+
+func TestRunImportPanic(t *testing.T) {
+	ctx := context.Background()
+	h := newTestHandler(t)
+	h.Images = panicStore{imagestore.NewMemoryStore()}
+	h.Pipeline = imageproc.NewPipeline()
+	h.MaxItemImages = 1
+	userID, jobID, rows, archive := newTestImport(t, h)
+
+	h.runImport(ctx, jobID, userID, rows, archive)
+
+	job, err := h.ImportRepo.GetImportJob(ctx, jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != domain.ImportStatusFailed {
+		t.Errorf("status = %v, want %v", job.Status, domain.ImportStatusFailed)
+	}
+	if _, err := os.Stat(archive.file.Name()); !os.IsNotExist(err) {
+		t.Errorf("archive file is left: %v", err)
+	}
+}
+
+func TestRunImportWaits(t *testing.T) {
+	ctx := context.Background()
+	h := newTestHandler(t)
+	h.Images = imagestore.NewMemoryStore()
+	h.Pipeline = imageproc.NewPipeline()
+	h.MaxItemImages = 1
+	h.Imports = make(chan struct{}, 1)
+	userID, jobID, rows, archive := newTestImport(t, h)
+
+	// another import is running
+	h.Imports <- struct{}{}
+	done := make(chan struct{})
+	go func() {
+		h.runImport(ctx, jobID, userID, rows, archive)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("import ran while another one was running")
+	case <-time.After(100 * time.Millisecond):
+	}
+	<-h.Imports
+	<-done
+
+	job, err := h.ImportRepo.GetImportJob(ctx, jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != domain.ImportStatusSucceeded {
+		t.Errorf("status = %v, error %q, want %v", job.Status, job.Error, domain.ImportStatusSucceeded)
+	}
+	if len(h.Imports) != 0 {
+		t.Errorf("slots taken = %d, want 0", len(h.Imports))
+	}
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// LikeItem adds the item to the likes of the user, which the most_liked sort counts. Liking it again does nothing.
+func (h *Handler) LikeItem(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	itemID, err := getItemID(c)
+	if err != nil {
+		return err
+	}
+
+	item, err := h.ItemRepo.GetItem(ctx, itemID)
+	if err != nil {
+		// not found handling
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if item.UserID == userID {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "Cannot like your own item.")
+	}
+
+	if err := h.LikeRepo.AddLike(ctx, userID, itemID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, "successful")
+}
+
+// UnlikeItem removes the item from the likes of the user, also if it was deleted since
+func (h *Handler) UnlikeItem(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	itemID, err := getItemID(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.LikeRepo.DeleteLike(ctx, userID, itemID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, "successful")
+}
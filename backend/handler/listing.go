@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+	"github.com/labstack/echo/v4"
+)
+
+// PauseItem hides an item on sale from buyers until it is relisted
+func (h *Handler) PauseItem(c echo.Context) error {
+	return h.updateListing(c, domain.ItemStatusPaused)
+}
+
+// RelistItem puts a paused item back on sale
+func (h *Handler) RelistItem(c echo.Context) error {
+	return h.updateListing(c, domain.ItemStatusOnSale)
+}
+
+// WithdrawItem takes the listing down for good
+func (h *Handler) WithdrawItem(c echo.Context) error {
+	return h.updateListing(c, domain.ItemStatusCancelled)
+}
+
+func (h *Handler) updateListing(c echo.Context, status domain.ItemStatus) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	itemID, err := getItemID(c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer tx.Rollback()
+
+	item, err := h.ItemRepo.GetItemTx(tx, ctx, itemID)
+	if err != nil {
+		// not found handling
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusPreconditionFailed, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if item.UserID != userID {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "You can only change your own items.")
+	}
+	// drafts are put on sale with /sell and refunded items go back on sale by themselves
+	if status == domain.ItemStatusOnSale && item.Status != domain.ItemStatusPaused {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "Only paused items can be relisted.")
+	}
+
+	if err := h.ItemRepo.UpdateItemStatusTx(tx, ctx, itemID, item.Status, status); err != nil {
+		return itemStatusError(err)
+	}
+	if status == domain.ItemStatusCancelled {
+		if err := h.OfferRepo.CloseOffersTx(tx, ctx, itemID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	// status changed, delete from cache
+	CA.Delete(fmt.Sprintf(itemKey, itemID))
+
+	return c.JSON(http.StatusOK, "successful")
+}
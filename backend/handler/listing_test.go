@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+	"github.com/labstack/echo/v4"
+)
+
+func TestUpdateListing(t *testing.T) {
+	ctx := context.Background()
+	h := newTestHandler(t)
+	sellerID := addTestUser(t, h, 0)
+	otherID := addTestUser(t, h, 0)
+	itemID := addTestItem(t, h, sellerID, 1000)
+	item := fmt.Sprint(itemID)
+
+	// the steps run in order on the same item, and a failed step leaves its status as it was
+	steps := []struct {
+		name     string
+		userID   int64
+		update   func(echo.Context) error
+		wantCode int
+		want     domain.ItemStatus
+	}{
+		{"relist on sale", sellerID, h.RelistItem, http.StatusPreconditionFailed, domain.ItemStatusOnSale},
+		{"pause of another user", otherID, h.PauseItem, http.StatusPreconditionFailed, domain.ItemStatusOnSale},
+		{"pause", sellerID, h.PauseItem, http.StatusOK, domain.ItemStatusPaused},
+		{"pause paused", sellerID, h.PauseItem, http.StatusPreconditionFailed, domain.ItemStatusPaused},
+		{"relist", sellerID, h.RelistItem, http.StatusOK, domain.ItemStatusOnSale},
+		{"withdraw", sellerID, h.WithdrawItem, http.StatusOK, domain.ItemStatusCancelled},
+		{"pause withdrawn", sellerID, h.PauseItem, http.StatusPreconditionFailed, domain.ItemStatusCancelled},
+		{"relist withdrawn", sellerID, h.RelistItem, http.StatusPreconditionFailed, domain.ItemStatusCancelled},
+		{"withdraw withdrawn", sellerID, h.WithdrawItem, http.StatusPreconditionFailed, domain.ItemStatusCancelled},
+	}
+	for _, step := range steps {
+		c, _ := newTestContext(step.userID, http.MethodPost, "/items/"+item, "", "itemID", item)
+		code := http.StatusOK
+		if err := step.update(c); err != nil {
+			var httpErr *echo.HTTPError
+			if !errors.As(err, &httpErr) {
+				t.Fatalf("%s: error = %v", step.name, err)
+			}
+			code = httpErr.Code
+		}
+		if code != step.wantCode {
+			t.Errorf("%s: status = %d, want %d", step.name, code, step.wantCode)
+		}
+
+		got, err := h.ItemRepo.GetItem(ctx, itemID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != step.want {
+			t.Errorf("%s: item status = %v, want %v", step.name, got.Status, step.want)
+		}
+	}
+}
+
+func TestWithdrawClosesOffers(t *testing.T) {
+	ctx := context.Background()
+	h := newTestHandler(t)
+	sellerID := addTestUser(t, h, 0)
+	buyerID := addTestUser(t, h, 0)
+	itemID := addTestItem(t, h, sellerID, 1000)
+	item := fmt.Sprint(itemID)
+
+	c, _ := newTestContext(buyerID, http.MethodPost, "/items/"+item+"/offers", `{"price": 800}`, "itemID", item)
+	if err := h.AddOffer(c); err != nil {
+		t.Fatal(err)
+	}
+	c, _ = newTestContext(sellerID, http.MethodPost, "/items/"+item+"/withdraw", "", "itemID", item)
+	if err := h.WithdrawItem(c); err != nil {
+		t.Fatal(err)
+	}
+
+	offers, err := h.OfferRepo.GetOffersByItemID(ctx, itemID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offers) != 1 || offers[0].Status != domain.OfferStatusClosed {
+		t.Errorf("offers = %+v, want one closed offer", offers)
+	}
+}
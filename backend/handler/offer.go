@@ -0,0 +1,441 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/db"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+	"github.com/labstack/echo/v4"
+)
+
+type addOfferRequest struct {
+	Price int64 `json:"price"`
+}
+
+type addOfferResponse struct {
+	ID int64 `json:"id"`
+}
+
+type counterOfferRequest struct {
+	Price int64 `json:"price"`
+}
+
+type getOfferResponse struct {
+	ID           int64              `json:"id"`
+	ItemID       int32              `json:"item_id"`
+	BuyerID      int64              `json:"buyer_id"`
+	SellerID     int64              `json:"seller_id"`
+	Price        int64              `json:"price"`
+	CounterPrice int64              `json:"counter_price,omitempty"`
+	Status       domain.OfferStatus `json:"status"`
+	ExpiresAt    string             `json:"expires_at"`
+	CreatedAt    string             `json:"created_at"`
+}
+
+func toOfferResponses(offers []domain.Offer) []getOfferResponse {
+	now := time.Now().Format(db.TimeLayout)
+	res := make([]getOfferResponse, len(offers))
+	for i, o := range offers {
+		// the expire job may not have run yet
+		if o.Expired(now) {
+			o.Status = domain.OfferStatusExpired
+		}
+		res[i] = getOfferResponse{
+			ID:           o.ID,
+			ItemID:       o.ItemID,
+			BuyerID:      o.BuyerID,
+			SellerID:     o.SellerID,
+			Price:        o.Price,
+			CounterPrice: o.CounterPrice,
+			Status:       o.Status,
+			ExpiresAt:    o.ExpiresAt,
+			CreatedAt:    o.CreatedAt,
+		}
+	}
+	return res
+}
+
+// AddOffer is called by a buyer to ask for a lower price than the listed one
+func (h *Handler) AddOffer(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	req := new(addOfferRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if req.Price <= 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Price must be greater than 0.")
+	}
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	itemID, err := getItemID(c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer tx.Rollback()
+
+	item, err := h.ItemRepo.GetItemTx(tx, ctx, itemID)
+	if err != nil {
+		// not found handling
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusPreconditionFailed, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if item.Status != domain.ItemStatusOnSale {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "This item is not on sale.")
+	}
+	if item.UserID == userID {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "Cannot make an offer on your own item.")
+	}
+	if req.Price >= item.Price {
+		return echo.NewHTTPError(http.StatusBadRequest, "Offer must be lower than the price.")
+	}
+
+	// one open offer per buyer and item
+	now := time.Now()
+	if _, err := h.OfferRepo.GetOpenOfferTx(tx, ctx, itemID, userID, now.Format(db.TimeLayout)); err != sql.ErrNoRows {
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "You already have an open offer on this item.")
+	}
+
+	id, err := h.OfferRepo.AddOfferTx(tx, ctx, domain.Offer{
+		ItemID:    itemID,
+		BuyerID:   userID,
+		SellerID:  item.UserID,
+		Price:     req.Price,
+		ExpiresAt: now.Add(h.OfferTTL).Format(db.TimeLayout),
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, addOfferResponse{ID: id})
+}
+
+// GetItemOffers returns all offers on the item to the seller, and only their own offers to buyers
+func (h *Handler) GetItemOffers(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	itemID, err := getItemID(c)
+	if err != nil {
+		return err
+	}
+
+	offers, err := h.OfferRepo.GetOffersByItemID(ctx, itemID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	var visible []domain.Offer
+	for _, o := range offers {
+		if o.SellerID == userID || o.BuyerID == userID {
+			visible = append(visible, o)
+		}
+	}
+	return c.JSON(http.StatusOK, toOfferResponses(visible))
+}
+
+// GetOffers returns the offers made by the login user
+func (h *Handler) GetOffers(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	offers, err := h.OfferRepo.GetOffersByBuyerID(ctx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, toOfferResponses(offers))
+}
+
+// AcceptOffer is called by the seller on a pending offer, or by the buyer on a countered one
+func (h *Handler) AcceptOffer(c echo.Context) error {
+	return h.answerOffer(c, domain.OfferStatusAccepted)
+}
+
+// RejectOffer is called by the seller on a pending offer, or by the buyer on a countered one
+func (h *Handler) RejectOffer(c echo.Context) error {
+	return h.answerOffer(c, domain.OfferStatusRejected)
+}
+
+func (h *Handler) answerOffer(c echo.Context, status domain.OfferStatus) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer tx.Rollback()
+
+	o, err := h.getOpenOfferTx(tx, ctx, c)
+	if err != nil {
+		return err
+	}
+	// the party who did not make the last price answers
+	if (o.Status == domain.OfferStatusPending && userID != o.SellerID) || (o.Status == domain.OfferStatusCountered && userID != o.BuyerID) {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "You cannot answer this offer.")
+	}
+	if !o.Status.CanTransitionTo(status) {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "This offer cannot be answered.")
+	}
+
+	from := o.Status
+	o.Status = status
+	if status == domain.OfferStatusAccepted {
+		if err := h.checkOfferItemTx(tx, ctx, o); err != nil {
+			return err
+		}
+		// the item is held for the buyer, who has OfferTTL to purchase
+		if err := h.ItemRepo.UpdateItemStatusTx(tx, ctx, o.ItemID, domain.ItemStatusOnSale, domain.ItemStatusReserved); err != nil {
+			return itemStatusError(err)
+		}
+		o.ExpiresAt = time.Now().Add(h.OfferTTL).Format(db.TimeLayout)
+	}
+	if err := h.OfferRepo.UpdateOfferTx(tx, ctx, o, from); err != nil {
+		if err == db.ErrConflict {
+			return echo.NewHTTPError(http.StatusConflict, "The offer was changed by another request.")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	// status changed, delete from cache
+	CA.Delete(fmt.Sprintf(itemKey, o.ItemID))
+
+	return c.JSON(http.StatusOK, "successful")
+}
+
+// CounterOffer is called by the seller to propose a price between the offer and the listed price
+func (h *Handler) CounterOffer(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	req := new(counterOfferRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer tx.Rollback()
+
+	o, err := h.getOpenOfferTx(tx, ctx, c)
+	if err != nil {
+		return err
+	}
+	if userID != o.SellerID {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "Only the seller can counter an offer.")
+	}
+	if !o.Status.CanTransitionTo(domain.OfferStatusCountered) {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "This offer cannot be countered.")
+	}
+
+	item, err := h.ItemRepo.GetItemTx(tx, ctx, o.ItemID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if req.Price <= o.Price || req.Price >= item.Price {
+		return echo.NewHTTPError(http.StatusBadRequest, "Counter price must be between the offer and the price.")
+	}
+	if err := h.checkOfferItemTx(tx, ctx, o); err != nil {
+		return err
+	}
+
+	from := o.Status
+	o.Status = domain.OfferStatusCountered
+	o.CounterPrice = req.Price
+	o.ExpiresAt = time.Now().Add(h.OfferTTL).Format(db.TimeLayout)
+	if err := h.OfferRepo.UpdateOfferTx(tx, ctx, o, from); err != nil {
+		if err == db.ErrConflict {
+			return echo.NewHTTPError(http.StatusConflict, "The offer was changed by another request.")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, "successful")
+}
+
+// WithdrawOffer is called by the buyer
+func (h *Handler) WithdrawOffer(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer tx.Rollback()
+
+	o, err := h.getOpenOfferTx(tx, ctx, c)
+	if err != nil {
+		return err
+	}
+	if userID != o.BuyerID {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "You can only withdraw your own offers.")
+	}
+
+	from := o.Status
+	o.Status = domain.OfferStatusWithdrawn
+	if err := h.OfferRepo.UpdateOfferTx(tx, ctx, o, from); err != nil {
+		if err == db.ErrConflict {
+			return echo.NewHTTPError(http.StatusConflict, "The offer was changed by another request.")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if from == domain.OfferStatusAccepted {
+		if err := h.releaseItemTx(tx, ctx, o.ItemID); err != nil {
+			return itemStatusError(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	// status changed, delete from cache
+	CA.Delete(fmt.Sprintf(itemKey, o.ItemID))
+
+	return c.JSON(http.StatusOK, "successful")
+}
+
+// getOpenOfferTx returns the offer of the offerID param if it can still change
+func (h *Handler) getOpenOfferTx(tx *sql.Tx, ctx context.Context, c echo.Context) (domain.Offer, error) {
+	offerID, err := strconv.ParseInt(c.Param("offerID"), 10, 64)
+	if err != nil {
+		return domain.Offer{}, echo.NewHTTPError(http.StatusBadRequest, "invalid offerID")
+	}
+
+	o, err := h.OfferRepo.GetOfferTx(tx, ctx, offerID)
+	if err != nil {
+		// not found handling
+		if err == sql.ErrNoRows {
+			return o, echo.NewHTTPError(http.StatusNotFound, "Offer not found.")
+		}
+		return o, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	userID, err := getUserID(c)
+	if err != nil {
+		return o, echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	if userID != o.BuyerID && userID != o.SellerID {
+		return o, echo.NewHTTPError(http.StatusNotFound, "Offer not found.")
+	}
+	if o.Expired(time.Now().Format(db.TimeLayout)) {
+		return o, echo.NewHTTPError(http.StatusPreconditionFailed, "This offer has expired.")
+	}
+	if !o.Status.CanTransitionTo(domain.OfferStatusWithdrawn) {
+		return o, echo.NewHTTPError(http.StatusPreconditionFailed, "This offer is already settled.")
+	}
+	return o, nil
+}
+
+// checkOfferItemTx fails unless the item of the offer can still be sold
+func (h *Handler) checkOfferItemTx(tx *sql.Tx, ctx context.Context, o domain.Offer) error {
+	item, err := h.ItemRepo.GetItemTx(tx, ctx, o.ItemID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if item.Status != domain.ItemStatusOnSale {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "This item is not on sale.")
+	}
+	return nil
+}
+
+// releaseItemTx puts the item reserved for an accepted offer back on sale
+func (h *Handler) releaseItemTx(tx *sql.Tx, ctx context.Context, itemID int32) error {
+	item, err := h.ItemRepo.GetItemWithDeletedTx(tx, ctx, itemID)
+	if err != nil {
+		return err
+	}
+	// offers accepted before items were reserved left them on sale
+	if item.Status != domain.ItemStatusReserved {
+		return nil
+	}
+	return h.ItemRepo.UpdateItemStatusTx(tx, ctx, itemID, domain.ItemStatusReserved, domain.ItemStatusOnSale)
+}
+
+// ExpireOffers marks the offers which ran out of time as expired, and puts the items reserved for them back on sale
+func (h *Handler) ExpireOffers(ctx context.Context) error {
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	offers, err := h.OfferRepo.ExpireOffersTx(tx, ctx, time.Now().Format(db.TimeLayout))
+	if err != nil {
+		return err
+	}
+	var released []int32
+	for _, o := range offers {
+		if o.Status != domain.OfferStatusAccepted {
+			continue
+		}
+		if err := h.releaseItemTx(tx, ctx, o.ItemID); err != nil {
+			return err
+		}
+		released = append(released, o.ItemID)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, itemID := range released {
+		// status changed, delete from cache
+		CA.Delete(fmt.Sprintf(itemKey, itemID))
+	}
+	if len(offers) != 0 {
+		log.Printf("offers expired: %v", len(offers))
+	}
+	return nil
+}
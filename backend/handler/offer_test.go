@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+	"github.com/labstack/echo/v4"
+)
+
+func TestAcceptedOfferReservesItem(t *testing.T) {
+	tests := []struct {
+		name string
+		// end ends the accepted offer
+		end func(t *testing.T, h *Handler, buyerID int64, offerID string)
+	}{
+		{
+			name: "withdrawn",
+			end: func(t *testing.T, h *Handler, buyerID int64, offerID string) {
+				c, _ := newTestContext(buyerID, http.MethodPost, "/offers/"+offerID+"/withdraw", "", "offerID", offerID)
+				if err := h.WithdrawOffer(c); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "expired",
+			end: func(t *testing.T, h *Handler, buyerID int64, offerID string) {
+				if _, err := h.DB.Exec("UPDATE offers SET expires_at = '2000-01-01 00:00:00' WHERE id = ?", offerID); err != nil {
+					t.Fatal(err)
+				}
+				if err := h.ExpireOffers(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			h := newTestHandler(t)
+			sellerID := addTestUser(t, h, 0)
+			buyerID := addTestUser(t, h, 0)
+			otherID := addTestUser(t, h, 0)
+			itemID := addTestItem(t, h, sellerID, 1000)
+			item := fmt.Sprint(itemID)
+
+			var offerIDs []string
+			for _, userID := range []int64{buyerID, otherID} {
+				c, rec := newTestContext(userID, http.MethodPost, "/items/"+item+"/offers", `{"price": 800}`, "itemID", item)
+				if err := h.AddOffer(c); err != nil {
+					t.Fatal(err)
+				}
+				var res addOfferResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+					t.Fatal(err)
+				}
+				offerIDs = append(offerIDs, fmt.Sprint(res.ID))
+			}
+
+			c, _ := newTestContext(sellerID, http.MethodPost, "/offers/"+offerIDs[0]+"/accept", "", "offerID", offerIDs[0])
+			if err := h.AcceptOffer(c); err != nil {
+				t.Fatal(err)
+			}
+			got, err := h.ItemRepo.GetItem(ctx, itemID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != domain.ItemStatusReserved {
+				t.Fatalf("status after accept = %v, want %v", got.Status, domain.ItemStatusReserved)
+			}
+
+			// the seller cannot accept another offer for the reserved item
+			c, _ = newTestContext(sellerID, http.MethodPost, "/offers/"+offerIDs[1]+"/accept", "", "offerID", offerIDs[1])
+			var httpErr *echo.HTTPError
+			if err := h.AcceptOffer(c); !errors.As(err, &httpErr) || httpErr.Code != http.StatusPreconditionFailed {
+				t.Fatalf("AcceptOffer() of another offer error = %v, want 412", err)
+			}
+
+			tt.end(t, h, buyerID, offerIDs[0])
+			got, err = h.ItemRepo.GetItem(ctx, itemID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != domain.ItemStatusOnSale {
+				t.Errorf("status after the offer ended = %v, want %v", got.Status, domain.ItemStatusOnSale)
+			}
+		})
+	}
+}
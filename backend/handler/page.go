@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/db"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// defaultPageSize is the limit of a page asked for without one
+	defaultPageSize = 30
+	maxPageSize     = 100
+)
+
+// pageResponse is the response of the lists of PagedList. NextCursor is empty on the last page.
+type pageResponse struct {
+	Items      any    `json:"items"`
+	NextCursor string `json:"next_cursor"`
+	// Facets are only returned by /v2/search-detail when asked for
+	Facets *facetsResponse `json:"facets,omitempty"`
+}
+
+// cursorPayload is what the opaque cursors of pages encode. A cursor only continues the sort it was made for.
+type cursorPayload struct {
+	Sort db.ItemSort `json:"s"`
+	Key  any         `json:"k"`
+	ID   int32       `json:"i"`
+}
+
+func encodeCursor(sort db.ItemSort, cursor *db.ItemCursor) (string, error) {
+	if cursor == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(cursorPayload{Sort: sort, Key: cursor.Key, ID: cursor.ID})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(sort db.ItemSort, s string) (*db.ItemCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	// numbers are kept as they are, integer keys must not become floats
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var p cursorPayload
+	if err := dec.Decode(&p); err != nil {
+		return nil, err
+	}
+	if p.Sort != sort || p.ID == 0 {
+		return nil, fmt.Errorf("cursor of another list")
+	}
+	cursor := &db.ItemCursor{Key: p.Key, ID: p.ID}
+	switch key := p.Key.(type) {
+	case json.Number:
+		if i, err := key.Int64(); err == nil {
+			cursor.Key = i
+		} else if cursor.Key, err = key.Float64(); err != nil {
+			return nil, err
+		}
+	case string:
+	default:
+		return nil, fmt.Errorf("invalid cursor key %v", key)
+	}
+	return cursor, nil
+}
+
+// itemSorts are the values of the sort parameter
+var itemSorts = map[string]db.ItemSort{
+	"newest":      db.ItemSortNewest,
+	"price_asc":   db.ItemSortPriceAsc,
+	"price_desc":  db.ItemSortPriceDesc,
+	"most_viewed": db.ItemSortViews,
+	"most_liked":  db.ItemSortLikes,
+}
+
+// pagedListKey marks the requests of the lists PagedList serves
+const pagedListKey = "pagedList"
+
+// PagedList serves the v2 of a list endpoint, which is always paged and always responds with a pageResponse.
+// The v1 endpoints respond with every item in an array, as they did before pages.
+func PagedList(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Set(pagedListKey, true)
+		return next(c)
+	}
+}
+
+// readList sets the sort, and for the lists of PagedList the limit and the cursor of the query from the sort, limit and cursor parameters.
+// Without sort the query keeps its order, and without limit a page has defaultPageSize items. paged reports whether the list is paged.
+func readList(c echo.Context, query *db.ItemQuery) (paged bool, err error) {
+	if name := c.QueryParam("sort"); name != "" {
+		sort, ok := itemSorts[name]
+		if !ok {
+			return false, echo.NewHTTPError(http.StatusBadRequest, "Sort must be newest, price_asc, price_desc, most_viewed or most_liked.")
+		}
+		query.Sort = sort
+	}
+
+	limit, cursor := c.QueryParam("limit"), c.QueryParam("cursor")
+	if paged, _ := c.Get(pagedListKey).(bool); !paged {
+		if limit != "" || cursor != "" {
+			return false, echo.NewHTTPError(http.StatusBadRequest, "Pages are returned by the /v2 lists.")
+		}
+		return false, nil
+	}
+
+	query.Limit = defaultPageSize
+	if limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxPageSize {
+			return true, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Limit must be between 1 and %d.", maxPageSize))
+		}
+		query.Limit = n
+	}
+	if cursor != "" {
+		after, err := decodeCursor(query.Sort, cursor)
+		if err != nil {
+			return true, echo.NewHTTPError(http.StatusBadRequest, "Invalid cursor.")
+		}
+		query.After = after
+	}
+	return true, nil
+}
+
+// pageJSON responds with the items in a pageResponse if the list is paged, or as they are otherwise
+func pageJSON(c echo.Context, paged bool, items any, sort db.ItemSort, next *db.ItemCursor) error {
+	if !paged {
+		return c.JSON(http.StatusOK, items)
+	}
+	cursor, err := encodeCursor(sort, next)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, pageResponse{Items: items, NextCursor: cursor})
+}
@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/db"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+	"github.com/labstack/echo/v4"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		sort   db.ItemSort
+		cursor *db.ItemCursor
+	}{
+		{db.ItemSortPriceAsc, &db.ItemCursor{Key: int64(1000), ID: 3}},
+		// over the integers a float64 keeps
+		{db.ItemSortPriceDesc, &db.ItemCursor{Key: int64(1<<53 + 1), ID: 4}},
+		{db.ItemSortRelevance, &db.ItemCursor{Key: -1.25, ID: 5}},
+		{db.ItemSortNewest, &db.ItemCursor{Key: "2023-06-01 10:00:00", ID: 6}},
+	} {
+		s, err := encodeCursor(tt.sort, tt.cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := decodeCursor(tt.sort, s)
+		if err != nil {
+			t.Errorf("decodeCursor(%q) error = %v", s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.cursor) {
+			t.Errorf("decodeCursor(encodeCursor(%+v)) = %+v", tt.cursor, got)
+		}
+	}
+
+	// the last page has no cursor
+	if s, err := encodeCursor(db.ItemSortNewest, nil); s != "" || err != nil {
+		t.Errorf("encodeCursor(nil) = %q, %v, want an empty cursor", s, err)
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	encode := func(payload string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(payload))
+	}
+	other, err := encodeCursor(db.ItemSortPriceAsc, &db.ItemCursor{Key: int64(1000), ID: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, s := range map[string]string{
+		"another sort":  other,
+		"not base64":    "!!!",
+		"not JSON":      encode("cursor"),
+		"no id":         encode(`{"s":1,"k":1000}`),
+		"an object key": encode(`{"s":1,"k":{},"i":3}`),
+		"a bool key":    encode(`{"s":1,"k":true,"i":3}`),
+		"no key":        encode(`{"s":1,"i":3}`),
+	} {
+		if cursor, err := decodeCursor(db.ItemSortPriceDesc, s); err == nil {
+			t.Errorf("%s: decodeCursor() = %+v, want an error", name, cursor)
+		}
+	}
+}
+
+func TestReadList(t *testing.T) {
+	cursor, err := encodeCursor(db.ItemSortNewest, &db.ItemCursor{Key: "2023-06-01 10:00:00", ID: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name      string
+		query     string
+		v2        bool
+		wantPaged bool
+		wantLimit int
+		wantAfter bool
+		wantErr   bool
+	}{
+		{name: "v1", query: "sort=newest"},
+		{name: "v1 with limit", query: "limit=10", wantErr: true},
+		{name: "v1 with cursor", query: "sort=newest&cursor=" + cursor, wantErr: true},
+		{name: "v2", v2: true, wantPaged: true, wantLimit: defaultPageSize},
+		{name: "v2 with limit", query: "limit=10", v2: true, wantPaged: true, wantLimit: 10},
+		{name: "v2 with cursor", query: "sort=newest&cursor=" + cursor, v2: true, wantPaged: true, wantLimit: defaultPageSize, wantAfter: true},
+		{name: "cursor of another sort", query: "sort=price_asc&cursor=" + cursor, v2: true, wantErr: true},
+		{name: "limit 0", query: "limit=0", v2: true, wantErr: true},
+		{name: "limit over the max", query: "limit=101", v2: true, wantErr: true},
+		{name: "unknown sort", query: "sort=oldest", v2: true, wantErr: true},
+	} {
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/items?"+tt.query, nil), httptest.NewRecorder())
+		if tt.v2 {
+			c.Set(pagedListKey, true)
+		}
+		var query db.ItemQuery
+		paged, err := readList(c, &query)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: readList() error = %v, want an error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if paged != tt.wantPaged || query.Limit != tt.wantLimit || (query.After != nil) != tt.wantAfter {
+			t.Errorf("%s: readList() = %v, limit %d, after %+v", tt.name, paged, query.Limit, query.After)
+		}
+	}
+}
+
+// TestPagedList pages through the items on sale in both price sorts, which the v1 list returns at once
+func TestPagedList(t *testing.T) {
+	ctx := context.Background()
+	h := newTestHandler(t)
+	if _, err := h.DB.Exec("INSERT INTO category (id, name) VALUES (1, 'category')"); err != nil {
+		t.Fatal(err)
+	}
+	sellerID := addTestUser(t, h, 0)
+	want := make(map[int32]bool)
+	for i := 0; i < 5; i++ {
+		id, err := h.ItemRepo.AddItem(ctx, domain.Item{Name: "item", Price: 1000, Description: "description", CategoryID: 1, UserID: sellerID, Status: domain.ItemStatusOnSale})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[id] = true
+	}
+	// items listed before the price was required have none, and are on the first and the last page
+	if _, err := h.DB.Exec("UPDATE items SET price = NULL WHERE id IN (1, 3)"); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(target string, handler echo.HandlerFunc) []byte {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		if err := handler(echo.New().NewContext(httptest.NewRequest(http.MethodGet, target, nil), rec)); err != nil {
+			t.Fatalf("GET %s error = %v", target, err)
+		}
+		return rec.Body.Bytes()
+	}
+
+	for _, sort := range []string{"price_asc", "price_desc"} {
+		var all []getOnSaleItemsResponse
+		if err := json.Unmarshal(get("/items?sort="+sort, h.GetOnSaleItems), &all); err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != len(want) {
+			t.Errorf("%s: v1 items = %d, want %d", sort, len(all), len(want))
+		}
+
+		got := make(map[int32]bool)
+		cursor := ""
+		for pages := 1; ; pages++ {
+			var page struct {
+				Items      []getOnSaleItemsResponse `json:"items"`
+				NextCursor string                   `json:"next_cursor"`
+			}
+			if err := json.Unmarshal(get("/v2/items?sort="+sort+"&limit=2&cursor="+cursor, PagedList(h.GetOnSaleItems)), &page); err != nil {
+				t.Fatal(err)
+			}
+			for _, item := range page.Items {
+				if got[item.ID] {
+					t.Errorf("%s: item %d is on two pages", sort, item.ID)
+				}
+				got[item.ID] = true
+			}
+			if page.NextCursor == "" {
+				if pages != 3 {
+					t.Errorf("%s: pages = %d, want 3", sort, pages)
+				}
+				break
+			}
+			cursor = page.NextCursor
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: paged items = %v, want %v", sort, got, want)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/db"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+	"github.com/labstack/echo/v4"
+)
+
+type requestPayoutRequest struct {
+	Amount int64 `json:"amount"`
+}
+
+type requestPayoutResponse struct {
+	ID int64 `json:"id"`
+}
+
+type approvePayoutRequest struct {
+	Reference string `json:"reference"`
+}
+
+type rejectPayoutRequest struct {
+	Reason string `json:"reason"`
+}
+
+type getPayoutResponse struct {
+	ID        int64               `json:"id"`
+	UserID    int64               `json:"user_id"`
+	Amount    int64               `json:"amount"`
+	Status    domain.PayoutStatus `json:"status"`
+	Reference string              `json:"reference,omitempty"`
+	Reason    string              `json:"reason,omitempty"`
+	CreatedAt string              `json:"created_at"`
+	UpdatedAt string              `json:"updated_at"`
+}
+
+func toPayoutResponse(p domain.Payout) getPayoutResponse {
+	return getPayoutResponse{
+		ID:        p.ID,
+		UserID:    p.UserID,
+		Amount:    p.Amount,
+		Status:    p.Status,
+		Reference: p.Reference,
+		Reason:    p.Reason,
+		CreatedAt: p.CreatedAt,
+		UpdatedAt: p.UpdatedAt,
+	}
+}
+
+// RequestPayout moves the amount from the wallet to the payout account until it is settled
+func (h *Handler) RequestPayout(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	req := new(requestPayoutRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if req.Amount <= 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Amount must be greater than 0.")
+	}
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer tx.Rollback()
+
+	id, err := h.PayoutRepo.AddPayoutTx(tx, ctx, userID, req.Amount)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	// held money is no longer part of the balance, so it cannot be spent or withdrawn twice
+	if _, err := h.LedgerRepo.PostTx(tx, ctx, domain.LedgerKindWithdraw, 0, []domain.LedgerEntry{
+		{Account: domain.LedgerAccountWallet, UserID: userID, Amount: -req.Amount},
+		{Account: domain.LedgerAccountPayout, UserID: userID, Amount: req.Amount},
+	}); err != nil {
+		if err == db.ErrInsufficientBalance {
+			return echo.NewHTTPError(http.StatusBadRequest, "Your balance is not enough.")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, requestPayoutResponse{ID: id})
+}
+
+func (h *Handler) GetPayouts(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	payouts, err := h.PayoutRepo.GetPayoutsByUserID(ctx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	res := make([]getPayoutResponse, len(payouts))
+	for i, p := range payouts {
+		res[i] = toPayoutResponse(p)
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) GetPayout(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	payoutID, err := getPayoutID(c)
+	if err != nil {
+		return err
+	}
+
+	p, err := h.PayoutRepo.GetPayout(ctx, payoutID)
+	if err != nil {
+		// not found handling
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, "Payout not found.")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if p.UserID != userID && !adminUserIDs[userID] {
+		return echo.NewHTTPError(http.StatusNotFound, "Payout not found.")
+	}
+
+	return c.JSON(http.StatusOK, toPayoutResponse(p))
+}
+
+// GetPendingPayouts lists the payouts waiting for an admin
+func (h *Handler) GetPendingPayouts(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	payouts, err := h.PayoutRepo.GetPayoutsByStatus(ctx, domain.PayoutStatusPending)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	res := make([]getPayoutResponse, len(payouts))
+	for i, p := range payouts {
+		res[i] = toPayoutResponse(p)
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) ApprovePayout(c echo.Context) error {
+	req := new(approvePayoutRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return h.settlePayout(c, domain.PayoutResult{Status: domain.PayoutStatusPaid, Reference: req.Reference})
+}
+
+func (h *Handler) RejectPayout(c echo.Context) error {
+	req := new(rejectPayoutRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if len(req.Reason) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Reason cannot be empty.")
+	}
+	return h.settlePayout(c, domain.PayoutResult{Status: domain.PayoutStatusRejected, Reason: req.Reason})
+}
+
+func (h *Handler) settlePayout(c echo.Context, result domain.PayoutResult) error {
+	ctx := c.Request().Context()
+
+	payoutID, err := getPayoutID(c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer tx.Rollback()
+
+	p, err := h.PayoutRepo.GetPayoutTx(tx, ctx, payoutID)
+	if err != nil {
+		// not found handling
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, "Payout not found.")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	switch p.Status {
+	case domain.PayoutStatusPending:
+	case domain.PayoutStatusProcessing:
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "This payout is being transferred.")
+	default:
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "This payout is already settled.")
+	}
+
+	if err := h.settlePayoutTx(tx, ctx, p, result); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, "successful")
+}
+
+// settlePayoutTx sends the held money out of the platform, or back to the wallet when rejected.
+// It returns db.ErrConflict if the payout is no longer in the status of p.
+func (h *Handler) settlePayoutTx(tx *sql.Tx, ctx context.Context, p domain.Payout, result domain.PayoutResult) error {
+	kind := domain.LedgerKindPayout
+	to := domain.LedgerEntry{Account: domain.LedgerAccountExternal, Amount: p.Amount}
+	if result.Status == domain.PayoutStatusRejected {
+		kind = domain.LedgerKindPayoutRejected
+		to = domain.LedgerEntry{Account: domain.LedgerAccountWallet, UserID: p.UserID, Amount: p.Amount}
+	}
+
+	if _, err := h.LedgerRepo.PostTx(tx, ctx, kind, 0, []domain.LedgerEntry{
+		{Account: domain.LedgerAccountPayout, UserID: p.UserID, Amount: -p.Amount},
+		to,
+	}); err != nil {
+		return err
+	}
+
+	return h.PayoutRepo.UpdatePayoutStatusTx(tx, ctx, p.ID, p.Status, result)
+}
+
+// ProcessPayouts hands the pending payouts to the provider. Each payout is claimed before its transfer, so that an admin
+// cannot settle it meanwhile, and stays processing if the transfer fails, to be transferred again on the next run with the
+// same idempotency key. Payouts the provider leaves pending stay processing with their provider_ref, and their status
+// is polled on the next runs instead.
+func (h *Handler) ProcessPayouts(ctx context.Context, provider domain.PayoutProvider) error {
+	pending, err := h.PayoutRepo.GetPayoutsByStatus(ctx, domain.PayoutStatusPending)
+	if err != nil {
+		return err
+	}
+	processing, err := h.PayoutRepo.GetPayoutsByStatus(ctx, domain.PayoutStatusProcessing)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range append(processing, pending...) {
+		result, err := h.processPayout(ctx, provider, p)
+		if err != nil {
+			log.Printf("failed to process payout %v: %s", p.ID, err)
+			continue
+		}
+		if result.Status != domain.PayoutStatusPending {
+			log.Printf("payout settled: %v status %v", p.ID, result.Status)
+		}
+	}
+	return nil
+}
+
+func (h *Handler) processPayout(ctx context.Context, provider domain.PayoutProvider, p domain.Payout) (domain.PayoutResult, error) {
+	if p.ProviderRef != "" {
+		return h.pollPayout(ctx, provider, p)
+	}
+	if p.Status == domain.PayoutStatusPending {
+		// an admin may have settled it in the meantime
+		if err := h.PayoutRepo.UpdatePayoutStatus(ctx, p.ID, domain.PayoutStatusPending, domain.PayoutStatusProcessing); err != nil {
+			return domain.PayoutResult{}, err
+		}
+		p.Status = domain.PayoutStatusProcessing
+	}
+
+	result, err := provider.Transfer(ctx, p)
+	if err != nil {
+		return result, err
+	}
+	if result.Status == domain.PayoutStatusPending {
+		return result, h.PayoutRepo.UpdateProviderRef(ctx, p.ID, result.Reference)
+	}
+	return result, h.finishPayout(ctx, p, result)
+}
+
+// pollPayout settles the payout once the provider has finished its transfer
+func (h *Handler) pollPayout(ctx context.Context, provider domain.PayoutProvider, p domain.Payout) (domain.PayoutResult, error) {
+	result, err := provider.Status(ctx, p.ProviderRef)
+	if err != nil || result.Status == domain.PayoutStatusPending {
+		return result, err
+	}
+	return result, h.finishPayout(ctx, p, result)
+}
+
+// finishPayout settles the processing payout with the result of the provider
+func (h *Handler) finishPayout(ctx context.Context, p domain.Payout, result domain.PayoutResult) error {
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := h.settlePayoutTx(tx, ctx, p, result); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func getPayoutID(c echo.Context) (int64, error) {
+	payoutID, err := strconv.ParseInt(c.Param("payoutID"), 10, 64)
+	if err != nil {
+		return -1, echo.NewHTTPError(http.StatusBadRequest, "invalid payoutID")
+	}
+	return payoutID, nil
+}
@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+// flakyProvider fails the first transfers after sending the money, like a provider which timed out
+type flakyProvider struct {
+	failures int
+	result   domain.PayoutResult
+	// keys are the idempotency keys of every call
+	keys []string
+	// status is the result of a pending transfer, and polls the references it was asked for
+	status domain.PayoutResult
+	polls  []string
+}
+
+func (f *flakyProvider) Transfer(ctx context.Context, p domain.Payout) (domain.PayoutResult, error) {
+	f.keys = append(f.keys, p.IdempotencyKey())
+	if f.failures > 0 {
+		f.failures--
+		return domain.PayoutResult{}, errors.New("timeout")
+	}
+	return f.result, nil
+}
+
+func (f *flakyProvider) Status(ctx context.Context, ref string) (domain.PayoutResult, error) {
+	f.polls = append(f.polls, ref)
+	return f.status, nil
+}
+
+func requestTestPayout(t *testing.T, h *Handler, userID int64, amount int64) int64 {
+	t.Helper()
+	ctx := context.Background()
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	id, err := h.PayoutRepo.AddPayoutTx(tx, ctx, userID, amount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.LedgerRepo.PostTx(tx, ctx, domain.LedgerKindWithdraw, 0, []domain.LedgerEntry{
+		{Account: domain.LedgerAccountWallet, UserID: userID, Amount: -amount},
+		{Account: domain.LedgerAccountPayout, UserID: userID, Amount: amount},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestProcessPayoutsRetriesWithTheSameKey(t *testing.T) {
+	ctx := context.Background()
+	h := newTestHandler(t)
+	userID := addTestUser(t, h, 1000)
+	payoutID := requestTestPayout(t, h, userID, 600)
+	provider := &flakyProvider{failures: 1, result: domain.PayoutResult{Status: domain.PayoutStatusPaid, Reference: "transfer-1"}}
+
+	if err := h.ProcessPayouts(ctx, provider); err != nil {
+		t.Fatal(err)
+	}
+	p, err := h.PayoutRepo.GetPayout(ctx, payoutID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != domain.PayoutStatusProcessing {
+		t.Fatalf("status after a failed transfer = %v, want processing", p.Status)
+	}
+
+	if err := h.ProcessPayouts(ctx, provider); err != nil {
+		t.Fatal(err)
+	}
+	p, err = h.PayoutRepo.GetPayout(ctx, payoutID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != domain.PayoutStatusPaid || p.Reference != "transfer-1" {
+		t.Errorf("payout = %+v, want paid with transfer-1", p)
+	}
+	if len(provider.keys) != 2 || provider.keys[0] != provider.keys[1] {
+		t.Errorf("idempotency keys = %v, want the same key twice", provider.keys)
+	}
+
+	held, err := h.LedgerRepo.GetBalance(ctx, domain.LedgerAccountPayout, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wallet, err := h.LedgerRepo.GetBalance(ctx, domain.LedgerAccountWallet, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if held != 0 || wallet != 400 {
+		t.Errorf("held = %d, wallet = %d, want 0 and 400", held, wallet)
+	}
+
+	// a settled payout is not transferred again
+	if err := h.ProcessPayouts(ctx, provider); err != nil {
+		t.Fatal(err)
+	}
+	if len(provider.keys) != 2 {
+		t.Errorf("transfers = %d, want 2", len(provider.keys))
+	}
+}
+
+func TestProcessPayoutsPollsPending(t *testing.T) {
+	ctx := context.Background()
+	h := newTestHandler(t)
+	userID := addTestUser(t, h, 1000)
+	payoutID := requestTestPayout(t, h, userID, 600)
+	pending := domain.PayoutResult{Status: domain.PayoutStatusPending, Reference: "transfer-1"}
+	provider := &flakyProvider{result: pending, status: pending}
+
+	// the transfer is sent once and polled on the next runs
+	for i := 0; i < 2; i++ {
+		if err := h.ProcessPayouts(ctx, provider); err != nil {
+			t.Fatal(err)
+		}
+		p, err := h.PayoutRepo.GetPayout(ctx, payoutID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Status != domain.PayoutStatusProcessing || p.ProviderRef != "transfer-1" {
+			t.Fatalf("payout after run %d = %+v, want processing with transfer-1", i+1, p)
+		}
+	}
+	if len(provider.keys) != 1 || len(provider.polls) != 1 || provider.polls[0] != "transfer-1" {
+		t.Fatalf("transfers = %v, polls = %v, want one each of transfer-1", provider.keys, provider.polls)
+	}
+
+	provider.status = domain.PayoutResult{Status: domain.PayoutStatusPaid, Reference: "transfer-1"}
+	if err := h.ProcessPayouts(ctx, provider); err != nil {
+		t.Fatal(err)
+	}
+	p, err := h.PayoutRepo.GetPayout(ctx, payoutID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != domain.PayoutStatusPaid || p.Reference != "transfer-1" {
+		t.Errorf("payout = %+v, want paid with transfer-1", p)
+	}
+	held, err := h.LedgerRepo.GetBalance(ctx, domain.LedgerAccountPayout, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if held != 0 {
+		t.Errorf("held = %d, want 0", held)
+	}
+	if len(provider.keys) != 1 {
+		t.Errorf("transfers = %d, want 1", len(provider.keys))
+	}
+}
@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type getPriceHistoryResponse struct {
+	OldPrice  int64  `json:"old_price"`
+	NewPrice  int64  `json:"new_price"`
+	CreatedAt string `json:"created_at"`
+}
+
+// GetPriceHistory lists the price changes of the item, the latest first
+func (h *Handler) GetPriceHistory(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	itemID, err := getItemID(c)
+	if err != nil {
+		return err
+	}
+	if _, err := h.ItemRepo.GetItem(ctx, itemID); err != nil {
+		// not found handling
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	changes, err := h.PriceRepo.GetPriceHistory(ctx, itemID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	res := make([]getPriceHistoryResponse, len(changes))
+	for i, p := range changes {
+		res[i] = getPriceHistoryResponse{OldPrice: p.OldPrice, NewPrice: p.NewPrice, CreatedAt: p.CreatedAt}
+	}
+	return c.JSON(http.StatusOK, res)
+}
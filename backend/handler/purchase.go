@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/service"
+	"github.com/labstack/echo/v4"
+)
+
+// Purchase is the deprecated route of PurchaseV2. It used to pay the seller without escrow.
+func (h *Handler) Purchase(c echo.Context) error {
+	c.Response().Header().Set("Deprecation", "true")
+	c.Response().Header().Set("Link", "</purchase-v2/"+c.Param("itemID")+`>; rel="successor-version"`)
+	return h.PurchaseV2(c)
+}
+
+func (h *Handler) PurchaseV2(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	// the body is optional
+	req := new(purchaseRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	itemID, err := getItemID(c)
+	if err != nil {
+		return err
+	}
+
+	if _, err := h.Purchases.Purchase(ctx, service.PurchaseInput{
+		ItemID:     itemID,
+		BuyerID:    userID,
+		CouponCode: req.CouponCode,
+		Points:     req.Points,
+	}); err != nil {
+		return purchaseError(err)
+	}
+
+	return c.JSON(http.StatusOK, "successful")
+}
+
+// purchaseError converts the errors of the purchase service into responses
+func purchaseError(err error) error {
+	switch err {
+	case service.ErrItemNotFound, service.ErrUserNotFound:
+		return echo.NewHTTPError(http.StatusPreconditionFailed, err.Error())
+	case service.ErrNotOnSale:
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "This item is not on sale.")
+	case service.ErrReserved:
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "This item is reserved for another buyer.")
+	case service.ErrOwnItem:
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "Cannot buy your own item.")
+	case service.ErrSoldOut:
+		return echo.NewHTTPError(http.StatusConflict, "This item has already been sold.")
+	case service.ErrInsufficientFunds:
+		return echo.NewHTTPError(http.StatusBadRequest, "Your balance is not enough.")
+	case service.ErrInsufficientPoints:
+		return echo.NewHTTPError(http.StatusBadRequest, "Your points are not enough.")
+	case service.ErrInvalidPoints:
+		return echo.NewHTTPError(http.StatusBadRequest, "Points cannot be negative or exceed the price.")
+	case service.ErrInvalidCoupon:
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid coupon code.")
+	case service.ErrCouponExpired:
+		return echo.NewHTTPError(http.StatusBadRequest, "This coupon has expired.")
+	case service.ErrCouponMinPrice:
+		return echo.NewHTTPError(http.StatusBadRequest, "The price is too low for this coupon.")
+	case service.ErrCouponUsedUp:
+		return echo.NewHTTPError(http.StatusBadRequest, "This coupon is no longer available.")
+	case service.ErrCouponAlreadyUsed:
+		return echo.NewHTTPError(http.StatusBadRequest, "You have already used this coupon.")
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+}
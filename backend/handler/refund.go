@@ -0,0 +1,266 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/db"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+	"github.com/labstack/echo/v4"
+)
+
+type cancelPurchaseRequest struct {
+	Reason string `json:"reason"`
+}
+
+// RequestCancel is called by the buyer. The purchase is refunded when the seller approves.
+func (h *Handler) RequestCancel(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	req := new(cancelPurchaseRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if len(req.Reason) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Reason cannot be empty.")
+	}
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	itemID, err := getItemID(c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer tx.Rollback()
+
+	p, err := h.getCancellablePurchaseTx(tx, ctx, itemID)
+	if err != nil {
+		return err
+	}
+	if p.BuyerID != userID {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "You can only cancel items you bought.")
+	}
+
+	if err := h.PurchaseRepo.UpdateCancelRequestTx(tx, ctx, p.ID, userID, req.Reason); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, "successful")
+}
+
+// ApproveCancel is called by the seller to accept the cancel request of the buyer
+func (h *Handler) ApproveCancel(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	itemID, err := getItemID(c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer tx.Rollback()
+
+	p, err := h.getCancellablePurchaseTx(tx, ctx, itemID)
+	if err != nil {
+		return err
+	}
+	if p.SellerID != userID {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "You can only cancel your own items.")
+	}
+	if p.CancelRequestedBy != p.BuyerID {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "The buyer has not requested to cancel.")
+	}
+
+	if err := h.refundTx(tx, ctx, p); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	// status changed, delete from cache
+	CA.Delete(fmt.Sprintf(itemKey, itemID))
+
+	return c.JSON(http.StatusOK, "successful")
+}
+
+// RejectCancel is called by the seller to turn down the cancel request of the buyer
+func (h *Handler) RejectCancel(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	itemID, err := getItemID(c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer tx.Rollback()
+
+	p, err := h.getCancellablePurchaseTx(tx, ctx, itemID)
+	if err != nil {
+		return err
+	}
+	if p.SellerID != userID {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "You can only cancel your own items.")
+	}
+	if p.CancelRequestedBy != p.BuyerID {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "The buyer has not requested to cancel.")
+	}
+
+	if err := h.PurchaseRepo.UpdateCancelRequestTx(tx, ctx, p.ID, 0, p.CancelReason); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, "successful")
+}
+
+// AdminRefund cancels the purchase without the agreement of the seller
+func (h *Handler) AdminRefund(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	req := new(cancelPurchaseRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if len(req.Reason) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Reason cannot be empty.")
+	}
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	itemID, err := getItemID(c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer tx.Rollback()
+
+	p, err := h.getCancellablePurchaseTx(tx, ctx, itemID)
+	if err != nil {
+		return err
+	}
+
+	if err := h.PurchaseRepo.UpdateCancelRequestTx(tx, ctx, p.ID, userID, req.Reason); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if err := h.refundTx(tx, ctx, p); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	// status changed, delete from cache
+	CA.Delete(fmt.Sprintf(itemKey, itemID))
+
+	return c.JSON(http.StatusOK, "successful")
+}
+
+func (h *Handler) getCancellablePurchaseTx(tx *sql.Tx, ctx context.Context, itemID int32) (domain.Purchase, error) {
+	p, err := h.PurchaseRepo.GetPurchaseByItemIDTx(tx, ctx, itemID)
+	if err != nil {
+		// not found handling
+		if err == sql.ErrNoRows {
+			return p, echo.NewHTTPError(http.StatusPreconditionFailed, "This item has not been purchased.")
+		}
+		return p, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if !p.Status.CanTransitionTo(domain.PurchaseStatusCancelled) {
+		return p, echo.NewHTTPError(http.StatusPreconditionFailed, "This purchase cannot be cancelled.")
+	}
+	return p, nil
+}
+
+// refundTx returns the money to the buyer and puts the item back on sale
+func (h *Handler) refundTx(tx *sql.Tx, ctx context.Context, p domain.Purchase) error {
+	// the buyer gets back what they paid, the discount goes back to the platform
+	var entries []domain.LedgerEntry
+	if paid := p.Price - p.Discount - p.Points; paid != 0 {
+		entries = append(entries, domain.LedgerEntry{Account: domain.LedgerAccountWallet, UserID: p.BuyerID, Amount: paid})
+	}
+	if p.Points != 0 {
+		entries = append(entries, domain.LedgerEntry{Account: domain.LedgerAccountPoints, UserID: p.BuyerID, Amount: p.Points})
+	}
+	platform := p.Discount
+	// the money is still in escrow unless the purchase was completed
+	if p.Status == domain.PurchaseStatusCompleted {
+		entries = append(entries, domain.LedgerEntry{Account: domain.LedgerAccountWallet, UserID: p.SellerID, Amount: -p.SellerAmount})
+		platform -= p.Fee.Amount
+	} else {
+		entries = append(entries, domain.LedgerEntry{Account: domain.LedgerAccountEscrow, Amount: -p.Price})
+	}
+	if platform != 0 {
+		entries = append(entries, domain.LedgerEntry{Account: domain.LedgerAccountPlatform, Amount: platform})
+	}
+
+	if _, err := h.LedgerRepo.PostTx(tx, ctx, domain.LedgerKindRefund, p.ItemID, entries); err != nil {
+		if err == db.ErrInsufficientBalance {
+			return echo.NewHTTPError(http.StatusPreconditionFailed, "The seller balance is not enough to refund.")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if err := h.PurchaseRepo.UpdatePurchaseStatusTx(tx, ctx, p.ID, domain.PurchaseStatusCancelled); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	// the coupon can be used again
+	if p.CouponID != 0 {
+		if err := h.CouponRepo.UnredeemTx(tx, ctx, p.ID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
+	item, err := h.ItemRepo.GetItemWithDeletedTx(tx, ctx, p.ItemID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if err := h.ItemRepo.UpdateItemStatusTx(tx, ctx, p.ItemID, item.Status, domain.ItemStatusOnSale); err != nil {
+		return itemStatusError(err)
+	}
+	// a completed sale may have been deleted by the seller. The listing is deleted as well, and comes back as a draft
+	// when they restore it.
+	if item.DeletedAt != "" {
+		if err := h.ItemRepo.UpdateItemStatusTx(tx, ctx, p.ItemID, domain.ItemStatusOnSale, domain.ItemStatusDeleted); err != nil {
+			return itemStatusError(err)
+		}
+	}
+	return nil
+}
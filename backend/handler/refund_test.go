@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+// addTestSale adds a completed sale of the item, with the seller paid
+func addTestSale(t *testing.T, h *Handler, itemID int32, buyerID int64, sellerID int64, price int64) {
+	t.Helper()
+	if _, err := h.DB.Exec("UPDATE items SET status = ? WHERE id = ?", domain.ItemStatusCompleted, itemID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.DB.Exec("INSERT INTO purchase (item_id, buyer_id, seller_id, price, seller_amount, status) VALUES (?, ?, ?, ?, ?, ?)",
+		itemID, buyerID, sellerID, price, price, domain.PurchaseStatusCompleted); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRefundDeletedSale(t *testing.T) {
+	ctx := context.Background()
+	h := newTestHandler(t)
+	// the seller was paid the price of the sale
+	sellerID := addTestUser(t, h, 1000)
+	buyerID := addTestUser(t, h, 0)
+	adminID := addTestUser(t, h, 0)
+	itemID := addTestItem(t, h, sellerID, 1000)
+	item := fmt.Sprint(itemID)
+	addTestSale(t, h, itemID, buyerID, sellerID, 1000)
+
+	c, _ := newTestContext(sellerID, http.MethodDelete, "/items/"+item, "", "itemID", item)
+	if err := h.DeleteItem(c); err != nil {
+		t.Fatal(err)
+	}
+	c, _ = newTestContext(adminID, http.MethodPost, "/purchase/"+item+"/refund", `{"reason": "never arrived"}`, "itemID", item)
+	if err := h.AdminRefund(c); err != nil {
+		t.Fatal(err)
+	}
+
+	// the refunded listing stays deleted
+	var status domain.ItemStatus
+	var deleted bool
+	if err := h.DB.QueryRow("SELECT status, deleted_at IS NOT NULL FROM items WHERE id = ?", itemID).Scan(&status, &deleted); err != nil {
+		t.Fatal(err)
+	}
+	if status != domain.ItemStatusDeleted || !deleted {
+		t.Fatalf("item after refund = status %v deleted %v, want deleted", status, deleted)
+	}
+
+	c, _ = newTestContext(sellerID, http.MethodPost, "/items/"+item+"/restore", "", "itemID", item)
+	if err := h.RestoreItem(c); err != nil {
+		t.Fatal(err)
+	}
+	got, err := h.ItemRepo.GetItem(ctx, itemID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != domain.ItemStatusInitial {
+		t.Errorf("status after restore = %v, want %v", got.Status, domain.ItemStatusInitial)
+	}
+}
+
+func TestRefundGivesBackCoupon(t *testing.T) {
+	ctx := context.Background()
+	h := newTestHandler(t)
+	sellerID := addTestUser(t, h, 1000)
+	buyerID := addTestUser(t, h, 0)
+	adminID := addTestUser(t, h, 0)
+	itemID := addTestItem(t, h, sellerID, 1000)
+	item := fmt.Sprint(itemID)
+	addTestSale(t, h, itemID, buyerID, sellerID, 1000)
+
+	couponID, err := h.CouponRepo.AddCoupon(ctx, domain.Coupon{Code: "ONCE", DiscountType: domain.CouponDiscountAmount, DiscountValue: 100, UsageLimit: 1, ExpiresAt: "2099-01-01 00:00:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.DB.Exec("UPDATE purchase SET coupon_id = ?, discount = 100 WHERE item_id = ?", couponID, itemID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.DB.Exec("UPDATE coupons SET used_count = 1 WHERE id = ?", couponID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.DB.Exec("INSERT INTO coupon_redemptions (coupon_id, user_id, purchase_id, discount) SELECT ?, ?, id, 100 FROM purchase WHERE item_id = ?", couponID, buyerID, itemID); err != nil {
+		t.Fatal(err)
+	}
+
+	c, _ := newTestContext(adminID, http.MethodPost, "/purchase/"+item+"/refund", `{"reason": "never arrived"}`, "itemID", item)
+	if err := h.AdminRefund(c); err != nil {
+		t.Fatal(err)
+	}
+
+	var used, redemptions int64
+	if err := h.DB.QueryRow("SELECT used_count, (SELECT COUNT(*) FROM coupon_redemptions) FROM coupons WHERE id = ?", couponID).Scan(&used, &redemptions); err != nil {
+		t.Fatal(err)
+	}
+	if used != 0 || redemptions != 0 {
+		t.Errorf("used_count = %d, redemptions = %d, want 0 and 0", used, redemptions)
+	}
+}
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+	"github.com/labstack/echo/v4"
+)
+
+type getItemRevisionsResponse struct {
+	ID        int64                `json:"id"`
+	UserID    int64                `json:"user_id"`
+	Changes   []itemChangeResponse `json:"changes"`
+	CreatedAt string               `json:"created_at"`
+}
+
+type itemChangeResponse struct {
+	Field domain.ItemField `json:"field"`
+	Old   string           `json:"old"`
+	New   string           `json:"new"`
+}
+
+// GetItemRevisions lists the edits of the item, the latest first
+func (h *Handler) GetItemRevisions(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	itemID, err := getItemID(c)
+	if err != nil {
+		return err
+	}
+	if _, err := h.ItemRepo.GetItem(ctx, itemID); err != nil {
+		// not found handling
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	revisions, err := h.RevisionRepo.GetRevisions(ctx, itemID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	res := make([]getItemRevisionsResponse, len(revisions))
+	for i, rev := range revisions {
+		changes := make([]itemChangeResponse, len(rev.Changes))
+		for j, change := range rev.Changes {
+			changes[j] = itemChangeResponse{Field: change.Field, Old: change.Old, New: change.New}
+		}
+		res[i] = getItemRevisionsResponse{ID: rev.ID, UserID: rev.UserID, Changes: changes, CreatedAt: rev.CreatedAt}
+	}
+	return c.JSON(http.StatusOK, res)
+}
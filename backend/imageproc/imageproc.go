@@ -0,0 +1,134 @@
+// Package imageproc checks uploaded images, removes their metadata and makes their thumbnails.
+package imageproc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+type Format string
+
+const (
+	FormatJPEG Format = "jpeg"
+	FormatPNG  Format = "png"
+	FormatGIF  Format = "gif"
+	FormatWebP Format = "webp"
+)
+
+func (f Format) ContentType() string {
+	return "image/" + string(f)
+}
+
+var (
+	// ErrUnsupported means the data is not a JPEG, PNG or GIF image
+	ErrUnsupported = errors.New("unsupported image format")
+	// ErrTooLarge means the image is over the limits of the Pipeline
+	ErrTooLarge = errors.New("image too large")
+	// ErrInvalid means the data looks like an image but cannot be read
+	ErrInvalid = errors.New("invalid image")
+)
+
+// ThumbnailSize is the width and the height of every thumbnail
+const ThumbnailSize = 300
+
+// Pipeline turns an upload into the image to store
+type Pipeline struct {
+	// MaxBytes is the largest upload accepted
+	MaxBytes int64
+	// MaxPixels is the largest width * height accepted, which also bounds the memory used to make the thumbnail
+	MaxPixels int
+}
+
+func NewPipeline() *Pipeline {
+	return &Pipeline{MaxBytes: 4 << 20, MaxPixels: 25_000_000}
+}
+
+// Image is an upload ready to be stored
+type Image struct {
+	Format Format
+	Width  int
+	Height int
+	// Data is the upload without its metadata
+	Data []byte
+	// Thumbnail is a ThumbnailSize square JPEG
+	Thumbnail []byte
+}
+
+// Process checks the upload and removes its EXIF, XMP and other metadata, such as the GPS position.
+// The orientation of JPEG images is kept so that they are still shown upright.
+// Every error returned is caused by the upload itself.
+func (p *Pipeline) Process(data []byte) (Image, error) {
+	if int64(len(data)) > p.MaxBytes {
+		return Image{}, fmt.Errorf("%w: over %d bytes", ErrTooLarge, p.MaxBytes)
+	}
+	format, err := Detect(data)
+	if err != nil {
+		return Image{}, err
+	}
+
+	// WebP is only detected to serve the images stored before it was rejected, as there is no decoder
+	// to make their thumbnails with
+	if format == FormatWebP {
+		return Image{}, fmt.Errorf("%w: WebP images have no thumbnail", ErrUnsupported)
+	}
+
+	img := Image{Format: format}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	img.Width, img.Height = cfg.Width, cfg.Height
+	if err != nil {
+		return Image{}, fmt.Errorf("%w: %s", ErrInvalid, err)
+	}
+	if img.Width <= 0 || img.Height <= 0 {
+		return Image{}, fmt.Errorf("%w: empty image", ErrInvalid)
+	}
+	if img.Width > p.MaxPixels/img.Height {
+		return Image{}, fmt.Errorf("%w: %dx%d is over %d pixels", ErrTooLarge, img.Width, img.Height, p.MaxPixels)
+	}
+
+	orientation := 1
+	switch format {
+	case FormatJPEG:
+		img.Data, orientation, err = stripJPEG(data)
+	case FormatPNG:
+		img.Data, err = stripPNG(data)
+	case FormatGIF:
+		img.Data, err = stripGIF(data)
+	}
+	if err != nil {
+		return Image{}, fmt.Errorf("%w: %s", ErrInvalid, err)
+	}
+
+	if img.Thumbnail, err = thumbnail(img.Data, orientation); err != nil {
+		return Image{}, fmt.Errorf("%w: %s", ErrInvalid, err)
+	}
+	return img, nil
+}
+
+// Detect tells the format from the content, whatever the file name or the Content-Type of the upload
+func Detect(data []byte) (Format, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8, 0xff}):
+		return FormatJPEG, nil
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return FormatPNG, nil
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return FormatGIF, nil
+	case len(data) >= 12 && bytes.Equal(data[:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		return FormatWebP, nil
+	}
+	return "", ErrUnsupported
+}
+
+// ContentType returns the Content-Type to serve stored data with
+func ContentType(data []byte) string {
+	format, err := Detect(data)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return format.ContentType()
+}
@@ -0,0 +1,81 @@
+package imageproc
+
+import (
+	"bytes"
+	"errors"
+	"image/jpeg"
+	"testing"
+)
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		format Format
+		w, h   int
+	}{
+		{"jpeg", testJPEG(t, 40, 20, 1), FormatJPEG, 40, 20},
+		{"rotated jpeg", testJPEG(t, 40, 20, 6), FormatJPEG, 40, 20},
+		{"png", testPNG(t, 40, 20), FormatPNG, 40, 20},
+		{"gif", testGIF(t, 40, 20), FormatGIF, 40, 20},
+	}
+	for _, tt := range tests {
+		img, err := NewPipeline().Process(tt.data)
+		if err != nil {
+			t.Errorf("%s: Process() error = %v", tt.name, err)
+			continue
+		}
+		if img.Format != tt.format || img.Width != tt.w || img.Height != tt.h {
+			t.Errorf("%s: Process() = %v %dx%d, want %v %dx%d", tt.name, img.Format, img.Width, img.Height, tt.format, tt.w, tt.h)
+		}
+		if bytes.Contains(img.Data, []byte(secret)) {
+			t.Errorf("%s: metadata left after Process()", tt.name)
+		}
+		thumb, err := jpeg.DecodeConfig(bytes.NewReader(img.Thumbnail))
+		if err != nil || thumb.Width != ThumbnailSize || thumb.Height != ThumbnailSize {
+			t.Errorf("%s: thumbnail = %dx%d, %v, want a %d square JPEG", tt.name, thumb.Width, thumb.Height, err, ThumbnailSize)
+		}
+	}
+}
+
+func TestProcessRejects(t *testing.T) {
+	p := NewPipeline()
+	p.MaxPixels = 40 * 20
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"text", []byte("<svg></svg>"), ErrUnsupported},
+		{"empty", nil, ErrUnsupported},
+		{"over the bytes", append(testPNG(t, 40, 20), make([]byte, p.MaxBytes)...), ErrTooLarge},
+		{"over the pixels", testPNG(t, 41, 20), ErrTooLarge},
+		// there is no WebP decoder to make the thumbnail with
+		{"webp", []byte("RIFF\x00\x00\x00\x00WEBPVP8L"), ErrUnsupported},
+		{"truncated", testPNG(t, 40, 20)[:20], ErrInvalid},
+	}
+	for _, tt := range tests {
+		if _, err := p.Process(tt.data); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Process() error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	for data, want := range map[string]Format{
+		"\xff\xd8\xff\xe0":            FormatJPEG,
+		"\x89PNG\r\n\x1a\n":           FormatPNG,
+		"GIF87a":                      FormatGIF,
+		"GIF89a":                      FormatGIF,
+		"RIFF\x00\x00\x00\x00WEBPVP8": FormatWebP,
+	} {
+		if got, err := Detect([]byte(data)); err != nil || got != want {
+			t.Errorf("Detect(%q) = %v, %v, want %v", data, got, err, want)
+		}
+	}
+	// a WAV file is RIFF too
+	if _, err := Detect([]byte("RIFF\x00\x00\x00\x00WAVEfmt ")); err != ErrUnsupported {
+		t.Errorf("Detect() of a WAV file error = %v, want %v", err, ErrUnsupported)
+	}
+}
@@ -0,0 +1,206 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// stripJPEG drops the APP1 (EXIF, XMP), APP13 (IPTC) and comment segments, and returns the EXIF orientation.
+// An orientation other than 1 is written back as an EXIF segment holding nothing else.
+func stripJPEG(data []byte) ([]byte, int, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xff, 0xd8)
+	orientation := 1
+	// the orientation goes after the JFIF segment if there is one
+	insertAt := len(out)
+
+	i := 2
+	for {
+		if i+4 > len(data) || data[i] != 0xff {
+			return nil, 0, errors.New("jpeg: bad segment")
+		}
+		marker := data[i+1]
+		// the scan runs until the end of the image
+		if marker == 0xda {
+			out = append(out, data[i:]...)
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if length < 2 || i+2+length > len(data) {
+			return nil, 0, errors.New("jpeg: bad segment length")
+		}
+		segment := data[i : i+2+length]
+		i += 2 + length
+
+		switch marker {
+		case 0xe1:
+			if o, ok := exifOrientation(segment[4:]); ok {
+				orientation = o
+			}
+		case 0xed, 0xfe:
+		default:
+			out = append(out, segment...)
+			if marker == 0xe0 {
+				insertAt = len(out)
+			}
+		}
+	}
+
+	if orientation != 1 {
+		out = append(out[:insertAt], append(orientationSegment(orientation), out[insertAt:]...)...)
+	}
+	return out, orientation, nil
+}
+
+// exifOrientation reads the orientation tag of IFD0 from an APP1 payload
+func exifOrientation(payload []byte) (int, bool) {
+	if !bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+		return 0, false
+	}
+	tiff := payload[6:]
+	if len(tiff) < 8 {
+		return 0, false
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, false
+	}
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd+2 > len(tiff) {
+		return 0, false
+	}
+	n := int(order.Uint16(tiff[ifd : ifd+2]))
+	for e := 0; e < n; e++ {
+		entry := ifd + 2 + e*12
+		if entry+12 > len(tiff) {
+			return 0, false
+		}
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+			o := int(order.Uint16(tiff[entry+8 : entry+10]))
+			if o < 1 || o > 8 {
+				return 0, false
+			}
+			return o, true
+		}
+	}
+	return 0, false
+}
+
+// orientationSegment is an APP1 segment with an EXIF orientation tag only
+func orientationSegment(orientation int) []byte {
+	tiff := []byte{
+		'M', 'M', 0, 42, 0, 0, 0, 8, // header, IFD0 at 8
+		0, 1, // one entry
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, byte(orientation), 0, 0, // orientation, SHORT, count 1
+		0, 0, 0, 0, // no next IFD
+	}
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// pngMetadata are the chunks dropped from PNG images
+var pngMetadata = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+func stripPNG(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:8]...)
+	for i := 8; i < len(data); {
+		if i+12 > len(data) {
+			return nil, errors.New("png: bad chunk")
+		}
+		length := int(binary.BigEndian.Uint32(data[i : i+4]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, errors.New("png: bad chunk length")
+		}
+		if !pngMetadata[string(data[i+4:i+8])] {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return out, nil
+}
+
+// gifLooping are the application extensions kept in GIF images, which tell how many times an animation plays
+var gifLooping = map[string]bool{"NETSCAPE2.0": true, "ANIMEXTS1.0": true}
+
+// stripGIF drops the comment extensions and the application extensions other than looping, such as XMP,
+// along with anything after the trailer
+func stripGIF(data []byte) ([]byte, error) {
+	if len(data) < 13 {
+		return nil, errors.New("gif: too short")
+	}
+	out := make([]byte, 0, len(data))
+	// header, logical screen descriptor and global color table
+	i := 13 + gifColorTableSize(data[10])
+	if i > len(data) {
+		return nil, errors.New("gif: bad color table")
+	}
+	out = append(out, data[:i]...)
+
+	for {
+		if i >= len(data) {
+			return nil, errors.New("gif: no trailer")
+		}
+		start := i
+		keep := true
+		switch data[i] {
+		case 0x3b:
+			return append(out, 0x3b), nil
+		case 0x21:
+			if i+2 > len(data) {
+				return nil, errors.New("gif: bad extension")
+			}
+			switch data[i+1] {
+			case 0xfe:
+				keep = false
+			case 0xff:
+				// the first sub-block of an application extension is its identifier and authentication code
+				keep = i+3+11 <= len(data) && data[i+2] == 11 && gifLooping[string(data[i+3:i+3+11])]
+			}
+			i += 2
+		case 0x2c:
+			if i+10 > len(data) {
+				return nil, errors.New("gif: bad image descriptor")
+			}
+			// descriptor, local color table and LZW minimum code size
+			i += 10 + gifColorTableSize(data[i+9]) + 1
+		default:
+			return nil, errors.New("gif: bad block")
+		}
+
+		// the data sub-blocks run until an empty one
+		for {
+			if i >= len(data) {
+				return nil, errors.New("gif: bad sub-block")
+			}
+			size := int(data[i])
+			i += 1 + size
+			if size == 0 {
+				break
+			}
+		}
+		if i > len(data) {
+			return nil, errors.New("gif: bad sub-block")
+		}
+		if keep {
+			out = append(out, data[start:i]...)
+		}
+	}
+}
+
+// gifColorTableSize is the size of the color table a packed field of a GIF declares
+func gifColorTableSize(packed byte) int {
+	if packed&0x80 == 0 {
+		return 0
+	}
+	return 3 << (packed&0x07 + 1)
+}
@@ -0,0 +1,169 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// secret is the metadata every test image carries, which must not be left after stripping
+const secret = "GPS 35.6812N 139.7671E"
+
+func testImage(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 0x80, 0xff})
+		}
+	}
+	return img
+}
+
+// exifSegment is an APP1 segment with the orientation and the secret in IFD0
+func exifSegment(orientation int) []byte {
+	tiff := []byte{
+		'I', 'I', 42, 0, 8, 0, 0, 0, // header, IFD0 at 8
+		2, 0, // two entries
+		0x12, 0x01, 3, 0, 1, 0, 0, 0, byte(orientation), 0, 0, 0, // orientation
+		0x0e, 0x01, 2, 0, byte(len(secret)), 0, 0, 0, 38, 0, 0, 0, // image description at 38
+		0, 0, 0, 0, // no next IFD
+	}
+	payload := append(append([]byte("Exif\x00\x00"), tiff...), secret...)
+	segment := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+func testJPEG(t *testing.T, w, h int, orientation int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(w, h), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	comment := append([]byte{0xff, 0xfe, 0, byte(len(secret) + 2)}, secret...)
+	// the metadata go right after SOI, before the JFIF segment of the encoder
+	return append(append(append([]byte{0xff, 0xd8}, exifSegment(orientation)...), comment...), data[2:]...)
+}
+
+func pngChunk(kind string, data []byte) []byte {
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], kind)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage(w, h)); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// after the signature and IHDR
+	ihdr := 8 + 12 + 13
+	var out []byte
+	out = append(out, data[:ihdr]...)
+	out = append(out, pngChunk("tEXt", []byte("Comment\x00"+secret))...)
+	out = append(out, pngChunk("eXIf", exifSegment(1)[10:])...)
+	return append(out, data[ihdr:]...)
+}
+
+// gifExtension is an extension block with the data in one sub-block
+func gifExtension(label byte, data ...[]byte) []byte {
+	ext := []byte{0x21, label}
+	for _, d := range data {
+		ext = append(append(ext, byte(len(d))), d...)
+	}
+	return append(ext, 0)
+}
+
+// testGIF is an animation which loops forever, with a comment and XMP after the global color table
+func testGIF(t *testing.T, w, h int) []byte {
+	t.Helper()
+	frame := image.NewPaletted(image.Rect(0, 0, w, h), color.Palette{color.Black, color.White})
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, &gif.GIF{Image: []*image.Paletted{frame, frame}, Delay: []int{10, 10}, LoopCount: 0}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	header := 13 + gifColorTableSize(data[10])
+	var out []byte
+	out = append(out, data[:header]...)
+	out = append(out, gifExtension(0xfe, []byte(secret))...)
+	out = append(out, gifExtension(0xff, []byte("XMP DataXMP"), []byte(secret))...)
+	out = append(out, data[header:]...)
+	// anything after the trailer is dropped too
+	return append(out, secret...)
+}
+
+func TestStripJPEG(t *testing.T) {
+	for _, orientation := range []int{1, 6} {
+		out, got, err := stripJPEG(testJPEG(t, 40, 20, orientation))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(out, []byte(secret)) {
+			t.Errorf("orientation %d: metadata left after stripping", orientation)
+		}
+		if got != orientation {
+			t.Errorf("orientation = %d, want %d", got, orientation)
+		}
+		// the orientation is written back for the viewers
+		if _, again, err := stripJPEG(out); err != nil || again != orientation {
+			t.Errorf("orientation of the stripped image = %d, %v, want %d", again, err, orientation)
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+			t.Errorf("orientation %d: stripped image does not decode: %s", orientation, err)
+		}
+	}
+
+	if _, _, err := stripJPEG([]byte{0xff, 0xd8, 0xff, 0xe1, 0xff, 0xff}); err == nil {
+		t.Error("stripJPEG() of a truncated segment error = nil")
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	out, err := stripPNG(testPNG(t, 40, 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out, []byte(secret)) || bytes.Contains(out, []byte("tEXt")) || bytes.Contains(out, []byte("eXIf")) {
+		t.Error("metadata left after stripping")
+	}
+	if _, err := png.Decode(bytes.NewReader(out)); err != nil {
+		t.Errorf("stripped image does not decode: %s", err)
+	}
+
+	if _, err := stripPNG(append([]byte("\x89PNG\r\n\x1a\n"), 0, 0, 1, 0, 'I', 'D', 'A', 'T')); err == nil {
+		t.Error("stripPNG() of a truncated chunk error = nil")
+	}
+}
+
+func TestStripGIF(t *testing.T) {
+	out, err := stripGIF(testGIF(t, 40, 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out, []byte(secret)) || bytes.Contains(out, []byte("XMP DataXMP")) {
+		t.Error("metadata left after stripping")
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("stripped image does not decode: %s", err)
+	}
+	if len(g.Image) != 2 || g.LoopCount != 0 {
+		t.Errorf("stripped animation has %d frames and loop count %d, want 2 frames looping forever", len(g.Image), g.LoopCount)
+	}
+
+	if _, err := stripGIF([]byte("GIF89a\x01\x00\x01\x00\x00\x00\x00\x21\xfe\x05ab")); err == nil {
+		t.Error("stripGIF() of a truncated extension error = nil")
+	}
+}
@@ -0,0 +1,84 @@
+package imageproc
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+)
+
+// samples is the number of source pixels averaged per thumbnail pixel on each axis
+const samples = 4
+
+// thumbnail crops the middle square of the image, turns it upright and scales it to ThumbnailSize.
+// Transparent pixels are put on white since the thumbnail is a JPEG.
+func thumbnail(data []byte, orientation int) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	b := src.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+
+	dst := image.NewRGBA(image.Rect(0, 0, ThumbnailSize, ThumbnailSize))
+	scale := float64(side) / ThumbnailSize
+	for y := 0; y < ThumbnailSize; y++ {
+		for x := 0; x < ThumbnailSize; x++ {
+			// the square is read as if it was already upright
+			u, v := orient(x, y, ThumbnailSize, orientation)
+			var r, g, bl, a uint32
+			for sy := 0; sy < samples; sy++ {
+				for sx := 0; sx < samples; sx++ {
+					px := x0 + int((float64(u)+(float64(sx)+0.5)/samples)*scale)
+					py := y0 + int((float64(v)+(float64(sy)+0.5)/samples)*scale)
+					cr, cg, cb, ca := src.At(px, py).RGBA()
+					r, g, bl, a = r+cr, g+cg, bl+cb, a+ca
+				}
+			}
+			n := uint32(samples * samples)
+			r, g, bl, a = r/n, g/n, bl/n, a/n
+			// premultiplied colors over white
+			white := 0xffff - a
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8((r + white) >> 8),
+				G: uint8((g + white) >> 8),
+				B: uint8((bl + white) >> 8),
+				A: 0xff,
+			})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// orient returns the pixel of a stored square of side n which is shown at (x, y) with the EXIF orientation
+func orient(x, y, n, orientation int) (int, int) {
+	last := n - 1
+	switch orientation {
+	case 2:
+		return last - x, y
+	case 3:
+		return last - x, last - y
+	case 4:
+		return x, last - y
+	case 5:
+		return y, x
+	case 6:
+		return y, last - x
+	case 7:
+		return last - y, last - x
+	case 8:
+		return last - y, x
+	default:
+		return x, y
+	}
+}
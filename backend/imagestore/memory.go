@@ -0,0 +1,42 @@
+package imagestore
+
+import (
+	"context"
+	"sync"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+// MemoryStore keeps the images in memory. It is meant for development and as a stand-in in tests.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	images map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{images: map[string][]byte{}}
+}
+
+func (s *MemoryStore) Put(ctx context.Context, key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.images[key] = append([]byte(nil), data...)
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, ok := s.images[key]
+	if !ok {
+		return nil, domain.ErrImageNotFound
+	}
+	return data, nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.images, key)
+	return nil
+}
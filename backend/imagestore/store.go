@@ -0,0 +1,60 @@
+// Package imagestore implements domain.ImageStore on the local file system, on S3 compatible storage and in memory.
+package imagestore
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+// NewKey returns a random key for a new image
+func NewKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "images/" + hex.EncodeToString(b), nil
+}
+
+// ThumbnailKey returns the key of the thumbnail of the image stored under key
+func ThumbnailKey(key string) string {
+	return key + "_thumb"
+}
+
+// FromEnv returns the store set by IMAGE_STORE, which is local by default.
+//
+//	local:  IMAGE_DIR (default images)
+//	s3:     S3_ENDPOINT, S3_BUCKET, S3_REGION (default us-east-1), S3_ACCESS_KEY_ID, S3_SECRET_ACCESS_KEY
+//	memory: nothing, the images are lost when the server stops
+func FromEnv() (domain.ImageStore, error) {
+	switch v := os.Getenv("IMAGE_STORE"); v {
+	case "", "local":
+		dir := os.Getenv("IMAGE_DIR")
+		if dir == "" {
+			dir = "images"
+		}
+		return NewLocalStore(dir), nil
+	case "s3":
+		store := &S3Store{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			Region:          os.Getenv("S3_REGION"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		}
+		if store.Endpoint == "" || store.Bucket == "" {
+			return nil, fmt.Errorf("S3_ENDPOINT and S3_BUCKET are required")
+		}
+		if store.Region == "" {
+			store.Region = "us-east-1"
+		}
+		return store, nil
+	case "memory":
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown IMAGE_STORE %q", v)
+	}
+}
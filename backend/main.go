@@ -2,15 +2,23 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/1en0/mecari-build-hackathon-2023/backend/db"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
 	"github.com/1en0/mecari-build-hackathon-2023/backend/handler"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/imageproc"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/imagestore"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/payout"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/service"
 	"github.com/golang-jwt/jwt/v5"
 	echojwt "github.com/labstack/echo-jwt/v4"
 	"github.com/labstack/echo/v4"
@@ -53,7 +61,13 @@ func run(ctx context.Context) int {
 		AllowOrigins: []string{frontURL},
 		AllowMethods: []string{"GET", "PUT", "DELETE", "OPTIONS", "POST"},
 	}))
-	e.Use(middleware.BodyLimit("5M"))
+	e.Use(middleware.BodyLimitWithConfig(middleware.BodyLimitConfig{
+		// imports carry the images of many items and have their own limit
+		Skipper: func(c echo.Context) bool {
+			return c.Path() == "/items/import"
+		},
+		Limit: "5M",
+	}))
 
 	// jwt
 	config := echojwt.Config{
@@ -70,13 +84,62 @@ func run(ctx context.Context) int {
 		return exitError
 	}
 	defer sqlDB.Close()
+	if !db.HasFullText(ctx, sqlDB) {
+		fmt.Fprintln(os.Stderr, "warning: SQLite has no FTS5, searches do not use the search index. Build with -tags sqlite_fts5, as make does.")
+	}
+
+	fees, err := feeSchedule()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid FEE_SCHEDULE: %s\n", err)
+		return exitError
+	}
+
+	payoutProvider, err := newPayoutProvider()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid payout provider: %s\n", err)
+		return exitError
+	}
+
+	images, err := imagestore.FromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid image store: %s\n", err)
+		return exitError
+	}
 
 	h := handler.Handler{
-		DB:       sqlDB,
-		UserRepo: db.NewUserRepository(sqlDB),
-		ItemRepo: db.NewItemRepository(sqlDB),
+		DB:           sqlDB,
+		UserRepo:     db.NewUserRepository(sqlDB),
+		ItemRepo:     db.NewItemRepository(sqlDB),
 		PurchaseRepo: db.NewPurchaseRepository(sqlDB),
+		LedgerRepo:   db.NewLedgerRepository(sqlDB),
+		CouponRepo:   db.NewCouponRepository(sqlDB),
+		PayoutRepo:   db.NewPayoutRepository(sqlDB),
+		OfferRepo:    db.NewOfferRepository(sqlDB),
+		ImageRepo:    db.NewImageRepository(sqlDB),
+		RevisionRepo: db.NewRevisionRepository(sqlDB),
+		PriceRepo:    db.NewPriceHistoryRepository(sqlDB),
+		ImportRepo:   db.NewImportRepository(sqlDB),
+		LikeRepo:     db.NewLikeRepository(sqlDB),
+		Images:       images,
+		Pipeline:     imageproc.NewPipeline(),
+		Fees:         fees,
+		// imports are run one at a time
+		Imports: make(chan struct{}, 1),
 	}
+	h.Purchases = &service.PurchaseService{
+		DB:           h.DB,
+		UserRepo:     h.UserRepo,
+		ItemRepo:     h.ItemRepo,
+		PurchaseRepo: h.PurchaseRepo,
+		LedgerRepo:   h.LedgerRepo,
+		CouponRepo:   h.CouponRepo,
+		OfferRepo:    h.OfferRepo,
+		Fees:         h.Fees,
+		Cache:        handler.CA,
+	}
+
+	// replay retried requests which move money
+	idempotent := handler.Idempotency(db.NewIdempotencyRepository(sqlDB))
 
 	// Routes
 	e.POST("/initialize", h.Initialize)
@@ -87,24 +150,149 @@ func run(ctx context.Context) int {
 	e.GET("/search-detail", h.SearchItemsDetail)
 	e.GET("/items/:itemID", h.GetItem)
 	e.GET("/items/:itemID/image", h.GetImage)
+	e.GET("/items/:itemID/thumbnail", h.GetThumbnail)
+	e.GET("/items/:itemID/revisions", h.GetItemRevisions)
+	e.GET("/items/:itemID/price-history", h.GetPriceHistory)
+	e.GET("/items/:itemID/images", h.GetItemImages)
+	e.GET("/items/:itemID/images/:index", h.GetItemImageAt)
 	e.GET("/items/categories", h.GetCategories)
 	e.POST("/register", h.Register)
 	e.POST("/login", h.Login)
+	// the lists which are always paged
+	e.GET("/v2/items", h.GetOnSaleItems, handler.PagedList)
+	e.GET("/v2/search", h.SearchItemsByName, handler.PagedList)
+	e.GET("/v2/search-detail", h.SearchItemsDetail, handler.PagedList)
 
 	// Login required
 	l := e.Group("")
 	l.Use(echojwt.WithConfig(config))
 	l.GET("/users/:userID/items", h.GetUserItems)
 	l.GET("/users/:userID/purchase", h.GetPurchasedItems)
+	l.GET("/v2/users/:userID/items", h.GetUserItems, handler.PagedList)
+	l.GET("/v2/users/:userID/purchase", h.GetPurchasedItems, handler.PagedList)
 	l.POST("/items", h.AddItem)
+	l.POST("/items/import", h.ImportItems, middleware.BodyLimit("100M"))
+	l.GET("/items/import/:jobID", h.GetImportJob)
 	l.PUT("/items/:itemID", h.EditItem)
 	l.POST("/sell", h.Sell)
-	l.POST("/purchase/:itemID", h.Purchase)
-	l.POST("/purchase-v2/:itemID", h.PurchaseV2)
+	l.POST("/items/:itemID/pause", h.PauseItem)
+	l.POST("/items/:itemID/relist", h.RelistItem)
+	l.POST("/items/:itemID/withdraw", h.WithdrawItem)
+	l.DELETE("/items/:itemID", h.DeleteItem)
+	l.POST("/items/:itemID/images", h.AddItemImage)
+	l.PUT("/items/:itemID/images", h.ReorderItemImages)
+	l.DELETE("/items/:itemID/images/:index", h.DeleteItemImage)
+	l.POST("/items/:itemID/restore", h.RestoreItem)
+	l.POST("/items/:itemID/like", h.LikeItem)
+	l.DELETE("/items/:itemID/like", h.UnlikeItem)
+	l.DELETE("/users/:userID", h.DeleteUser)
+	l.POST("/purchase/:itemID", h.Purchase, idempotent)
+	l.POST("/purchase-v2/:itemID", h.PurchaseV2, idempotent)
+	l.GET("/purchase/:itemID", h.GetPurchase)
+	l.POST("/purchase/:itemID/ship", h.ShipItem)
+	l.POST("/purchase/:itemID/receive", h.ReceiveItem)
+	l.POST("/purchase/:itemID/cancel", h.RequestCancel)
+	l.POST("/purchase/:itemID/cancel/approve", h.ApproveCancel, idempotent)
+	l.POST("/purchase/:itemID/cancel/reject", h.RejectCancel)
 	l.GET("/balance", h.GetBalance)
-	l.POST("/balance", h.AddBalance)
+	l.POST("/balance", h.AddBalance, idempotent)
+	l.GET("/balance/history", h.GetBalanceHistory)
+	l.GET("/points", h.GetPoints)
+	l.POST("/items/:itemID/offers", h.AddOffer)
+	l.GET("/items/:itemID/offers", h.GetItemOffers)
+	l.GET("/offers", h.GetOffers)
+	l.POST("/offers/:offerID/accept", h.AcceptOffer)
+	l.POST("/offers/:offerID/reject", h.RejectOffer)
+	l.POST("/offers/:offerID/counter", h.CounterOffer)
+	l.POST("/offers/:offerID/withdraw", h.WithdrawOffer)
+	l.POST("/payouts", h.RequestPayout, idempotent)
+	l.GET("/payouts", h.GetPayouts)
+	l.GET("/payouts/:payoutID", h.GetPayout)
 	l.GET("/items-auth/:itemID", h.GetItemWithAuth) // Store history of userID
 
+	// Admin only
+	a := l.Group("/admin")
+	a.Use(handler.AdminOnly)
+	a.POST("/purchase/:itemID/refund", h.AdminRefund, idempotent)
+	a.GET("/reports/sales", h.GetSalesReport)
+	a.POST("/coupons", h.AddCoupon)
+	a.GET("/coupons", h.GetCoupons)
+	a.POST("/points", h.GrantPoints)
+	a.GET("/payouts", h.GetPendingPayouts)
+	a.POST("/payouts/:payoutID/approve", h.ApprovePayout)
+	a.POST("/payouts/:payoutID/reject", h.RejectPayout)
+	a.POST("/users/:userID/restore", h.RestoreUser)
+	a.POST("/purge", h.Purge)
+
+	// Background jobs
+	escrowTimeout := 7 * 24 * time.Hour
+	if v := os.Getenv("ESCROW_RELEASE_TIMEOUT"); v != "" {
+		if escrowTimeout, err = time.ParseDuration(v); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid ESCROW_RELEASE_TIMEOUT: %s\n", err)
+			return exitError
+		}
+	}
+	h.OfferTTL = 48 * time.Hour
+	if v := os.Getenv("OFFER_TTL"); v != "" {
+		if h.OfferTTL, err = time.ParseDuration(v); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid OFFER_TTL: %s\n", err)
+			return exitError
+		}
+	}
+	h.MaxItemImages = 10
+	if v := os.Getenv("MAX_ITEM_IMAGES"); v != "" {
+		if h.MaxItemImages, err = strconv.Atoi(v); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid MAX_ITEM_IMAGES: %s\n", err)
+			return exitError
+		}
+		// every item has its cover
+		if h.MaxItemImages < 1 {
+			fmt.Fprintf(os.Stderr, "invalid MAX_ITEM_IMAGES: %d is less than 1\n", h.MaxItemImages)
+			return exitError
+		}
+	}
+	if v := os.Getenv("MAX_IMAGE_BYTES"); v != "" {
+		if h.Pipeline.MaxBytes, err = strconv.ParseInt(v, 10, 64); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid MAX_IMAGE_BYTES: %s\n", err)
+			return exitError
+		}
+	}
+	if h.PriceBuckets, err = priceBuckets(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid PRICE_BUCKETS: %s\n", err)
+		return exitError
+	}
+	h.ImageMaxAge = 5 * time.Minute
+	if v := os.Getenv("IMAGE_MAX_AGE"); v != "" {
+		if h.ImageMaxAge, err = time.ParseDuration(v); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid IMAGE_MAX_AGE: %s\n", err)
+			return exitError
+		}
+	}
+	h.PurgeRetention = 30 * 24 * time.Hour
+	if v := os.Getenv("PURGE_RETENTION"); v != "" {
+		if h.PurgeRetention, err = time.ParseDuration(v); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid PURGE_RETENTION: %s\n", err)
+			return exitError
+		}
+	}
+	// imports run in the server, the ones running when it stopped are lost
+	if _, err := h.ImportRepo.FailRunningImportJobs(ctx, "The server stopped during the import."); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to close unfinished imports: %s\n", err)
+		return exitError
+	}
+	jobCtx, stopJobs := context.WithCancel(ctx)
+	defer stopJobs()
+	go runEvery(jobCtx, time.Minute, func(ctx context.Context) error {
+		return h.ReleaseEscrow(ctx, escrowTimeout)
+	})
+	go runEvery(jobCtx, time.Minute, h.ExpireOffers)
+	go runEvery(jobCtx, time.Hour, h.PurgeDeleted)
+	if payoutProvider != nil {
+		go runEvery(jobCtx, time.Minute, func(ctx context.Context) error {
+			return h.ProcessPayouts(ctx, payoutProvider)
+		})
+	}
+
 	// Start server
 	go func() {
 		if err := e.Start(":9000"); err != nil && err != http.ErrServerClosed {
@@ -124,6 +312,109 @@ func run(ctx context.Context) int {
 	return exitOK
 }
 
+func runEvery(ctx context.Context, interval time.Duration, job func(ctx context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := job(ctx); err != nil {
+				fmt.Fprintf(os.Stderr, "background job failed: %s\n", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+type feeRule struct {
+	RateBP int64 `json:"rate_bp"`
+	Flat   int64 `json:"flat"`
+	Min    int64 `json:"min"`
+}
+
+// feeSchedule reads the commission from FEE_SCHEDULE, e.g.
+// {"rate_bp": 1000, "flat": 0, "min": 50, "categories": {"1": {"rate_bp": 500}}}
+// The default is 10% of the price.
+func feeSchedule() (domain.FeeSchedule, error) {
+	config := struct {
+		feeRule
+		Categories map[int64]feeRule `json:"categories"`
+	}{feeRule: feeRule{RateBP: 1000}}
+
+	if v := os.Getenv("FEE_SCHEDULE"); v != "" {
+		if err := json.Unmarshal([]byte(v), &config); err != nil {
+			return domain.FeeSchedule{}, err
+		}
+	}
+
+	if err := config.feeRule.check(); err != nil {
+		return domain.FeeSchedule{}, err
+	}
+	fees := domain.FeeSchedule{
+		Default:    domain.FeeRule(config.feeRule),
+		Categories: map[int64]domain.FeeRule{},
+	}
+	for id, rule := range config.Categories {
+		if err := rule.check(); err != nil {
+			return domain.FeeSchedule{}, fmt.Errorf("category %d: %w", id, err)
+		}
+		fees.Categories[id] = domain.FeeRule(rule)
+	}
+	return fees, nil
+}
+
+// check rejects rates over 100%, and negative rules, which would pay the seller more than the price
+func (r feeRule) check() error {
+	if r.RateBP < 0 || r.RateBP > 10000 {
+		return fmt.Errorf("rate_bp %d is not between 0 and 10000", r.RateBP)
+	}
+	if r.Flat < 0 || r.Min < 0 {
+		return fmt.Errorf("flat and min must not be negative")
+	}
+	return nil
+}
+
+// priceBuckets returns the bounds of the price buckets of search facets, e.g. PRICE_BUCKETS=1000,5000,10000
+func priceBuckets() ([]int64, error) {
+	v := os.Getenv("PRICE_BUCKETS")
+	if v == "" {
+		return []int64{1000, 3000, 5000, 10000, 50000}, nil
+	}
+	var bounds []int64
+	for _, s := range strings.Split(v, ",") {
+		bound, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if bound <= 0 || (len(bounds) > 0 && bound <= bounds[len(bounds)-1]) {
+			return nil, fmt.Errorf("bounds must be positive and ascending")
+		}
+		bounds = append(bounds, bound)
+	}
+	return bounds, nil
+}
+
+// newPayoutProvider returns the provider set by PAYOUT_PROVIDER. Without it payouts are settled by admins.
+// PAYOUT_FAKE_LIMIT holds the transfers over the amount until the next run.
+func newPayoutProvider() (domain.PayoutProvider, error) {
+	switch v := os.Getenv("PAYOUT_PROVIDER"); v {
+	case "":
+		return nil, nil
+	case "fake":
+		var limit int64
+		if s := os.Getenv("PAYOUT_FAKE_LIMIT"); s != "" {
+			var err error
+			if limit, err = strconv.ParseInt(s, 10, 64); err != nil {
+				return nil, err
+			}
+		}
+		return payout.NewFakeProvider(limit), nil
+	default:
+		return nil, fmt.Errorf("unknown PAYOUT_PROVIDER %q", v)
+	}
+}
+
 func logFormat() string {
 	// Customize freely: https://echo.labstack.com/guide/customization/
 	var format string
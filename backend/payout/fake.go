@@ -0,0 +1,48 @@
+package payout
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+// FakeProvider pays out without moving any real money. Transfers over Limit are held for a review,
+// which is over by the time their status is polled.
+type FakeProvider struct {
+	// Limit is the largest amount paid at once, 0 means no limit
+	Limit int64
+
+	mu sync.Mutex
+	// sent are the results of the transfers by idempotency key
+	sent map[string]domain.PayoutResult
+}
+
+func NewFakeProvider(limit int64) *FakeProvider {
+	return &FakeProvider{Limit: limit, sent: make(map[string]domain.PayoutResult)}
+}
+
+func (f *FakeProvider) Transfer(ctx context.Context, p domain.Payout) (domain.PayoutResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := p.IdempotencyKey()
+	if result, ok := f.sent[key]; ok {
+		return result, nil
+	}
+	result := domain.PayoutResult{Status: domain.PayoutStatusPaid, Reference: fmt.Sprintf("fake-%d", p.ID)}
+	if f.Limit != 0 && p.Amount > f.Limit {
+		log.Printf("fake payout: %v to user %v held for review", p.Amount, p.UserID)
+		result.Status = domain.PayoutStatusPending
+	} else {
+		log.Printf("fake payout: %v to user %v", p.Amount, p.UserID)
+	}
+	f.sent[key] = result
+	return result, nil
+}
+
+func (f *FakeProvider) Status(ctx context.Context, ref string) (domain.PayoutResult, error) {
+	log.Printf("fake payout: %v paid after review", ref)
+	return domain.PayoutResult{Status: domain.PayoutStatusPaid, Reference: ref}, nil
+}
@@ -0,0 +1,255 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/db"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+	"github.com/patrickmn/go-cache"
+	"github.com/pkg/errors"
+)
+
+// ItemKey is the cache key of an item response
+const ItemKey = "Item{%v}"
+
+var (
+	ErrItemNotFound       = errors.New("item not found")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrNotOnSale          = errors.New("this item is not on sale")
+	ErrSoldOut            = errors.New("this item has already been sold")
+	ErrReserved           = errors.New("this item is reserved for another buyer")
+	ErrOwnItem            = errors.New("cannot buy your own item")
+	ErrInvalidPoints      = errors.New("points cannot be negative or exceed the price")
+	ErrInsufficientPoints = errors.New("your points are not enough")
+	ErrInsufficientFunds  = errors.New("your balance is not enough")
+	ErrInvalidCoupon      = errors.New("invalid coupon code")
+	ErrCouponExpired      = errors.New("this coupon has expired")
+	ErrCouponMinPrice     = errors.New("the price is too low for this coupon")
+	ErrCouponUsedUp       = errors.New("this coupon is no longer available")
+	ErrCouponAlreadyUsed  = errors.New("you have already used this coupon")
+)
+
+type PurchaseInput struct {
+	ItemID     int32
+	BuyerID    int64
+	CouponCode string
+	Points     int64
+}
+
+// PurchaseService buys items. The money is held in escrow until the buyer receives the item.
+type PurchaseService struct {
+	DB           *sql.DB
+	UserRepo     db.UserRepository
+	ItemRepo     db.ItemRepository
+	PurchaseRepo db.PurchaseRepository
+	LedgerRepo   db.LedgerRepository
+	CouponRepo   db.CouponRepository
+	OfferRepo    db.OfferRepository
+	Fees         domain.FeeSchedule
+	Cache        *cache.Cache
+}
+
+// Purchase marks the item sold, records the purchase and moves the money in one transaction.
+// Errors caused by the input are the Err values of this package.
+func (s *PurchaseService) Purchase(ctx context.Context, in PurchaseInput) (domain.Purchase, error) {
+	if in.Points < 0 {
+		return domain.Purchase{}, ErrInvalidPoints
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.Purchase{}, err
+	}
+	defer tx.Rollback()
+
+	p, err := s.purchaseTx(tx, ctx, in)
+	if err != nil {
+		return p, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return p, err
+	}
+
+	// status changed, delete from cache
+	if s.Cache != nil {
+		s.Cache.Delete(fmt.Sprintf(ItemKey, in.ItemID))
+	}
+	return p, nil
+}
+
+func (s *PurchaseService) purchaseTx(tx *sql.Tx, ctx context.Context, in PurchaseInput) (domain.Purchase, error) {
+	item, err := s.ItemRepo.GetItemTx(tx, ctx, in.ItemID)
+	if err != nil {
+		// not found handling
+		if err == sql.ErrNoRows {
+			return domain.Purchase{}, ErrItemNotFound
+		}
+		return domain.Purchase{}, err
+	}
+
+	// update only when item status is on sale
+	if item.Status.Sold() {
+		return domain.Purchase{}, ErrSoldOut
+	}
+	if item.Status != domain.ItemStatusOnSale && item.Status != domain.ItemStatusReserved {
+		return domain.Purchase{}, ErrNotOnSale
+	}
+
+	// not to buy own items
+	if item.UserID == in.BuyerID {
+		return domain.Purchase{}, ErrOwnItem
+	}
+
+	buyer, err := s.UserRepo.GetUserTx(tx, ctx, in.BuyerID)
+	if err != nil {
+		// not found handling
+		if err == sql.ErrNoRows {
+			return domain.Purchase{}, ErrUserNotFound
+		}
+		return domain.Purchase{}, err
+	}
+	if _, err := s.UserRepo.GetUserTx(tx, ctx, item.UserID); err != nil {
+		// not found handling
+		if err == sql.ErrNoRows {
+			return domain.Purchase{}, ErrUserNotFound
+		}
+		return domain.Purchase{}, err
+	}
+
+	// a buyer whose offer was accepted pays the agreed price, everyone else the list price.
+	// The seller may have lowered the price below the agreed one since.
+	price := item.Price
+	offer, err := s.OfferRepo.GetAcceptedOfferTx(tx, ctx, item.ID, buyer.ID, time.Now().Format(db.TimeLayout))
+	if err != nil && err != sql.ErrNoRows {
+		return domain.Purchase{}, err
+	}
+	if err == nil && offer.AgreedPrice() < price {
+		price = offer.AgreedPrice()
+	}
+	// a reserved item is held for the buyer of the accepted offer
+	if item.Status == domain.ItemStatusReserved && offer.ID == 0 {
+		return domain.Purchase{}, ErrReserved
+	}
+
+	// the discount is paid by the platform and does not reduce the seller amount
+	var coupon domain.Coupon
+	var discount int64
+	if len(in.CouponCode) != 0 {
+		coupon, err = s.getCouponTx(tx, ctx, in.CouponCode, buyer.ID, price)
+		if err != nil {
+			return domain.Purchase{}, err
+		}
+		discount = coupon.Discount(price)
+	}
+	if in.Points > price-discount {
+		return domain.Purchase{}, ErrInvalidPoints
+	}
+	pay := price - discount - in.Points
+	if pay > buyer.Balance {
+		return domain.Purchase{}, ErrInsufficientFunds
+	}
+
+	// another buyer may have bought it since it was read
+	if err := s.ItemRepo.UpdateItemStatusTx(tx, ctx, item.ID, item.Status, domain.ItemStatusSoldOut); err != nil {
+		if err == db.ErrConflict {
+			return domain.Purchase{}, ErrSoldOut
+		}
+		return domain.Purchase{}, err
+	}
+
+	fee := s.Fees.Calculate(item.CategoryID, price)
+	p := domain.Purchase{
+		ItemID:       item.ID,
+		BuyerID:      buyer.ID,
+		SellerID:     item.UserID,
+		Price:        price,
+		CouponID:     coupon.ID,
+		Discount:     discount,
+		Points:       in.Points,
+		Fee:          fee,
+		SellerAmount: price - fee.Amount,
+		Status:       domain.PurchaseStatusPaid,
+	}
+	if p.ID, err = s.PurchaseRepo.AddPurchaseTx(tx, ctx, p); err != nil {
+		return p, err
+	}
+
+	if offer.ID != 0 {
+		if err := s.OfferRepo.UseOfferTx(tx, ctx, offer.ID, p.ID); err != nil {
+			return p, err
+		}
+	}
+	// the other buyers cannot purchase at their agreed price anymore
+	if err := s.OfferRepo.CloseOffersTx(tx, ctx, item.ID); err != nil {
+		return p, err
+	}
+
+	if coupon.ID != 0 {
+		if err := s.CouponRepo.RedeemTx(tx, ctx, coupon, buyer.ID, p.ID, discount); err != nil {
+			if err == db.ErrCouponUsedUp {
+				return p, ErrCouponUsedUp
+			}
+			return p, err
+		}
+	}
+
+	// the seller is paid from escrow once the buyer receives the item
+	entries := []domain.LedgerEntry{
+		{Account: domain.LedgerAccountEscrow, Amount: price},
+	}
+	if pay != 0 {
+		entries = append(entries, domain.LedgerEntry{Account: domain.LedgerAccountWallet, UserID: buyer.ID, Amount: -pay})
+	}
+	if in.Points != 0 {
+		entries = append(entries, domain.LedgerEntry{Account: domain.LedgerAccountPoints, UserID: buyer.ID, Amount: -in.Points})
+	}
+	if discount != 0 {
+		entries = append(entries, domain.LedgerEntry{Account: domain.LedgerAccountPlatform, Amount: -discount})
+	}
+	if _, err := s.LedgerRepo.PostTx(tx, ctx, domain.LedgerKindPurchase, item.ID, entries); err != nil {
+		switch err {
+		case db.ErrInsufficientBalance:
+			return p, ErrInsufficientFunds
+		case db.ErrInsufficientPoints:
+			return p, ErrInsufficientPoints
+		}
+		return p, err
+	}
+	return p, nil
+}
+
+// getCouponTx looks up a coupon the user can apply to the price
+func (s *PurchaseService) getCouponTx(tx *sql.Tx, ctx context.Context, code string, userID int64, price int64) (domain.Coupon, error) {
+	coupon, err := s.CouponRepo.GetCouponByCodeTx(tx, ctx, code)
+	if err != nil {
+		// not found handling
+		if err == sql.ErrNoRows {
+			return coupon, ErrInvalidCoupon
+		}
+		return coupon, err
+	}
+
+	if coupon.ExpiresAt < time.Now().Format(db.TimeLayout) {
+		return coupon, ErrCouponExpired
+	}
+	if price < coupon.MinPrice {
+		return coupon, ErrCouponMinPrice
+	}
+	if coupon.UsageLimit != 0 && coupon.UsedCount >= coupon.UsageLimit {
+		return coupon, ErrCouponUsedUp
+	}
+	if coupon.PerUserLimit != 0 {
+		count, err := s.CouponRepo.CountRedemptionsTx(tx, ctx, coupon.ID, userID)
+		if err != nil {
+			return coupon, err
+		}
+		if count >= coupon.PerUserLimit {
+			return coupon, ErrCouponAlreadyUsed
+		}
+	}
+	return coupon, nil
+}
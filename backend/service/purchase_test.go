@@ -0,0 +1,268 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/db"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+func TestPurchaseConcurrent(t *testing.T) {
+	const buyers = 10
+	ctx := context.Background()
+	s := newTestService(t)
+	sellerID := addTestUser(t, s, 0, 0)
+	itemID := addTestItem(t, s, sellerID, 1000)
+	buyerIDs := make([]int64, buyers)
+	for i := range buyerIDs {
+		buyerIDs[i] = addTestUser(t, s, 1000, 0)
+	}
+
+	errs := make([]error, buyers)
+	var wg sync.WaitGroup
+	for i, buyerID := range buyerIDs {
+		wg.Add(1)
+		go func(i int, buyerID int64) {
+			defer wg.Done()
+			_, errs[i] = s.Purchase(ctx, PurchaseInput{ItemID: itemID, BuyerID: buyerID})
+		}(i, buyerID)
+	}
+	wg.Wait()
+
+	var succeeded, soldOut int
+	for _, err := range errs {
+		switch err {
+		case nil:
+			succeeded++
+		case ErrSoldOut:
+			soldOut++
+		default:
+			t.Errorf("Purchase() error = %v", err)
+		}
+	}
+	if succeeded != 1 || soldOut != buyers-1 {
+		t.Errorf("purchases succeeded = %d, sold out = %d, want 1 and %d", succeeded, soldOut, buyers-1)
+	}
+
+	if n := countRows(t, s.DB, "SELECT COUNT(*) FROM purchase WHERE item_id = ?", itemID); n != 1 {
+		t.Errorf("purchases = %d, want 1", n)
+	}
+	if n := countRows(t, s.DB, "SELECT COUNT(*) FROM ledger_journal WHERE kind = ? AND item_id = ?", domain.LedgerKindPurchase, itemID); n != 1 {
+		t.Errorf("purchase journals = %d, want 1", n)
+	}
+	if n := countRows(t, s.DB, "SELECT COUNT(*) FROM ledger_entry WHERE account = ? AND amount < 0", domain.LedgerAccountWallet); n != 1 {
+		t.Errorf("buyer debits = %d, want 1", n)
+	}
+	if escrow := countRows(t, s.DB, "SELECT SUM(amount) FROM ledger_entry WHERE account = ?", domain.LedgerAccountEscrow); escrow != 1000 {
+		t.Errorf("escrow = %d, want 1000", escrow)
+	}
+}
+
+// staleItemRepo reads every item as on sale, like a purchase which read the item before another one sold it
+type staleItemRepo struct {
+	db.ItemRepository
+}
+
+func (r staleItemRepo) GetItemTx(tx *sql.Tx, ctx context.Context, id int32) (domain.Item, error) {
+	item, err := r.ItemRepository.GetItemTx(tx, ctx, id)
+	item.Status = domain.ItemStatusOnSale
+	return item, err
+}
+
+func TestPurchase(t *testing.T) {
+	tests := []struct {
+		name string
+		// the buyer has balance and points, the item is listed at 1000
+		balance int64
+		points  int64
+		// offer is the agreed price of an accepted offer of the buyer, 0 for none. lowered is the price of the item after it.
+		offer   int64
+		lowered int64
+		// coupon is the amount of a coupon of the code COUPON, 0 for none
+		coupon int64
+		fees   domain.FeeSchedule
+		// reserved accepts an offer of someone else first
+		reserved bool
+		// sold sells the item to someone else first, and stale hides it from the purchase
+		sold  bool
+		stale bool
+		in    PurchaseInput
+
+		wantErr error
+		want    domain.Purchase
+		// the balance and points left to the buyer
+		wantBalance int64
+		wantPoints  int64
+	}{
+		{
+			name:        "list price",
+			balance:     1000,
+			want:        domain.Purchase{Price: 1000, SellerAmount: 1000},
+			wantBalance: 0,
+		},
+		{
+			name:        "accepted offer",
+			balance:     1000,
+			offer:       800,
+			want:        domain.Purchase{Price: 800, SellerAmount: 800},
+			wantBalance: 200,
+		},
+		{
+			name:        "price lowered below the offer",
+			balance:     1000,
+			offer:       800,
+			lowered:     700,
+			want:        domain.Purchase{Price: 700, SellerAmount: 700},
+			wantBalance: 300,
+		},
+		{
+			name:        "coupon",
+			balance:     1000,
+			coupon:      300,
+			in:          PurchaseInput{CouponCode: "COUPON"},
+			want:        domain.Purchase{Price: 1000, Discount: 300, SellerAmount: 1000},
+			wantBalance: 300,
+		},
+		{
+			name:        "points",
+			balance:     1000,
+			points:      500,
+			in:          PurchaseInput{Points: 200},
+			want:        domain.Purchase{Price: 1000, Points: 200, SellerAmount: 1000},
+			wantBalance: 200,
+			wantPoints:  300,
+		},
+		{
+			name:        "offer, coupon and points",
+			balance:     1000,
+			points:      100,
+			offer:       800,
+			coupon:      300,
+			in:          PurchaseInput{CouponCode: "COUPON", Points: 100},
+			want:        domain.Purchase{Price: 800, Discount: 300, Points: 100, SellerAmount: 800},
+			wantBalance: 600,
+		},
+		{
+			name:        "fee",
+			balance:     1000,
+			fees:        domain.FeeSchedule{Default: domain.FeeRule{RateBP: 1000, Flat: 20}},
+			want:        domain.Purchase{Price: 1000, Fee: domain.Fee{RateBP: 1000, Flat: 20, Amount: 120}, SellerAmount: 880},
+			wantBalance: 0,
+		},
+		{
+			name:        "insufficient balance",
+			balance:     999,
+			wantErr:     ErrInsufficientFunds,
+			wantBalance: 999,
+		},
+		{
+			name:        "points over the price",
+			balance:     1000,
+			points:      2000,
+			in:          PurchaseInput{Points: 1001},
+			wantErr:     ErrInvalidPoints,
+			wantBalance: 1000,
+			wantPoints:  2000,
+		},
+		{
+			name:        "insufficient points",
+			balance:     1000,
+			points:      100,
+			in:          PurchaseInput{Points: 200},
+			wantErr:     ErrInsufficientPoints,
+			wantBalance: 1000,
+			wantPoints:  100,
+		},
+		{
+			name:        "reserved for another buyer",
+			balance:     1000,
+			reserved:    true,
+			wantErr:     ErrReserved,
+			wantBalance: 1000,
+		},
+		{
+			name:        "sold out",
+			balance:     1000,
+			sold:        true,
+			wantErr:     ErrSoldOut,
+			wantBalance: 1000,
+		},
+		{
+			name:        "sold out after it was read",
+			balance:     1000,
+			sold:        true,
+			stale:       true,
+			wantErr:     ErrSoldOut,
+			wantBalance: 1000,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestService(t)
+			s.Fees = tt.fees
+			sellerID := addTestUser(t, s, 0, 0)
+			buyerID := addTestUser(t, s, tt.balance, tt.points)
+			itemID := addTestItem(t, s, sellerID, 1000)
+
+			if tt.offer != 0 {
+				addTestOffer(t, s, itemID, buyerID, sellerID, tt.offer)
+			}
+			if tt.lowered != 0 {
+				if _, err := s.DB.Exec("UPDATE items SET price = ? WHERE id = ?", tt.lowered, itemID); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.coupon != 0 {
+				if _, err := s.CouponRepo.AddCoupon(ctx, domain.Coupon{Code: "COUPON", DiscountType: domain.CouponDiscountAmount, DiscountValue: tt.coupon, ExpiresAt: "2099-01-01 00:00:00"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.reserved {
+				other := addTestUser(t, s, 1000, 0)
+				addTestOffer(t, s, itemID, other, sellerID, 800)
+			}
+			if tt.sold {
+				other := addTestUser(t, s, 1000, 0)
+				if _, err := s.Purchase(ctx, PurchaseInput{ItemID: itemID, BuyerID: other}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.stale {
+				s.ItemRepo = staleItemRepo{s.ItemRepo}
+			}
+
+			in := tt.in
+			in.ItemID, in.BuyerID = itemID, buyerID
+			p, err := s.Purchase(ctx, in)
+			if err != tt.wantErr {
+				t.Fatalf("Purchase() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				got := domain.Purchase{Price: p.Price, Discount: p.Discount, Points: p.Points, Fee: p.Fee, SellerAmount: p.SellerAmount}
+				if got != tt.want {
+					t.Errorf("Purchase() = %+v, want %+v", got, tt.want)
+				}
+				// the escrow holds the price, whoever paid it
+				if escrow := countRows(t, s.DB, "SELECT SUM(amount) FROM ledger_entry WHERE account = ?", domain.LedgerAccountEscrow); escrow != p.Price {
+					t.Errorf("escrow = %d, want %d", escrow, p.Price)
+				}
+			}
+
+			balance, err := s.LedgerRepo.GetBalance(ctx, domain.LedgerAccountWallet, buyerID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			points, err := s.LedgerRepo.GetBalance(ctx, domain.LedgerAccountPoints, buyerID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if balance != tt.wantBalance || points != tt.wantPoints {
+				t.Errorf("balance = %d, points = %d, want %d and %d", balance, points, tt.wantBalance, tt.wantPoints)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/1en0/mecari-build-hackathon-2023/backend/db"
+	"github.com/1en0/mecari-build-hackathon-2023/backend/domain"
+)
+
+// newTestService returns a PurchaseService on an empty DB in a temporary directory, without fees
+func newTestService(t *testing.T) *PurchaseService {
+	t.Helper()
+	sqlDB, err := db.OpenDB(context.Background(), filepath.Join(t.TempDir(), "test.sqlite3"), filepath.Join("..", "sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	return &PurchaseService{
+		DB:           sqlDB,
+		UserRepo:     db.NewUserRepository(sqlDB),
+		ItemRepo:     db.NewItemRepository(sqlDB),
+		PurchaseRepo: db.NewPurchaseRepository(sqlDB),
+		LedgerRepo:   db.NewLedgerRepository(sqlDB),
+		CouponRepo:   db.NewCouponRepository(sqlDB),
+		OfferRepo:    db.NewOfferRepository(sqlDB),
+	}
+}
+
+// addTestUser adds a user with the balance and the points
+func addTestUser(t *testing.T, s *PurchaseService, balance int64, points int64) int64 {
+	t.Helper()
+	ctx := context.Background()
+	userID, err := s.UserRepo.AddUser(ctx, domain.User{Name: "user", Password: "password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if balance != 0 {
+		postTestJournal(t, s, domain.LedgerKindTopUp, []domain.LedgerEntry{
+			{Account: domain.LedgerAccountExternal, Amount: -balance},
+			{Account: domain.LedgerAccountWallet, UserID: userID, Amount: balance},
+		})
+	}
+	if points != 0 {
+		postTestJournal(t, s, domain.LedgerKindPromotion, []domain.LedgerEntry{
+			{Account: domain.LedgerAccountPlatform, Amount: -points},
+			{Account: domain.LedgerAccountPoints, UserID: userID, Amount: points},
+		})
+	}
+	return userID
+}
+
+func postTestJournal(t *testing.T, s *PurchaseService, kind domain.LedgerKind, entries []domain.LedgerEntry) {
+	t.Helper()
+	ctx := context.Background()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err := s.LedgerRepo.PostTx(tx, ctx, kind, 0, entries); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// addTestItem lists an item of the seller on sale
+func addTestItem(t *testing.T, s *PurchaseService, sellerID int64, price int64) int32 {
+	t.Helper()
+	itemID, err := s.ItemRepo.AddItem(context.Background(), domain.Item{
+		Name:        "item",
+		Price:       price,
+		Description: "description",
+		CategoryID:  1,
+		UserID:      sellerID,
+		Status:      domain.ItemStatusOnSale,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return itemID
+}
+
+func countRows(t *testing.T, sqlDB *sql.DB, query string, args ...any) int64 {
+	t.Helper()
+	var n int64
+	if err := sqlDB.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// addTestOffer adds an offer of the buyer which the seller accepted at the price
+func addTestOffer(t *testing.T, s *PurchaseService, itemID int32, buyerID int64, sellerID int64, price int64) {
+	t.Helper()
+	ctx := context.Background()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	offer := domain.Offer{ItemID: itemID, BuyerID: buyerID, SellerID: sellerID, Price: price, ExpiresAt: "2099-01-01 00:00:00"}
+	if offer.ID, err = s.OfferRepo.AddOfferTx(tx, ctx, offer); err != nil {
+		t.Fatal(err)
+	}
+	offer.Status = domain.OfferStatusAccepted
+	if err := s.OfferRepo.UpdateOfferTx(tx, ctx, offer, domain.OfferStatusPending); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ItemRepo.UpdateItemStatusTx(tx, ctx, itemID, domain.ItemStatusOnSale, domain.ItemStatusReserved); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -4,3 +4,17 @@ DROP TABLE category;
 DROP TABLE status;
 DROP TABLE history;
 DROP TABLE purchase;
+DROP TABLE ledger_journal;
+DROP TABLE ledger_entry;
+DROP TABLE idempotency_keys;
+DROP TABLE coupons;
+DROP TABLE coupon_redemptions;
+DROP TABLE payouts;
+DROP TABLE offers;
+DROP TABLE item_images;
+DROP TABLE item_revisions;
+DROP TABLE item_revision_changes;
+DROP TABLE price_history;
+DROP TABLE import_jobs;
+DROP TABLE import_job_rows;
+DROP TABLE likes;
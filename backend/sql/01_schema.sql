@@ -9,15 +9,28 @@ CREATE TABLE IF NOT EXISTS items
     image       blob,
     status      integer,
     created_at  text NOT NULL DEFAULT (DATETIME('now', 'localtime')),
-    updated_at  text NOT NULL DEFAULT (DATETIME('now', 'localtime'))
+    updated_at  text NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+    deleted_at  text,
+    -- the likes and the views of the item, counted as the likes and history tables change
+    like_count  integer NOT NULL DEFAULT 0,
+    view_count  integer NOT NULL DEFAULT 0
 );
 
+-- lists are paged in the order of (updated_at, id), or of the key of their sort and id
+CREATE INDEX IF NOT EXISTS items_updated_idx ON items (updated_at, id);
+CREATE INDEX IF NOT EXISTS items_seller_idx ON items (seller_id, updated_at, id);
+CREATE INDEX IF NOT EXISTS items_created_idx ON items (created_at, id);
+CREATE INDEX IF NOT EXISTS items_sort_price_idx ON items (COALESCE(price, 0), id);
+CREATE INDEX IF NOT EXISTS items_likes_idx ON items (like_count, id);
+CREATE INDEX IF NOT EXISTS items_views_idx ON items (view_count, id);
+
 CREATE TABLE IF NOT EXISTS users
 (
-    id       integer primary key autoincrement,
-    name     varchar(50),
-    password binary(60),
-    balance  integer default 0
+    id         integer primary key autoincrement,
+    name       varchar(50),
+    password   binary(60),
+    balance    integer default 0,
+    deleted_at text
 );
 
 CREATE TABLE IF NOT EXISTS category
@@ -40,8 +53,229 @@ CREATE TABLE IF NOT EXISTS history
     accesss_at  text NOT NULL DEFAULT (DATETIME('now', 'localtime'))
 );
 
+-- covers the check of whether a user viewed an item before
+CREATE INDEX IF NOT EXISTS history_item_idx ON history (item_id, user_id);
+
 CREATE TABLE IF NOT EXISTS purchase
 (
-    item_id  integer primary key,
-    buyer_id integer
+    id                  integer primary key autoincrement,
+    item_id             integer NOT NULL,
+    buyer_id            integer NOT NULL,
+    seller_id           integer NOT NULL,
+    price               integer NOT NULL,
+    coupon_id           integer,
+    discount            integer NOT NULL DEFAULT 0,
+    points              integer NOT NULL DEFAULT 0,
+    fee_rate_bp         integer NOT NULL DEFAULT 0,
+    fee_flat            integer NOT NULL DEFAULT 0,
+    fee_amount          integer NOT NULL DEFAULT 0,
+    seller_amount       integer NOT NULL,
+    status              integer NOT NULL,
+    created_at          text NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+    updated_at          text NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+    shipped_at          text,
+    received_at         text,
+    completed_at        text,
+    cancelled_at        text,
+    cancel_requested_by integer,
+    cancel_reason       text
+);
+
+CREATE INDEX IF NOT EXISTS purchase_item_idx ON purchase (item_id);
+CREATE INDEX IF NOT EXISTS purchase_buyer_idx ON purchase (buyer_id);
+CREATE INDEX IF NOT EXISTS purchase_status_idx ON purchase (status, shipped_at);
+
+CREATE TABLE IF NOT EXISTS ledger_journal
+(
+    id         integer primary key autoincrement,
+    kind       varchar(20) NOT NULL,
+    item_id    integer,
+    created_at text NOT NULL DEFAULT (DATETIME('now', 'localtime'))
+);
+
+CREATE TABLE IF NOT EXISTS ledger_entry
+(
+    id         integer primary key autoincrement,
+    journal_id integer NOT NULL,
+    account    varchar(20) NOT NULL,
+    user_id    integer,
+    amount     integer NOT NULL,
+    created_at text NOT NULL DEFAULT (DATETIME('now', 'localtime'))
+);
+
+CREATE INDEX IF NOT EXISTS ledger_entry_account_idx ON ledger_entry (account, user_id, id);
+
+-- ledger rows are append only
+CREATE TRIGGER IF NOT EXISTS ledger_journal_no_update BEFORE UPDATE ON ledger_journal
+BEGIN
+    SELECT RAISE(ABORT, 'ledger is append only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS ledger_journal_no_delete BEFORE DELETE ON ledger_journal
+BEGIN
+    SELECT RAISE(ABORT, 'ledger is append only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS ledger_entry_no_update BEFORE UPDATE ON ledger_entry
+BEGIN
+    SELECT RAISE(ABORT, 'ledger is append only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS ledger_entry_no_delete BEFORE DELETE ON ledger_entry
+BEGIN
+    SELECT RAISE(ABORT, 'ledger is append only');
+END;
+
+CREATE TABLE IF NOT EXISTS idempotency_keys
+(
+    user_id      integer NOT NULL,
+    key          varchar(255) NOT NULL,
+    request_hash varchar(64) NOT NULL,
+    status       integer NOT NULL DEFAULT 0,
+    content_type text NOT NULL DEFAULT '',
+    body         blob,
+    created_at   text NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+    primary key (user_id, key)
+);
+
+CREATE TABLE IF NOT EXISTS coupons
+(
+    id             integer primary key autoincrement,
+    code           varchar(50) NOT NULL UNIQUE,
+    discount_type  varchar(20) NOT NULL,
+    discount_value integer NOT NULL,
+    max_discount   integer NOT NULL DEFAULT 0,
+    min_price      integer NOT NULL DEFAULT 0,
+    usage_limit    integer NOT NULL DEFAULT 0,
+    per_user_limit integer NOT NULL DEFAULT 0,
+    used_count     integer NOT NULL DEFAULT 0,
+    expires_at     text NOT NULL,
+    created_at     text NOT NULL DEFAULT (DATETIME('now', 'localtime'))
+);
+
+CREATE TABLE IF NOT EXISTS coupon_redemptions
+(
+    id          integer primary key autoincrement,
+    coupon_id   integer NOT NULL,
+    user_id     integer NOT NULL,
+    purchase_id integer NOT NULL,
+    discount    integer NOT NULL,
+    created_at  text NOT NULL DEFAULT (DATETIME('now', 'localtime'))
+);
+
+CREATE INDEX IF NOT EXISTS coupon_redemptions_coupon_idx ON coupon_redemptions (coupon_id, user_id);
+
+CREATE TABLE IF NOT EXISTS payouts
+(
+    id           integer primary key autoincrement,
+    user_id      integer NOT NULL,
+    amount       integer NOT NULL,
+    status       integer NOT NULL DEFAULT 1,
+    reference    varchar(255) NOT NULL DEFAULT '',
+    reason       text NOT NULL DEFAULT '',
+    -- the transfer the provider has not finished
+    provider_ref varchar(255) NOT NULL DEFAULT '',
+    created_at   text NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+    updated_at   text NOT NULL DEFAULT (DATETIME('now', 'localtime'))
+);
+
+CREATE INDEX IF NOT EXISTS payouts_user_idx ON payouts (user_id);
+CREATE INDEX IF NOT EXISTS payouts_status_idx ON payouts (status);
+
+CREATE TABLE IF NOT EXISTS offers
+(
+    id            integer primary key autoincrement,
+    item_id       integer NOT NULL,
+    buyer_id      integer NOT NULL,
+    seller_id     integer NOT NULL,
+    price         integer NOT NULL,
+    counter_price integer NOT NULL DEFAULT 0,
+    status        integer NOT NULL DEFAULT 1,
+    purchase_id   integer,
+    expires_at    text NOT NULL,
+    created_at    text NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+    updated_at    text NOT NULL DEFAULT (DATETIME('now', 'localtime'))
+);
+
+CREATE INDEX IF NOT EXISTS offers_item_idx ON offers (item_id, buyer_id, status);
+CREATE INDEX IF NOT EXISTS offers_status_idx ON offers (status, expires_at);
+
+CREATE TABLE IF NOT EXISTS item_images
+(
+    id         integer primary key autoincrement,
+    item_id    integer NOT NULL,
+    position   integer NOT NULL,
+    -- image_key locates the image in the image store, image is only set for images not moved there yet
+    image_key  text,
+    image      blob,
+    created_at text NOT NULL DEFAULT (DATETIME('now', 'localtime'))
+);
+
+CREATE INDEX IF NOT EXISTS item_images_item_idx ON item_images (item_id, position);
+
+-- the images of items from before item_images are moved by db.moveItemImages, after the data is loaded
+
+-- every edit of an item and the fields it changed
+CREATE TABLE IF NOT EXISTS item_revisions
+(
+    id         integer primary key autoincrement,
+    item_id    integer NOT NULL,
+    user_id    integer NOT NULL,
+    created_at text NOT NULL DEFAULT (DATETIME('now', 'localtime'))
+);
+
+CREATE INDEX IF NOT EXISTS item_revisions_item_idx ON item_revisions (item_id);
+
+CREATE TABLE IF NOT EXISTS item_revision_changes
+(
+    revision_id integer NOT NULL,
+    field       text NOT NULL,
+    old_value   text NOT NULL,
+    new_value   text NOT NULL
 );
+
+CREATE INDEX IF NOT EXISTS item_revision_changes_revision_idx ON item_revision_changes (revision_id);
+
+CREATE TABLE IF NOT EXISTS price_history
+(
+    id         integer primary key autoincrement,
+    item_id    integer NOT NULL,
+    old_price  integer NOT NULL,
+    new_price  integer NOT NULL,
+    created_at text NOT NULL DEFAULT (DATETIME('now', 'localtime'))
+);
+
+CREATE INDEX IF NOT EXISTS price_history_item_idx ON price_history (item_id);
+
+CREATE TABLE IF NOT EXISTS import_jobs
+(
+    id         integer primary key autoincrement,
+    user_id    integer NOT NULL,
+    status     integer NOT NULL,
+    total      integer NOT NULL,
+    processed  integer NOT NULL DEFAULT 0,
+    error      text NOT NULL DEFAULT '',
+    created_at text NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+    updated_at text NOT NULL DEFAULT (DATETIME('now', 'localtime'))
+);
+
+-- the result of every row once the job finished
+CREATE TABLE IF NOT EXISTS import_job_rows
+(
+    job_id  integer NOT NULL,
+    row_no  integer NOT NULL,
+    item_id integer,
+    error   text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS import_job_rows_job_idx ON import_job_rows (job_id, row_no);
+
+CREATE TABLE IF NOT EXISTS likes
+(
+    item_id    integer NOT NULL,
+    user_id    integer NOT NULL,
+    created_at text NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+    PRIMARY KEY (item_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS likes_user_idx ON likes (user_id);